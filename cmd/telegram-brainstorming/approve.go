@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"codex-brainstorming-telegram/internal/approval"
+	"codex-brainstorming-telegram/internal/telegramapi"
+	"codex-brainstorming-telegram/internal/telegrambrainstorm"
+	"codex-brainstorming-telegram/internal/telegramformat"
+)
+
+// Exit codes of the approve subcommand besides 0 (approved), 1 (runtime
+// failure or timeout) and 2 (usage error).
+const (
+	exitRejected = 3
+	exitRevise   = 4
+)
+
+const (
+	defaultApprovalQuestion = "请审阅以上计划：批准、拒绝，或提出修改意见。"
+	reviseCommentsPrompt    = "请回复具体的修改意见："
+)
+
+// approvalButtons are offered in this order; the index decides the outcome.
+var approvalButtons = []string{"✅ 批准", "❌ 拒绝", "✏️ 修改"}
+
+var approvalDecisions = []approval.Decision{approval.Approved, approval.Rejected, approval.Revise}
+
+func runApprove(parent context.Context, stdin io.Reader, stdout io.Writer, stderr io.Writer, args []string) int {
+	fs := flag.NewFlagSet("telegram-brainstorming approve", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	cf := addConfigFlags(fs)
+	envPath := cf.envPath
+	apiBase := fs.String("api-base", "https://api.telegram.org", "telegram API base URL")
+	overrideTimeout := fs.Duration("session-timeout", 0, "override TELEGRAM_REPLY_TIMEOUT")
+	cf.replyTimeout = overrideTimeout
+	planFile := fs.String("plan-file", "", "plan to approve (- reads stdin)")
+	question := fs.String("prompt", defaultApprovalQuestion, "question sent with the Approve/Reject/Revise buttons")
+	graceWindow := fs.Duration("grace-window", 0, graceWindowFlagUsage)
+	cf.reminders = fs.String("remind", "", remindFlagUsage)
+	markStatus := fs.Bool("mark-status", true, markStatusFlagUsage)
+	attachmentDir := fs.String("attachment-dir", "", "directory for screenshots or files sent as revise comments (default: "+defaultReceivedDirName+" next to --env)")
+	spool := fs.Bool("spool", false, spoolFlagUsage)
+	spoolFile := fs.String("spool-file", "", spoolFileFlagUsage)
+	brokerFlag := fs.String("broker", brokerAuto, brokerFlagUsage)
+	parseModeFlag := fs.String("parse-mode", "none", "plan formatting: none, markdownv2 or html")
+	asDocument := fs.Bool("plan-as-document", false, "upload the plan as a document instead of sending it as messages")
+	recordPath := fs.String("record", "", "approval log file (default: "+approval.DefaultFileName+" next to --env)")
+	verify := fs.Bool("verify", false, "only check the log for a signed approval of the plan; exit 0 if found, 1 otherwise")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *overrideTimeout < 0 {
+		fmt.Fprintln(stderr, "session-timeout must be >= 0")
+		return 2
+	}
+	if *graceWindow < 0 {
+		fmt.Fprintln(stderr, "grace-window must be >= 0")
+		return 2
+	}
+	if *planFile == "" {
+		fmt.Fprintln(stderr, "plan-file is required")
+		return 2
+	}
+	parseMode, err := telegramformat.ParseParseMode(*parseModeFlag)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	cfg, err := cf.load()
+	if err != nil {
+		fmt.Fprintf(stderr, "load config failed: %v\n", err)
+		return 2
+	}
+	if *recordPath == "" {
+		*recordPath = approval.DefaultPath(*envPath)
+	}
+
+	plan, err := readPlanFile(*planFile, stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	planHash := approval.HashPlan(plan)
+	key := approval.KeyFromToken(cfg.BotToken)
+
+	if *verify {
+		rec, err := approval.Verify(*recordPath, planHash, key)
+		if err != nil {
+			fmt.Fprintf(stderr, "校验失败：%v\n", err)
+			return 1
+		}
+		fmt.Fprintf(stderr, "校验通过：计划已由用户 %d 于 %s 批准。\n", rec.ApproverID, rec.DecidedAt.Format(time.RFC3339))
+		fmt.Fprintln(stdout, rec.Decision)
+		return 0
+	}
+
+	httpClient, err := buildHTTPClient(cfg.ProxyURL)
+	if err != nil {
+		fmt.Fprintf(stderr, "proxy config error: %v\n", err)
+		return 2
+	}
+
+	apiClient := telegramapi.NewClient(*apiBase, cfg.BotToken, httpClient)
+	defer printMigrationHint(stderr, apiClient, cfg.ChatID)
+	api, err := useBroker(apiClient, *brokerFlag, cfg.BotToken)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	conv, err := newConversation(api, cfg.ChatID)
+	if err != nil {
+		fmt.Fprintf(stderr, "会话失败：%v\n", err)
+		return 1
+	}
+
+	onUnrelated := spoolUnrelated(*spool, *spoolFile, *envPath)
+	transcriber := newTranscriber(cfg, stderr)
+	// approve has no --match flag, so only the broker default applies.
+	match, _ := resolveMatch("", sharesUpdates(api, cfg.ThreadID))
+	opts := promptOptions{
+		Options:        approvalButtons,
+		Match:          match,
+		OnUnrelated:    onUnrelated,
+		Transcriber:    transcriber,
+		GraceWindow:    *graceWindow,
+		Reminders:      cfg.Reminders.Delays(cfg.ReplyTimeout),
+		MarkStatus:     *markStatus,
+		ParseMode:      parseMode,
+		AllowedUserIDs: cfg.AllowedUserIDs,
+		ThreadID:       cfg.ThreadID,
+		Escalation:     escalationTargets(cfg),
+		OnEscalate:     printEscalation(stderr),
+	}
+	prompt := strings.TrimSpace(*question)
+	if *asDocument {
+		opts.Attachments = []telegrambrainstorm.Attachment{planAttachment(*planFile, plan)}
+	} else {
+		prompt = strings.TrimSpace(string(plan)) + "\n\n" + prompt
+	}
+
+	wait := cfg.ReplyTimeout + escalationWait(opts.Escalation)
+	ctx, cancel := context.WithTimeout(parent, 2*wait+30*time.Second)
+	defer cancel()
+
+	fmt.Fprintln(stderr, "等待审批：请前往 Telegram 查看计划并选择批准、拒绝或修改。")
+
+	result, err := conv.Ask(ctx, prompt, cfg.ReplyTimeout, opts)
+	if err != nil {
+		return approvalFailure(stderr, err)
+	}
+	decision, ok := decisionFor(result)
+	if !ok {
+		fmt.Fprintf(stderr, "会话失败：无法识别的审批结果 %q\n", result.NormalizedReply)
+		return 1
+	}
+
+	rec := approval.Record{
+		Decision:         decision,
+		PlanSHA256:       planHash,
+		ApproverID:       result.Sender.ID,
+		ApproverUsername: result.Sender.Username,
+		ChatID:           result.ChatID,
+		PromptMessageID:  result.PromptMessageID,
+		ReplyMessageID:   result.ReplyMessageID,
+		DecidedAt:        result.RepliedAt,
+	}
+	if rec.DecidedAt.IsZero() {
+		rec.DecidedAt = time.Now()
+	}
+
+	if decision == approval.Revise {
+		// Comments are asked for where the plan was answered.
+		commentConv, timeout, threadID, reminders := conv, cfg.ReplyTimeout, cfg.ThreadID, opts.Reminders
+		if t, ok := escalatedTo(opts.Escalation, result.ChatID); ok {
+			commentConv, err = newConversation(api, t.ChatID)
+			if err != nil {
+				return approvalFailure(stderr, err)
+			}
+			timeout, threadID, reminders = t.Timeout, 0, t.Reminders
+		}
+		comments, err := commentConv.Ask(ctx, reviseCommentsPrompt, timeout, promptOptions{
+			Match:          match,
+			AllowedUserIDs: cfg.AllowedUserIDs,
+			ThreadID:       threadID,
+			OnUnrelated:    onUnrelated,
+			Transcriber:    transcriber,
+			AttachmentDir:  receivedDir(*attachmentDir, *envPath),
+			GraceWindow:    *graceWindow,
+			Reminders:      reminders,
+			MarkStatus:     *markStatus,
+		})
+		if err != nil {
+			return approvalFailure(stderr, err)
+		}
+		rec.Comments = comments.NormalizedReply
+		for _, f := range comments.Files {
+			rec.Comments = strings.TrimSpace(rec.Comments + "\n" + f.Path)
+		}
+		rec.ReplyMessageID = comments.ReplyMessageID
+	}
+
+	if _, err := approval.Append(*recordPath, rec, key); err != nil {
+		fmt.Fprintf(stderr, "保存审批记录失败：%v\n", err)
+		return 1
+	}
+
+	fmt.Fprintln(stdout, decision)
+	switch decision {
+	case approval.Approved:
+		fmt.Fprintln(stderr, "审批通过：计划已批准，记录已写入。")
+		return 0
+	case approval.Rejected:
+		fmt.Fprintln(stderr, "审批未通过：计划被拒绝。")
+		return exitRejected
+	default:
+		fmt.Fprintln(stdout, rec.Comments)
+		fmt.Fprintln(stderr, "需要修改：已收到修改意见。")
+		return exitRevise
+	}
+}
+
+func decisionFor(result promptResult) (approval.Decision, bool) {
+	if len(result.Choices) != 1 {
+		return "", false
+	}
+	for i, label := range approvalButtons {
+		if result.Choices[0] == label {
+			return approvalDecisions[i], true
+		}
+	}
+	return "", false
+}
+
+func approvalFailure(stderr io.Writer, err error) int {
+	if errors.Is(err, telegrambrainstorm.ErrSessionTimeout) {
+		fmt.Fprintln(stderr, "会话超时：未在规定时间内完成审批")
+		return 1
+	}
+	fmt.Fprintf(stderr, "会话失败：%v\n", err)
+	printHint(stderr, err)
+	return 1
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"codex-brainstorming-telegram/internal/approval"
+	"codex-brainstorming-telegram/internal/telegramfake"
+)
+
+func writeApproveFixtures(t *testing.T) (envPath string, planPath string) {
+	t.Helper()
+
+	tmpDir := t.TempDir()
+	envPath = filepath.Join(tmpDir, ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=123\nTELEGRAM_REPLY_TIMEOUT=10s\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	planPath = filepath.Join(tmpDir, "plan.md")
+	if err := os.WriteFile(planPath, []byte("# Plan\n\n- refactor retry loop\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return envPath, planPath
+}
+
+func TestRunApproveWritesSignedRecord(t *testing.T) {
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	envPath, planPath := writeApproveFixtures(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		sent, err := fake.WaitForSent(ctx, 1)
+		if err != nil {
+			return
+		}
+		fake.InjectCallback(telegramfake.CallbackTap{ChatID: 123, MessageID: sent[0].MessageID, Data: "opt:0", FromID: 42})
+	}()
+
+	var stdout, stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"approve", "--env", envPath, "--api-base", srv.URL, "--plan-file", planPath})
+	if exitCode != 0 {
+		t.Fatalf("run() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+	if got := stdout.String(); got != "approved\n" {
+		t.Fatalf("stdout = %q, want approved", got)
+	}
+
+	sent := fake.SentMessages()
+	if len(sent) != 1 || !strings.Contains(sent[0].Text, "refactor retry loop") || !strings.HasSuffix(sent[0].Text, "✅ 已回答：✅ 批准") || sent[0].ReplyMarkup != nil {
+		t.Fatalf("SentMessages() = %+v, want plan marked approved with its buttons removed", sent)
+	}
+
+	plan, _ := os.ReadFile(planPath)
+	rec, err := approval.Verify(approval.DefaultPath(envPath), approval.HashPlan(plan), approval.KeyFromToken("token"))
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if rec.ApproverID != 42 || rec.PromptMessageID != sent[0].MessageID {
+		t.Fatalf("record = %+v", rec)
+	}
+
+	stdout.Reset()
+	if exitCode := run(ctx, &stdout, &stderr, []string{"approve", "--env", envPath, "--plan-file", planPath, "--verify"}); exitCode != 0 {
+		t.Fatalf("verify exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+}
+
+func TestRunApproveRejectExitCode(t *testing.T) {
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	envPath, planPath := writeApproveFixtures(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		if _, err := fake.WaitForSent(ctx, 1); err != nil {
+			return
+		}
+		// Free text such as "ok" must not count as a decision.
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: 123, Text: "ok", FromID: 42})
+		if _, err := fake.WaitForSent(ctx, 2); err != nil {
+			return
+		}
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: 123, Text: "2", FromID: 42})
+	}()
+
+	var stdout, stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"approve", "--env", envPath, "--api-base", srv.URL, "--plan-file", planPath})
+	if exitCode != exitRejected {
+		t.Fatalf("run() exitCode = %d, want %d, stderr = %s", exitCode, exitRejected, stderr.String())
+	}
+	if got := stdout.String(); got != "rejected\n" {
+		t.Fatalf("stdout = %q, want rejected", got)
+	}
+
+	if exitCode := run(ctx, &stdout, &stderr, []string{"approve", "--env", envPath, "--plan-file", planPath, "--verify"}); exitCode != 1 {
+		t.Fatalf("verify exitCode = %d, want 1", exitCode)
+	}
+}
+
+func TestRunApproveReviseCollectsComments(t *testing.T) {
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	envPath, planPath := writeApproveFixtures(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		sent, err := fake.WaitForSent(ctx, 2)
+		if err != nil {
+			return
+		}
+		fake.InjectCallback(telegramfake.CallbackTap{ChatID: 123, MessageID: sent[1].MessageID, Data: "opt:2", FromID: 42})
+		if _, err := fake.WaitForSent(ctx, 3); err != nil {
+			return
+		}
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: 123, Text: "split step 2", FromID: 42})
+	}()
+
+	var stdout, stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"approve", "--env", envPath, "--api-base", srv.URL, "--plan-file", planPath, "--plan-as-document"})
+	if exitCode != exitRevise {
+		t.Fatalf("run() exitCode = %d, want %d, stderr = %s", exitCode, exitRevise, stderr.String())
+	}
+	if got := stdout.String(); got != "revise\nsplit step 2\n" {
+		t.Fatalf("stdout = %q, want revise with comments", got)
+	}
+
+	sent := fake.SentMessages()
+	if sent[0].Document == nil || sent[0].Document.FileName != "plan.md" {
+		t.Fatalf("first message = %+v, want plan document", sent[0])
+	}
+	if sent[2].Text != reviseCommentsPrompt+"\n\n✅ 已回答：split step 2" {
+		t.Fatalf("third message = %q, want comments prompt", sent[2].Text)
+	}
+}
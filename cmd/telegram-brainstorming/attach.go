@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"codex-brainstorming-telegram/internal/telegramapi"
+	"codex-brainstorming-telegram/internal/telegrambrainstorm"
+)
+
+// defaultPlanPrompt is the approval question sent after --plan-file when no
+// prompt is given.
+const defaultPlanPrompt = "请查看上方附件中的执行计划，并回复是否批准执行。"
+
+const maxCaptionRunes = 200
+
+// loadAttachments reads --attach-file and --plan-file into documents sent
+// before the prompt. A plan file of "-" is read from stdin.
+func loadAttachments(attachFiles []string, planFile string, stdin io.Reader) ([]telegrambrainstorm.Attachment, error) {
+	var out []telegrambrainstorm.Attachment
+
+	if planFile != "" {
+		data, err := readPlanFile(planFile, stdin)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, planAttachment(planFile, data))
+	}
+
+	for _, path := range attachFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read attach file: %w", err)
+		}
+		out = append(out, telegrambrainstorm.Attachment{
+			File: telegramapi.InputFile{Name: filepath.Base(path), Data: data},
+		})
+	}
+
+	for _, att := range out {
+		if len(att.File.Data) > telegramapi.MaxUploadSize {
+			return nil, fmt.Errorf("%s is larger than the %d MB Telegram upload limit", att.File.Name, telegramapi.MaxUploadSize>>20)
+		}
+	}
+	return out, nil
+}
+
+// readPlanFile reads a plan from path, or from stdin when path is "-".
+func readPlanFile(path string, stdin io.Reader) ([]byte, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(io.LimitReader(stdin, telegramapi.MaxUploadSize+1))
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read plan file: %w", err)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, fmt.Errorf("plan file %s is empty", path)
+	}
+	return data, nil
+}
+
+func planAttachment(path string, data []byte) telegrambrainstorm.Attachment {
+	return telegrambrainstorm.Attachment{
+		File:    telegramapi.InputFile{Name: planFileName(path, data), Data: data},
+		Caption: planCaption(data),
+	}
+}
+
+// planFileName keeps a .md/.diff/.patch name and otherwise generates one,
+// so Telegram clients offer a viewer for the plan.
+func planFileName(path string, data []byte) string {
+	if path != "-" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".md", ".markdown", ".diff", ".patch":
+			return filepath.Base(path)
+		}
+	}
+	if looksLikeDiff(data) {
+		return "plan.diff"
+	}
+	return "plan.md"
+}
+
+func looksLikeDiff(data []byte) bool {
+	text := string(data)
+	return strings.HasPrefix(text, "diff --git ") ||
+		(strings.HasPrefix(text, "--- ") && strings.Contains(text, "\n+++ "))
+}
+
+// planCaption uses the plan's first non-empty line, without Markdown heading
+// marks, as the document caption.
+func planCaption(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#"))
+		if line == "" {
+			continue
+		}
+		if r := []rune(line); len(r) > maxCaptionRunes {
+			line = string(r[:maxCaptionRunes-1]) + "…"
+		}
+		return line
+	}
+	return ""
+}
+
+// attachmentDigest folds attachment contents into the session prompt hash,
+// so a new plan under the same session ID and prompt starts a new round.
+func attachmentDigest(attachments []telegrambrainstorm.Attachment) string {
+	if len(attachments) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	for _, att := range attachments {
+		h.Write([]byte(att.File.Name))
+		h.Write([]byte{0})
+		h.Write(att.File.Data)
+		h.Write([]byte{0})
+	}
+	return "\x00attachments:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// defaultReceivedDirName holds photo and document replies, next to the .env
+// file like the session and approval state.
+const defaultReceivedDirName = ".telegram-attachments"
+
+const attachmentDirFlagUsage = "directory for photo and document replies (default: " + defaultReceivedDirName + " next to --env)"
+
+func receivedDir(flagValue string, envPath string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return filepath.Join(filepath.Dir(envPath), defaultReceivedDirName)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"codex-brainstorming-telegram/internal/telegramapi"
+	"codex-brainstorming-telegram/internal/telegrambrainstorm"
+	"codex-brainstorming-telegram/internal/telegrambroker"
+)
+
+const (
+	brokerAuto = "auto"
+	brokerOff  = "off"
+)
+
+const brokerFlagUsage = "read updates from a local broker: auto (use the default socket when a broker is running), off, or a socket path"
+
+// errSharedMatchAny refuses --match any where every session sees every
+// message: one typed reply would answer all of them.
+var errSharedMatchAny = errors.New("match any is not allowed through a broker without TELEGRAM_THREAD_ID: sessions sharing the chat would all take the same message; use --match reply or give each session its own topic")
+
+// runBroker owns the getUpdates loop for one bot token and serves updates to
+// other telegram-brainstorming processes until interrupted.
+func runBroker(parent context.Context, stderr io.Writer, args []string) int {
+	fs := flag.NewFlagSet("telegram-brainstorming broker", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	cf := addConfigFlags(fs)
+	apiBase := fs.String("api-base", "https://api.telegram.org", "telegram API base URL")
+	socket := fs.String("socket", "", "Unix socket path (default: derived from the bot token in the temp directory)")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := cf.load()
+	if err != nil {
+		fmt.Fprintf(stderr, "load config failed: %v\n", err)
+		return 2
+	}
+	if *socket == "" {
+		*socket = telegrambroker.DefaultSocketPath(cfg.BotToken)
+	}
+
+	httpClient, err := buildHTTPClient(cfg.ProxyURL)
+	if err != nil {
+		fmt.Fprintf(stderr, "proxy config error: %v\n", err)
+		return 2
+	}
+	apiClient := telegramapi.NewClient(*apiBase, cfg.BotToken, httpClient)
+
+	ln, err := telegrambroker.Listen(*socket)
+	if err != nil {
+		fmt.Fprintf(stderr, "broker 启动失败：%v\n", err)
+		return 1
+	}
+	defer os.Remove(*socket)
+
+	ctx, stop := signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	broker := telegrambroker.New(apiClient)
+	broker.OnError = func(err error) {
+		fmt.Fprintf(stderr, "broker 拉取更新失败，稍后重试：%v\n", err)
+	}
+	server := &http.Server{Handler: broker, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		_ = server.Serve(ln)
+	}()
+	defer server.Close()
+
+	fmt.Fprintf(stderr, "broker 运行中：%s\n", *socket)
+	if err := broker.Run(ctx); err != nil {
+		fmt.Fprintf(stderr, "broker 已停止：%v\n", err)
+		printHint(stderr, err)
+		return 1
+	}
+	fmt.Fprintln(stderr, "broker 已停止。")
+	return 0
+}
+
+// sharesUpdates reports whether the session reads through a broker and has no
+// topic of its own. The broker hands every update to every session, so such
+// sessions can tell their answers apart only by what they reply to.
+func sharesUpdates(api promptAPI, threadID int64) bool {
+	routed, ok := api.(routedAPI)
+	if !ok || threadID != 0 {
+		return false
+	}
+	_, ok = routed.updates.(*telegrambroker.Client)
+	return ok
+}
+
+// resolveMatch turns a --match value into the session's mode. Left empty it
+// is any, or reply for sessions that share updates; an explicit any is
+// refused for those.
+func resolveMatch(mode string, shared bool) (telegrambrainstorm.MatchMode, error) {
+	switch {
+	case mode == "" && shared:
+		return telegrambrainstorm.MatchReply, nil
+	case mode == "":
+		return telegrambrainstorm.MatchAny, nil
+	case shared && telegrambrainstorm.MatchMode(mode) == telegrambrainstorm.MatchAny:
+		return "", errSharedMatchAny
+	}
+	return telegrambrainstorm.MatchMode(mode), nil
+}
+
+// useBroker routes GetUpdates through a running broker according to the
+// --broker flag. It returns apiClient unchanged when no broker is used.
+func useBroker(apiClient *telegramapi.Client, mode string, botToken string) (promptAPI, error) {
+	switch mode {
+	case brokerOff:
+		return apiClient, nil
+	case brokerAuto, "":
+		client := telegrambroker.NewClient(telegrambroker.DefaultSocketPath(botToken))
+		if !client.Available() {
+			return apiClient, nil
+		}
+		return routedAPI{Client: apiClient, updates: client}, nil
+	default:
+		client := telegrambroker.NewClient(mode)
+		if !client.Available() {
+			return nil, errors.New("no broker is listening on " + mode)
+		}
+		return routedAPI{Client: apiClient, updates: client}, nil
+	}
+}
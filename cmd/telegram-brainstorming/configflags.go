@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"codex-brainstorming-telegram/internal/config"
+)
+
+// configFlags are the command-line layer of the configuration, applied over
+// defaults, .env and the environment. Flags left empty fall through. There
+// is deliberately no token flag: command lines end up in process listings
+// and shell history.
+type configFlags struct {
+	envPath  *string
+	chatID   *string
+	threadID *string
+	proxyURL *string
+
+	// replyTimeout and reminders are registered by the commands that wait
+	// for answers, with their own usage text.
+	replyTimeout *time.Duration
+	reminders    *string
+}
+
+func addConfigFlags(fs *flag.FlagSet) *configFlags {
+	return &configFlags{
+		envPath:  fs.String("env", ".env", "path to .env file (optional when settings come from the environment)"),
+		chatID:   fs.String("chat-id", "", "override TELEGRAM_CHAT_ID"),
+		threadID: fs.String("thread-id", "", "override TELEGRAM_THREAD_ID"),
+		proxyURL: fs.String("proxy-url", "", "override TELEGRAM_PROXY_URL"),
+	}
+}
+
+func (f *configFlags) layers() config.Layers {
+	flags := map[string]string{
+		"TELEGRAM_CHAT_ID":   *f.chatID,
+		"TELEGRAM_THREAD_ID": *f.threadID,
+		"TELEGRAM_PROXY_URL": *f.proxyURL,
+	}
+	if f.replyTimeout != nil && *f.replyTimeout > 0 {
+		flags["TELEGRAM_REPLY_TIMEOUT"] = f.replyTimeout.String()
+	}
+	if f.reminders != nil {
+		flags["TELEGRAM_REMINDERS"] = *f.reminders
+	}
+	return config.Layers{EnvFile: *f.envPath, Flags: flags}
+}
+
+func (f *configFlags) load() (config.TelegramConfig, error) {
+	return config.Load(f.layers())
+}
+
+// runConfig implements "config show": the effective settings, which layer
+// each came from, and whether they are valid. Secrets are redacted.
+func runConfig(stdout io.Writer, stderr io.Writer, args []string) int {
+	if len(args) == 0 || args[0] != "show" {
+		fmt.Fprintln(stderr, "usage: telegram-brainstorming config show [flags]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("telegram-brainstorming config show", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	cf := addConfigFlags(fs)
+	cf.replyTimeout = fs.Duration("session-timeout", 0, "override TELEGRAM_REPLY_TIMEOUT")
+	cf.reminders = fs.String("remind", "", "override TELEGRAM_REMINDERS")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "unexpected argument %q\n", fs.Arg(0))
+		return 2
+	}
+
+	r, err := config.Resolve(cf.layers())
+	if err != nil {
+		fmt.Fprintf(stderr, "load config failed: %v\n", err)
+		return 2
+	}
+
+	envState := "found"
+	if !r.EnvFileFound {
+		envState = "not found"
+	}
+	fmt.Fprintf(stdout, "# env file: %s (%s)\n", r.EnvFile, envState)
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	for _, s := range r.Settings() {
+		value := s.Redacted()
+		if value == "" {
+			value = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Key, value, s.Source)
+	}
+	if err := tw.Flush(); err != nil {
+		fmt.Fprintf(stderr, "write config failed: %v\n", err)
+		return 1
+	}
+
+	if _, err := r.Config(); err != nil {
+		fmt.Fprintf(stderr, "配置无效：%v\n", err)
+		return 1
+	}
+	fmt.Fprintln(stderr, "配置有效。")
+	return 0
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"codex-brainstorming-telegram/internal/config"
+	"codex-brainstorming-telegram/internal/telegramapi"
+	"codex-brainstorming-telegram/internal/telegrambrainstorm"
+	"codex-brainstorming-telegram/internal/telegramformat"
+	"codex-brainstorming-telegram/internal/transcribe"
+)
+
+const maxConversationLine = 1 << 20
+
+type conversationRequest struct {
+	ID            string   `json:"id,omitempty"`
+	Prompt        string   `json:"prompt"`
+	Options       []string `json:"options,omitempty"`
+	MultiSelect   bool     `json:"multi_select,omitempty"`
+	AllowFreeText bool     `json:"allow_free_text,omitempty"`
+	Match         string   `json:"match,omitempty"`
+	ParseMode     string   `json:"parse_mode,omitempty"`
+	Timeout       string   `json:"timeout,omitempty"`
+}
+
+type conversationReply struct {
+	ID              string     `json:"id,omitempty"`
+	ChatID          string     `json:"chat_id,omitempty"`
+	RawReply        string     `json:"raw_reply,omitempty"`
+	NormalizedReply string     `json:"normalized_reply,omitempty"`
+	Choices         []string   `json:"choices,omitempty"`
+	PromptMessageID int64      `json:"prompt_message_id,omitempty"`
+	ReplyMessageID  int64      `json:"reply_message_id,omitempty"`
+	Transcribed     bool       `json:"transcribed,omitempty"`
+	Files           []jsonFile `json:"files,omitempty"`
+	Corrected       bool       `json:"corrected,omitempty"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// roundDefaults apply to request lines that do not set the field themselves.
+type roundDefaults struct {
+	timeout        time.Duration
+	match          telegrambrainstorm.MatchMode
+	sharedUpdates  bool // rounds read through a broker without a topic
+	parseMode      telegramformat.ParseMode
+	allowedUserIDs []int64
+	threadID       int64
+	onUnrelated    func([]telegramapi.Update) error
+	transcriber    transcribe.Transcriber
+	attachmentDir  string
+	graceWindow    time.Duration
+	reminders      config.ReminderSchedule
+	escalation     []telegrambrainstorm.EscalationTarget
+	onEscalate     func(chatID string)
+	markStatus     bool
+	transcript     *transcriptRecorder
+}
+
+type asker interface {
+	Ask(ctx context.Context, prompt string, timeout time.Duration, opts promptOptions) (promptResult, error)
+}
+
+var newConversation = func(api promptAPI, chatID string) (asker, error) {
+	return telegrambrainstorm.NewConversation(api, chatID)
+}
+
+func runConversation(parent context.Context, stdin io.Reader, stdout io.Writer, stderr io.Writer, args []string) int {
+	fs := flag.NewFlagSet("telegram-brainstorming conversation", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	cf := addConfigFlags(fs)
+	envPath := cf.envPath
+	apiBase := fs.String("api-base", "https://api.telegram.org", "telegram API base URL")
+	overrideTimeout := fs.Duration("session-timeout", 0, "override TELEGRAM_REPLY_TIMEOUT for rounds without their own timeout")
+	cf.replyTimeout = overrideTimeout
+	matchMode := fs.String("match", "", "default "+matchFlagUsage)
+	graceWindow := fs.Duration("grace-window", 0, graceWindowFlagUsage)
+	cf.reminders = fs.String("remind", "", remindFlagUsage)
+	markStatus := fs.Bool("mark-status", true, markStatusFlagUsage)
+	attachmentDir := fs.String("attachment-dir", "", attachmentDirFlagUsage)
+	spool := fs.Bool("spool", false, spoolFlagUsage)
+	spoolFile := fs.String("spool-file", "", spoolFileFlagUsage)
+	brokerFlag := fs.String("broker", brokerAuto, brokerFlagUsage)
+	parseModeFlag := fs.String("parse-mode", "none", "default prompt formatting for rounds: none, markdownv2 or html")
+	transcriptID := fs.String("transcript", "", "transcript ID: append every round to its transcript (see the export subcommand)")
+	sessionDir := fs.String("session-dir", "", "session state directory (default: .telegram-sessions next to --env)")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *overrideTimeout < 0 {
+		fmt.Fprintln(stderr, "session-timeout must be >= 0")
+		return 2
+	}
+	if *graceWindow < 0 {
+		fmt.Fprintln(stderr, "grace-window must be >= 0")
+		return 2
+	}
+	if *matchMode != "" && !isValidMatchMode(*matchMode) {
+		fmt.Fprintln(stderr, "match must be any or reply")
+		return 2
+	}
+	parseMode, err := telegramformat.ParseParseMode(*parseModeFlag)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	transcript, err := newTranscriptRecorder(*sessionDir, *envPath, *transcriptID, stderr)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	cfg, err := cf.load()
+	if err != nil {
+		fmt.Fprintf(stderr, "load config failed: %v\n", err)
+		return 2
+	}
+
+	httpClient, err := buildHTTPClient(cfg.ProxyURL)
+	if err != nil {
+		fmt.Fprintf(stderr, "proxy config error: %v\n", err)
+		return 2
+	}
+
+	apiClient := telegramapi.NewClient(*apiBase, cfg.BotToken, httpClient)
+	defer printMigrationHint(stderr, apiClient, cfg.ChatID)
+	api, err := useBroker(apiClient, *brokerFlag, cfg.BotToken)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	shared := sharesUpdates(api, cfg.ThreadID)
+	match, err := resolveMatch(*matchMode, shared)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	conv, err := newConversation(api, cfg.ChatID)
+	if err != nil {
+		fmt.Fprintf(stderr, "会话失败：%v\n", err)
+		return 1
+	}
+
+	defaults := roundDefaults{
+		timeout:        cfg.ReplyTimeout,
+		match:          match,
+		sharedUpdates:  shared,
+		parseMode:      parseMode,
+		allowedUserIDs: cfg.AllowedUserIDs,
+		threadID:       cfg.ThreadID,
+		onUnrelated:    spoolUnrelated(*spool, *spoolFile, *envPath),
+		transcriber:    newTranscriber(cfg, stderr),
+		attachmentDir:  receivedDir(*attachmentDir, *envPath),
+		graceWindow:    *graceWindow,
+		reminders:      cfg.Reminders,
+		escalation:     escalationTargets(cfg),
+		onEscalate:     printEscalation(stderr),
+		markStatus:     *markStatus,
+		transcript:     transcript,
+	}
+
+	fmt.Fprintln(stderr, "对话模式运行中：从 stdin 逐行读取问题，请前往 Telegram 查看并回复。")
+
+	enc := json.NewEncoder(stdout)
+	scanner := bufio.NewScanner(stdin)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxConversationLine)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		reply, fatal := askConversationRound(parent, conv, line, defaults)
+		if err := enc.Encode(reply); err != nil {
+			fmt.Fprintf(stderr, "write reply failed: %v\n", err)
+			return 1
+		}
+		if fatal != nil {
+			fmt.Fprintf(stderr, "会话失败：%v\n", fatal)
+			return 1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(stderr, "read stdin failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintln(stderr, "对话结束：stdin 已关闭。")
+	return 0
+}
+
+// askConversationRound runs one request line. Request problems and timeouts
+// are reported in the reply line; the returned error is set only when the
+// conversation cannot continue.
+func askConversationRound(parent context.Context, conv asker, line string, defaults roundDefaults) (conversationReply, error) {
+	var req conversationRequest
+	if err := json.Unmarshal([]byte(line), &req); err != nil {
+		return conversationReply{Error: fmt.Sprintf("invalid request: %v", err)}, nil
+	}
+
+	reply := conversationReply{ID: req.ID}
+	prompt := strings.TrimSpace(req.Prompt)
+	if prompt == "" {
+		reply.Error = "invalid request: prompt is required"
+		return reply, nil
+	}
+
+	if err := telegrambrainstorm.ValidateOptions(req.Options); err != nil {
+		reply.Error = fmt.Sprintf("invalid request: %v", err)
+		return reply, nil
+	}
+
+	timeout := defaults.timeout
+	if req.Timeout != "" {
+		d, err := time.ParseDuration(req.Timeout)
+		if err != nil || d <= 0 {
+			reply.Error = fmt.Sprintf("invalid request: timeout %q must be a positive duration", req.Timeout)
+			return reply, nil
+		}
+		timeout = d
+	}
+
+	match := defaults.match
+	if req.Match != "" {
+		if !isValidMatchMode(req.Match) {
+			reply.Error = "invalid request: match must be any or reply"
+			return reply, nil
+		}
+		m, err := resolveMatch(req.Match, defaults.sharedUpdates)
+		if err != nil {
+			reply.Error = fmt.Sprintf("invalid request: %v", err)
+			return reply, nil
+		}
+		match = m
+	}
+
+	parseMode := defaults.parseMode
+	if req.ParseMode != "" {
+		mode, err := telegramformat.ParseParseMode(req.ParseMode)
+		if err != nil {
+			reply.Error = fmt.Sprintf("invalid request: %v", err)
+			return reply, nil
+		}
+		parseMode = mode
+	}
+
+	ctx, cancel := context.WithTimeout(parent, timeout+escalationWait(defaults.escalation)+30*time.Second)
+	defer cancel()
+
+	result, err := conv.Ask(ctx, prompt, timeout, promptOptions{
+		Options:        req.Options,
+		MultiSelect:    req.MultiSelect,
+		AllowFreeText:  req.AllowFreeText,
+		Match:          match,
+		ParseMode:      parseMode,
+		AllowedUserIDs: defaults.allowedUserIDs,
+		ThreadID:       defaults.threadID,
+		OnUnrelated:    defaults.onUnrelated,
+		Transcriber:    defaults.transcriber,
+		AttachmentDir:  defaults.attachmentDir,
+		GraceWindow:    defaults.graceWindow,
+		Reminders:      defaults.reminders.Delays(timeout),
+		MarkStatus:     defaults.markStatus,
+		Escalation:     defaults.escalation,
+		OnEscalate:     defaults.onEscalate,
+	})
+	defaults.transcript.record(prompt, req.Options, result, err)
+	if err != nil {
+		if errors.Is(err, telegrambrainstorm.ErrSessionTimeout) {
+			reply.Error = "timeout"
+			return reply, nil
+		}
+		reply.Error = err.Error()
+		return reply, err
+	}
+
+	reply.ChatID = result.ChatID
+	reply.RawReply = result.RawReply
+	reply.NormalizedReply = result.NormalizedReply
+	reply.Choices = result.Choices
+	reply.PromptMessageID = result.PromptMessageID
+	reply.ReplyMessageID = result.ReplyMessageID
+	reply.Transcribed = result.Transcribed
+	reply.Files = jsonFiles(result.Files)
+	reply.Corrected = result.Corrected
+	return reply, nil
+}
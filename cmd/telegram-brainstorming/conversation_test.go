@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"codex-brainstorming-telegram/internal/sessionstore"
+	"codex-brainstorming-telegram/internal/telegrambrainstorm"
+)
+
+type fakeAsker struct {
+	prompts []string
+	opts    []promptOptions
+	results []promptResult
+	errs    []error
+}
+
+func (f *fakeAsker) Ask(_ context.Context, prompt string, _ time.Duration, opts promptOptions) (promptResult, error) {
+	i := len(f.prompts)
+	f.prompts = append(f.prompts, prompt)
+	f.opts = append(f.opts, opts)
+	return f.results[i], f.errs[i]
+}
+
+func TestRunConversationAnswersEachLine(t *testing.T) {
+	tmpDir := t.TempDir()
+	envPath := filepath.Join(tmpDir, ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=123\nTELEGRAM_REPLY_TIMEOUT=1m\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	conv := &fakeAsker{
+		results: []promptResult{
+			{RawReply: "B", NormalizedReply: "B", PromptMessageID: 10, ReplyMessageID: 11},
+			{},
+		},
+		errs: []error{nil, telegrambrainstorm.ErrSessionTimeout},
+	}
+	orig := newConversation
+	newConversation = func(_ promptAPI, chatID string) (asker, error) {
+		if chatID != "123" {
+			t.Fatalf("chatID = %q, want 123", chatID)
+		}
+		return conv, nil
+	}
+	t.Cleanup(func() {
+		newConversation = orig
+	})
+
+	stdin := strings.NewReader(strings.Join([]string{
+		`{"id":"q1","prompt":"A/B?","options":["A","B"],"multi_select":true,"match":"reply"}`,
+		`not json`,
+		``,
+		`{"id":"q2","prompt":"1/2?","timeout":"10s"}`,
+	}, "\n"))
+	var stdout, stderr bytes.Buffer
+	exitCode := runConversation(context.Background(), stdin, &stdout, &stderr, []string{"--env", envPath, "--transcript", "conv-1"})
+	if exitCode != 0 {
+		t.Fatalf("runConversation() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+
+	if got := strings.Join(conv.prompts, "|"); got != "A/B?|1/2?" {
+		t.Fatalf("prompts = %q", got)
+	}
+	if conv.opts[0].Match != telegrambrainstorm.MatchReply || len(conv.opts[0].Options) != 2 || !conv.opts[0].MultiSelect {
+		t.Fatalf("first round opts = %+v", conv.opts[0])
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("stdout lines = %q, want 3", lines)
+	}
+	var replies [3]conversationReply
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &replies[i]); err != nil {
+			t.Fatalf("Unmarshal(%q) error = %v", line, err)
+		}
+	}
+	if replies[0].ID != "q1" || replies[0].NormalizedReply != "B" || replies[0].ReplyMessageID != 11 {
+		t.Fatalf("reply[0] = %+v", replies[0])
+	}
+	if !strings.HasPrefix(replies[1].Error, "invalid request") {
+		t.Fatalf("reply[1] = %+v, want invalid request", replies[1])
+	}
+	if replies[2].ID != "q2" || replies[2].Error != "timeout" {
+		t.Fatalf("reply[2] = %+v, want timeout", replies[2])
+	}
+
+	rounds, err := sessionstore.New(sessionstore.DefaultDir(envPath)).Transcript("conv-1")
+	if err != nil {
+		t.Fatalf("Transcript() error = %v", err)
+	}
+	if len(rounds) != 2 || rounds[0].Prompt != "A/B?" || rounds[0].NormalizedReply != "B" || rounds[1].Status != sessionstore.StatusTimeout {
+		t.Fatalf("transcript = %+v, want the answered and the timed-out round", rounds)
+	}
+}
+
+func TestAskConversationRoundRejectsBlankOptionWithoutEndingConversation(t *testing.T) {
+	// Ask is never reached: the fake has no result to return.
+	conv := &fakeAsker{}
+	reply, fatal := askConversationRound(context.Background(), conv, `{"id":"q1","prompt":"x","options":["A"," "]}`, roundDefaults{timeout: time.Minute})
+	if fatal != nil {
+		t.Fatalf("askConversationRound() fatal = %v, want the conversation to continue", fatal)
+	}
+	if reply.ID != "q1" || reply.Error != "invalid request: options must not be empty" {
+		t.Fatalf("reply = %+v, want invalid request", reply)
+	}
+	if len(conv.prompts) != 0 {
+		t.Fatalf("prompts = %q, want none sent", conv.prompts)
+	}
+}
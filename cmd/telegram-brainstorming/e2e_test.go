@@ -0,0 +1,860 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"codex-brainstorming-telegram/internal/sessionstore"
+	"codex-brainstorming-telegram/internal/telegramapi"
+	"codex-brainstorming-telegram/internal/telegramfake"
+)
+
+func TestRunEndToEndAgainstFakeServer(t *testing.T) {
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	tmpDir := t.TempDir()
+	envPath := filepath.Join(tmpDir, ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=123\nTELEGRAM_REPLY_TIMEOUT=10s\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	// Stale message sent before the prompt must not be taken as the answer.
+	fake.InjectMessage(telegramfake.UserMessage{ChatID: 123, Text: "stale"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		sent, err := fake.WaitForSent(ctx, 1)
+		if err != nil {
+			return
+		}
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: 123, Text: " B ", ReplyToMessageID: sent[0].MessageID})
+	}()
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"--env", envPath, "--api-base", srv.URL, "--match", "reply", "A/B?"})
+	if exitCode != 0 {
+		t.Fatalf("run() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+	if got := stdout.String(); got != "B\n" {
+		t.Fatalf("stdout = %q, want B", got)
+	}
+
+	sent := fake.SentMessages()
+	if len(sent) != 1 || sent[0].ChatID != 123 || sent[0].Text != "A/B?\n\n✅ 已回答：B" {
+		t.Fatalf("SentMessages() = %+v", sent)
+	}
+}
+
+func TestRunSplitsLongMarkdownPromptEndToEnd(t *testing.T) {
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	envPath := filepath.Join(t.TempDir(), ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=123\nTELEGRAM_REPLY_TIMEOUT=10s\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		sent, err := fake.WaitForSent(ctx, 1)
+		if err != nil {
+			return
+		}
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: 123, Text: "ok", ReplyToMessageID: sent[len(sent)-1].MessageID})
+	}()
+
+	step := "- " + strings.Repeat("refactor the retry loop. ", 40)
+	plan := "## Plan\n\n" + strings.Repeat(step+"\n\n", 12) + "Approve?"
+
+	var stdout, stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"--env", envPath, "--api-base", srv.URL, "--parse-mode", "markdownv2", "--prompt", plan})
+	if exitCode != 0 {
+		t.Fatalf("run() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+
+	sent := fake.SentMessages()
+	if len(sent) < 3 {
+		t.Fatalf("SentMessages() = %d messages, want the plan split", len(sent))
+	}
+	for i, msg := range sent {
+		if msg.ParseMode != "MarkdownV2" {
+			t.Fatalf("message %d parse_mode = %q, want MarkdownV2", i, msg.ParseMode)
+		}
+		if !strings.HasPrefix(msg.Text, fmt.Sprintf("\\(%d/%d\\)", i+1, len(sent))) {
+			t.Fatalf("message %d = %.30q, want chunk marker", i, msg.Text)
+		}
+	}
+}
+
+func TestRunPlanFileSendsDocumentBeforePrompt(t *testing.T) {
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	tmpDir := t.TempDir()
+	envPath := filepath.Join(tmpDir, ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=123\nTELEGRAM_REPLY_TIMEOUT=10s\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	diff := "diff --git a/x.go b/x.go\n--- a/x.go\n+++ b/x.go\n@@ -1 +1 @@\n-old\n+new\n"
+	planPath := filepath.Join(tmpDir, "changes.txt")
+	if err := os.WriteFile(planPath, []byte(diff), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	notesPath := filepath.Join(tmpDir, "notes.txt")
+	if err := os.WriteFile(notesPath, []byte("context"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		if _, err := fake.WaitForSent(ctx, 3); err != nil {
+			return
+		}
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: 123, Text: "approve"})
+	}()
+
+	var stdout, stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"--env", envPath, "--api-base", srv.URL, "--plan-file", planPath, "--attach-file", notesPath})
+	if exitCode != 0 {
+		t.Fatalf("run() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+	if got := stdout.String(); got != "approve\n" {
+		t.Fatalf("stdout = %q, want approve", got)
+	}
+
+	sent := fake.SentMessages()
+	if len(sent) != 3 {
+		t.Fatalf("SentMessages() = %+v, want plan, attachment, prompt", sent)
+	}
+	if sent[0].Document == nil || sent[0].Document.FileName != "plan.diff" || string(sent[0].Document.Data) != diff {
+		t.Fatalf("plan document = %+v", sent[0].Document)
+	}
+	if sent[0].Text != "diff --git a/x.go b/x.go" {
+		t.Fatalf("plan caption = %q", sent[0].Text)
+	}
+	if sent[1].Document == nil || sent[1].Document.FileName != "notes.txt" {
+		t.Fatalf("attachment = %+v", sent[1].Document)
+	}
+	if sent[2].Document != nil || sent[2].Text != defaultPlanPrompt+"\n\n✅ 已回答：approve" {
+		t.Fatalf("prompt = %+v, want default approval question", sent[2])
+	}
+}
+
+func TestRunIgnoresUnlistedGroupMembers(t *testing.T) {
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	envPath := filepath.Join(t.TempDir(), ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=-100123\nTELEGRAM_REPLY_TIMEOUT=10s\nTELEGRAM_ALLOWED_USER_IDS=42\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		if _, err := fake.WaitForSent(ctx, 1); err != nil {
+			return
+		}
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: -100123, Text: "stranger", FromID: 7})
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: -100123, Text: "other bot", FromID: 42, IsBot: true})
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: -100123, Text: "owner", FromID: 42})
+	}()
+
+	var stdout, stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"--env", envPath, "--api-base", srv.URL, "A/B?"})
+	if exitCode != 0 {
+		t.Fatalf("run() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+	if got := stdout.String(); got != "owner\n" {
+		t.Fatalf("stdout = %q, want owner", got)
+	}
+}
+
+func TestRunForumTopicEndToEnd(t *testing.T) {
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	envPath := filepath.Join(t.TempDir(), ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=-100123\nTELEGRAM_REPLY_TIMEOUT=10s\nTELEGRAM_THREAD_ID=9\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		if _, err := fake.WaitForSent(ctx, 1); err != nil {
+			return
+		}
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: -100123, Text: "other repo", ThreadID: 8})
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: -100123, Text: "general"})
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: -100123, Text: "this repo", ThreadID: 9})
+	}()
+
+	var stdout, stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"--env", envPath, "--api-base", srv.URL, "A/B?"})
+	if exitCode != 0 {
+		t.Fatalf("run() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+	if got := stdout.String(); got != "this repo\n" {
+		t.Fatalf("stdout = %q, want the reply from topic 9", got)
+	}
+	if sent := fake.SentMessages(); len(sent) != 1 || sent[0].ThreadID != 9 {
+		t.Fatalf("SentMessages() = %+v, want prompt in topic 9", sent)
+	}
+}
+
+func TestRunWebhookModeEndToEnd(t *testing.T) {
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	listenAddr := ln.Addr().String()
+	ln.Close()
+
+	tmpDir := t.TempDir()
+	envPath := filepath.Join(tmpDir, ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=123\nTELEGRAM_REPLY_TIMEOUT=10s\nTELEGRAM_WEBHOOK_SECRET=hook-secret\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		if _, err := fake.WaitForSent(ctx, 1); err != nil {
+			return
+		}
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: 123, Text: "A"})
+	}()
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{
+		"--env", envPath,
+		"--api-base", srv.URL,
+		"--webhook-url", "http://" + listenAddr + "/hook",
+		"--webhook-listen", listenAddr,
+		"A/B?",
+	})
+	if exitCode != 0 {
+		t.Fatalf("run() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+	if got := stdout.String(); got != "A\n" {
+		t.Fatalf("stdout = %q, want A", got)
+	}
+
+	info, err := telegramapi.NewClient(srv.URL, "token", srv.Client()).GetWebhookInfo(context.Background())
+	if err != nil {
+		t.Fatalf("GetWebhookInfo() error = %v", err)
+	}
+	if info.URL != "" {
+		t.Fatalf("webhook URL after run = %q, want deleted", info.URL)
+	}
+}
+
+func TestRunSharesBrokerBetweenSessions(t *testing.T) {
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	tmpDir := t.TempDir()
+	envPath := filepath.Join(tmpDir, ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=123\nTELEGRAM_REPLY_TIMEOUT=10s\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	// Unix socket paths are short; t.TempDir() can exceed the limit.
+	sockDir, err := os.MkdirTemp("", "tgb")
+	if err != nil {
+		t.Fatalf("MkdirTemp() error = %v", err)
+	}
+	defer os.RemoveAll(sockDir)
+	socket := filepath.Join(sockDir, "broker.sock")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	brokerCtx, stopBroker := context.WithCancel(ctx)
+	brokerDone := make(chan int, 1)
+	go func() {
+		var stderr bytes.Buffer
+		brokerDone <- runBroker(brokerCtx, &stderr, []string{"--env", envPath, "--api-base", srv.URL, "--socket", socket})
+	}()
+	for {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("broker did not start")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	go func() {
+		sent, err := fake.WaitForSent(ctx, 2)
+		if err != nil {
+			return
+		}
+		for _, m := range sent {
+			fake.InjectMessage(telegramfake.UserMessage{ChatID: 123, Text: "answer to " + m.Text, ReplyToMessageID: m.MessageID})
+		}
+	}()
+
+	type outcome struct {
+		exitCode int
+		stdout   string
+		stderr   string
+	}
+	// Without a topic per session, both default to --match reply, so each
+	// takes only the reply to its own prompt.
+	results := make(chan outcome, 2)
+	for _, prompt := range []string{"first?", "second?"} {
+		go func() {
+			var stdout, stderr bytes.Buffer
+			code := run(ctx, &stdout, &stderr, []string{"--env", envPath, "--api-base", srv.URL, "--broker", socket, prompt})
+			results <- outcome{code, stdout.String(), stderr.String()}
+		}()
+	}
+
+	got := map[string]bool{}
+	for range 2 {
+		r := <-results
+		if r.exitCode != 0 {
+			t.Fatalf("run() exitCode = %d, stderr = %s", r.exitCode, r.stderr)
+		}
+		if !strings.Contains(r.stderr, "broker") {
+			t.Fatalf("stderr = %q, want broker notice", r.stderr)
+		}
+		got[r.stdout] = true
+	}
+	if !got["answer to first?\n"] || !got["answer to second?\n"] {
+		t.Fatalf("replies = %v, want one answer per session", got)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run(ctx, &stdout, &stderr, []string{"--env", envPath, "--api-base", srv.URL, "--broker", socket, "--match", "any", "third?"}); code != 2 || !strings.Contains(stderr.String(), "match any") {
+		t.Fatalf("run(--match any) exitCode = %d, stderr = %q, want it refused through the broker", code, stderr.String())
+	}
+
+	stopBroker()
+	if code := <-brokerDone; code != 0 {
+		t.Fatalf("runBroker() exitCode = %d", code)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Fatalf("socket still exists after broker stopped: %v", err)
+	}
+}
+
+func TestRunBrokerPathRequiresRunningBroker(t *testing.T) {
+	tmpDir := t.TempDir()
+	envPath := filepath.Join(tmpDir, ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=123\nTELEGRAM_REPLY_TIMEOUT=10s\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	exitCode := run(context.Background(), &stdout, &stderr, []string{"--env", envPath, "--broker", filepath.Join(tmpDir, "missing.sock"), "hi"})
+	if exitCode != 2 || !strings.Contains(stderr.String(), "no broker") {
+		t.Fatalf("run() exitCode = %d, stderr = %q", exitCode, stderr.String())
+	}
+}
+
+func TestRunSpoolsUnrelatedUpdatesEndToEnd(t *testing.T) {
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	tmpDir := t.TempDir()
+	envPath := filepath.Join(tmpDir, ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=123\nTELEGRAM_REPLY_TIMEOUT=10s\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	fake.InjectMessage(telegramfake.UserMessage{ChatID: 123, Text: "typed early"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		if _, err := fake.WaitForSent(ctx, 1); err != nil {
+			return
+		}
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: 999, Text: "for another tool"})
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: 123, Text: "A"})
+	}()
+
+	var stdout, stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"--env", envPath, "--api-base", srv.URL, "--spool", "A/B?"})
+	if exitCode != 0 {
+		t.Fatalf("run() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+	if got := stdout.String(); got != "A\n" {
+		t.Fatalf("stdout = %q, want A", got)
+	}
+
+	stdout.Reset()
+	if exitCode := run(ctx, &stdout, &stderr, []string{"spool", "--env", envPath, "--chat-id", "999"}); exitCode != 0 {
+		t.Fatalf("spool exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+	if got := stdout.String(); strings.Count(got, "\n") != 1 || !strings.Contains(got, "for another tool") {
+		t.Fatalf("spool --chat-id stdout = %q, want only the other chat's update", got)
+	}
+
+	stdout.Reset()
+	if exitCode := run(ctx, &stdout, &stderr, []string{"spool", "--env", envPath, "--clear"}); exitCode != 0 {
+		t.Fatalf("spool --clear exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "typed early") {
+		t.Fatalf("spool stdout = %q, want both unrelated updates oldest first", stdout.String())
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, ".telegram-spool.jsonl")); !os.IsNotExist(err) {
+		t.Fatalf("spool file still exists after --clear: %v", err)
+	}
+}
+
+func TestRunTranscribesVoiceReplyEndToEnd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts as stand-ins for ffmpeg and whisper.cpp")
+	}
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	tmpDir := t.TempDir()
+	binDir := filepath.Join(tmpDir, "bin")
+	if err := os.Mkdir(binDir, 0o700); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	scripts := map[string]string{
+		// ffmpeg ... -i <src> ... <dst>: copy the voice note to the WAV path.
+		"ffmpeg": "#!/bin/sh\nfor last; do :; done\ncp \"$6\" \"$last\"\n",
+		// Echo the "audio" back, as if it had been spoken.
+		"whisper-cli": "#!/bin/sh\nwhile [ \"$1\" != -f ]; do shift; done\ncat \"$2\"\n",
+	}
+	for name, body := range scripts {
+		if err := os.WriteFile(filepath.Join(binDir, name), []byte(body), 0o700); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	envPath := filepath.Join(tmpDir, ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=123\nTELEGRAM_REPLY_TIMEOUT=10s\n" +
+		"TELEGRAM_WHISPER_BIN=" + filepath.Join(binDir, "whisper-cli") + "\nTELEGRAM_WHISPER_MODEL=ggml-base.bin\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		if _, err := fake.WaitForSent(ctx, 1); err != nil {
+			return
+		}
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: 123, Voice: &telegramfake.UserVoice{Data: []byte("  go with plan B\n"), Duration: 2}})
+	}()
+
+	var stdout, stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"--env", envPath, "--api-base", srv.URL, "--output", "json", "A or B?"})
+	if exitCode != 0 {
+		t.Fatalf("run() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+	if got := stdout.String(); !strings.Contains(got, `"normalized_reply":"go with plan B"`) || !strings.Contains(got, `"transcribed":true`) {
+		t.Fatalf("stdout = %s, want transcribed voice reply", got)
+	}
+}
+
+func TestRunSavesPhotoReplyEndToEnd(t *testing.T) {
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	tmpDir := t.TempDir()
+	envPath := filepath.Join(tmpDir, ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=123\nTELEGRAM_REPLY_TIMEOUT=10s\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		if _, err := fake.WaitForSent(ctx, 1); err != nil {
+			return
+		}
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: 123, Photo: []byte("JPEG data"), Caption: "like this"})
+	}()
+
+	var stdout, stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"--env", envPath, "--api-base", srv.URL, "Sketch the layout?"})
+	if exitCode != 0 {
+		t.Fatalf("run() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 || lines[0] != "like this" {
+		t.Fatalf("stdout = %q, want caption then path", stdout.String())
+	}
+	if filepath.Dir(lines[1]) != filepath.Join(tmpDir, ".telegram-attachments") || !strings.HasSuffix(lines[1], ".jpg") {
+		t.Fatalf("saved path = %q", lines[1])
+	}
+	if got, err := os.ReadFile(lines[1]); err != nil || string(got) != "JPEG data" {
+		t.Fatalf("saved photo = %q, %v", got, err)
+	}
+}
+
+func TestRunGraceWindowAppliesEditEndToEnd(t *testing.T) {
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	tmpDir := t.TempDir()
+	envPath := filepath.Join(tmpDir, ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=123\nTELEGRAM_REPLY_TIMEOUT=10s\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		if _, err := fake.WaitForSent(ctx, 1); err != nil {
+			return
+		}
+		_, messageID := fake.InjectMessage(telegramfake.UserMessage{ChatID: 123, Text: "Alpah"})
+		fake.InjectEdit(telegramfake.UserEdit{ChatID: 123, MessageID: messageID, Text: "Alpha"})
+	}()
+
+	var stdout, stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"--env", envPath, "--api-base", srv.URL, "--grace-window", "500ms", "--output", "json", "Name?"})
+	if exitCode != 0 {
+		t.Fatalf("run() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+	if got := stdout.String(); !strings.Contains(got, `"normalized_reply":"Alpha"`) || !strings.Contains(got, `"corrected":true`) {
+		t.Fatalf("stdout = %s, want the edited answer", got)
+	}
+}
+
+func TestRunRemindsAndMarksExpiredEndToEnd(t *testing.T) {
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	tmpDir := t.TempDir()
+	envPath := filepath.Join(tmpDir, ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=123\nTELEGRAM_REPLY_TIMEOUT=2s\nTELEGRAM_REMINDERS=50%\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"--env", envPath, "--api-base", srv.URL, "--option", "A", "--option", "B", "A or B?"})
+	if exitCode != 1 {
+		t.Fatalf("run() exitCode = %d, want 1 (timeout), stderr = %s", exitCode, stderr.String())
+	}
+
+	sent := fake.SentMessages()
+	if len(sent) != 2 || !strings.HasPrefix(sent[1].Text, "⏰") {
+		t.Fatalf("SentMessages() = %+v, want prompt and one reminder", sent)
+	}
+	prompt := sent[0]
+	if !prompt.Edited || !strings.Contains(prompt.Text, "已过期") || prompt.ReplyMarkup != nil {
+		t.Fatalf("prompt = %+v, want it marked expired without buttons", prompt)
+	}
+}
+
+func TestRunEscalatesToNextChatEndToEnd(t *testing.T) {
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	envPath := filepath.Join(t.TempDir(), ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_IDS=123:1s,456:10s\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	go func() {
+		sent, err := fake.WaitForSent(ctx, 2)
+		if err != nil {
+			return
+		}
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: 456, Text: "B", ReplyToMessageID: sent[1].MessageID})
+	}()
+
+	var stdout, stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"--env", envPath, "--api-base", srv.URL, "--option", "A", "--option", "B", "--output", "json", "A or B?"})
+	if exitCode != 0 {
+		t.Fatalf("run() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+
+	var result jsonResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatalf("stdout = %q: %v", stdout.String(), err)
+	}
+	if result.ChatID != "456" || result.NormalizedReply != "B" {
+		t.Fatalf("result = %+v, want B answered in chat 456", result)
+	}
+	if !strings.Contains(stderr.String(), "已转交到聊天 456") {
+		t.Fatalf("stderr = %q, want escalation notice", stderr.String())
+	}
+
+	sent := fake.SentMessages()
+	if len(sent) != 2 || sent[0].ChatID != 123 || sent[1].ChatID != 456 {
+		t.Fatalf("SentMessages() = %+v, want the prompt in 123 then 456", sent)
+	}
+	if !sent[0].Edited || !strings.Contains(sent[0].Text, "已转交") || sent[0].ReplyMarkup != nil {
+		t.Fatalf("first prompt = %+v, want it marked as handed on without buttons", sent[0])
+	}
+}
+
+func TestRunTranscriptExportEndToEnd(t *testing.T) {
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	tmpDir := t.TempDir()
+	envPath := filepath.Join(tmpDir, ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=123\nTELEGRAM_REPLY_TIMEOUT=10s\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		if _, err := fake.WaitForSent(ctx, 1); err != nil {
+			return
+		}
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: 123, Username: "alice", Text: "B"})
+	}()
+	var stdout, stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"--env", envPath, "--api-base", srv.URL, "--transcript", "design-1", "--option", "A", "--option", "B", "Which storage?"})
+	if exitCode != 0 {
+		t.Fatalf("run() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+
+	// A timed-out round is recorded too.
+	exitCode = run(ctx, &stdout, &stderr, []string{"--env", envPath, "--api-base", srv.URL, "--transcript", "design-1", "--session-timeout", "1s", "Any constraints?"})
+	if exitCode != 1 {
+		t.Fatalf("run() exitCode = %d, want 1 (timeout), stderr = %s", exitCode, stderr.String())
+	}
+
+	stdout.Reset()
+	if code := run(ctx, &stdout, &stderr, []string{"export", "--env", envPath, "--transcript", "design-1"}); code != 0 {
+		t.Fatalf("export exitCode = %d, stderr = %s", code, stderr.String())
+	}
+	md := stdout.String()
+	for _, want := range []string{"# Brainstorming session `design-1`", "## Round 1", "> Which storage?", "Options: A / B", "by @alice", "**Answer**: B", "## Round 2", "- Status: timed out"} {
+		if !strings.Contains(md, want) {
+			t.Fatalf("markdown export = %s\nwant %q", md, want)
+		}
+	}
+
+	stdout.Reset()
+	if code := run(ctx, &stdout, &stderr, []string{"export", "--env", envPath, "--transcript", "design-1", "--format", "json"}); code != 0 {
+		t.Fatalf("export exitCode = %d, stderr = %s", code, stderr.String())
+	}
+	var export struct {
+		Session string `json:"session"`
+		Rounds  []struct {
+			Prompt          string `json:"prompt"`
+			Status          string `json:"status"`
+			NormalizedReply string `json:"normalized_reply"`
+			PromptMessageID int64  `json:"prompt_message_id"`
+			ReplyMessageID  int64  `json:"reply_message_id"`
+		} `json:"rounds"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &export); err != nil {
+		t.Fatalf("Unmarshal() error = %v, output = %s", err, stdout.String())
+	}
+	if export.Session != "design-1" || len(export.Rounds) != 2 {
+		t.Fatalf("export = %+v", export)
+	}
+	first, second := export.Rounds[0], export.Rounds[1]
+	if first.Status != "answered" || first.NormalizedReply != "B" || first.PromptMessageID == 0 || first.ReplyMessageID == 0 {
+		t.Fatalf("first round = %+v", first)
+	}
+	if second.Status != "timeout" || second.Prompt != "Any constraints?" || second.PromptMessageID == 0 {
+		t.Fatalf("second round = %+v", second)
+	}
+
+	if code := run(ctx, &stdout, &stderr, []string{"export", "--env", envPath, "--transcript", "missing"}); code != 1 {
+		t.Fatalf("export of unknown transcript exitCode = %d, want 1", code)
+	}
+}
+
+func TestRunSummarySendsAndPinsDigestEndToEnd(t *testing.T) {
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	tmpDir := t.TempDir()
+	envPath := filepath.Join(tmpDir, ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=123\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	store := sessionstore.New(sessionstore.DefaultDir(envPath))
+	for _, e := range []sessionstore.TranscriptEntry{
+		{Prompt: "# Storage\nWhich backend?", Options: []string{"SQLite", "Postgres"}, Status: sessionstore.StatusAnswered, NormalizedReply: "SQLite"},
+		{Prompt: "Any deadline?", Status: sessionstore.StatusTimeout},
+	} {
+		if err := store.AppendTranscript("design-1", e); err != nil {
+			t.Fatalf("AppendTranscript() error = %v", err)
+		}
+	}
+	specPath := filepath.Join(tmpDir, "spec.md")
+	if err := os.WriteFile(specPath, []byte("- Purpose: local cache\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"summary", "--env", envPath, "--api-base", srv.URL, "--transcript", "design-1", "--spec-file", specPath})
+	if exitCode != 0 {
+		t.Fatalf("run() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+
+	sent := fake.SentMessages()
+	if len(sent) != 1 || !sent[0].Pinned || sent[0].ParseMode != "MarkdownV2" {
+		t.Fatalf("SentMessages() = %+v, want one pinned MarkdownV2 digest", sent)
+	}
+	if got := stdout.String(); got != fmt.Sprintf("%d\n", sent[0].MessageID) {
+		t.Fatalf("stdout = %q, want the digest message ID", got)
+	}
+	for _, want := range []string{"design\\-1", "*1\\. Storage*", "✅ SQLite", "*2\\. Any deadline?*", "⌛ 未回答", "*结论*", "• Purpose: local cache"} {
+		if !strings.Contains(sent[0].Text, want) {
+			t.Fatalf("digest = %s\nwant %q", sent[0].Text, want)
+		}
+	}
+}
+
+func TestRunWithoutEnvFileUsesEnvironmentAndFlagsEndToEnd(t *testing.T) {
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	// No .env at all: the token comes from the environment and --chat-id
+	// overrides the exported chat.
+	t.Setenv("TELEGRAM_BOT_TOKEN", "token")
+	t.Setenv("TELEGRAM_CHAT_ID", "999")
+	missing := filepath.Join(t.TempDir(), ".env")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		sent, err := fake.WaitForSent(ctx, 1)
+		if err != nil {
+			return
+		}
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: 123, Text: "A", ReplyToMessageID: sent[0].MessageID})
+	}()
+
+	var stdout, stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"--env", missing, "--chat-id", "123", "--session-timeout", "5s", "--api-base", srv.URL, "A/B?"})
+	if exitCode != 0 {
+		t.Fatalf("run() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+	if got := stdout.String(); got != "A\n" {
+		t.Fatalf("stdout = %q, want A", got)
+	}
+	if sent := fake.SentMessages(); len(sent) != 1 || sent[0].ChatID != 123 {
+		t.Fatalf("SentMessages() = %+v, want the prompt in chat 123", sent)
+	}
+}
+
+func TestRunFollowsChatMigrationEndToEnd(t *testing.T) {
+	fake := telegramfake.NewServer("token")
+	fake.MigrateChat(-777, -1001234)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	tmpDir := t.TempDir()
+	envPath := filepath.Join(tmpDir, ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=-777\nTELEGRAM_REPLY_TIMEOUT=5s\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		sent, err := fake.WaitForSent(ctx, 1)
+		if err != nil {
+			return
+		}
+		fake.InjectCallback(telegramfake.CallbackTap{ChatID: -1001234, MessageID: sent[0].MessageID, Data: "opt:1", FromID: 42})
+	}()
+
+	var stdout, stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"--env", envPath, "--api-base", srv.URL, "--option", "A", "--option", "B", "A or B?"})
+	if exitCode != 0 {
+		t.Fatalf("run() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+	if got := stdout.String(); got != "B\n" {
+		t.Fatalf("stdout = %q, want B", got)
+	}
+
+	sent := fake.SentMessages()
+	if len(sent) != 1 || sent[0].ChatID != -1001234 || !strings.Contains(sent[0].Text, "✅ 已回答：B") {
+		t.Fatalf("SentMessages() = %+v, want the prompt in the supergroup marked answered", sent)
+	}
+	if !strings.Contains(stderr.String(), "TELEGRAM_CHAT_ID 更新为 -1001234") {
+		t.Fatalf("stderr = %q, want a hint to update TELEGRAM_CHAT_ID", stderr.String())
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"codex-brainstorming-telegram/internal/config"
+	"codex-brainstorming-telegram/internal/telegrambrainstorm"
+)
+
+// escalationTargets are the chats after the first in TELEGRAM_CHAT_IDS, each
+// with reminders resolved against its own timeout.
+func escalationTargets(cfg config.TelegramConfig) []telegrambrainstorm.EscalationTarget {
+	if len(cfg.ChatIDs) < 2 {
+		return nil
+	}
+	targets := make([]telegrambrainstorm.EscalationTarget, 0, len(cfg.ChatIDs)-1)
+	for _, t := range cfg.ChatIDs[1:] {
+		targets = append(targets, telegrambrainstorm.EscalationTarget{
+			ChatID:    t.ChatID,
+			Timeout:   t.Timeout,
+			Reminders: cfg.Reminders.Delays(t.Timeout),
+		})
+	}
+	return targets
+}
+
+// escalationWait is how much longer than timeout a prompt can wait once it
+// is handed on through targets.
+func escalationWait(targets []telegrambrainstorm.EscalationTarget) time.Duration {
+	var total time.Duration
+	for _, t := range targets {
+		total += t.Timeout
+	}
+	return total
+}
+
+// escalatedTo returns the escalation target a prompt was answered in, if it
+// was not answered in the first chat.
+func escalatedTo(targets []telegrambrainstorm.EscalationTarget, chatID string) (telegrambrainstorm.EscalationTarget, bool) {
+	for _, t := range targets {
+		if t.ChatID == chatID {
+			return t, true
+		}
+	}
+	return telegrambrainstorm.EscalationTarget{}, false
+}
+
+func printEscalation(stderr io.Writer) func(string) {
+	return func(chatID string) {
+		fmt.Fprintf(stderr, "无人回复，问题已转交到聊天 %s。\n", chatID)
+	}
+}
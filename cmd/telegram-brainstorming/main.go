@@ -12,20 +12,50 @@ import (
 	"strings"
 	"time"
 
-	"codex-brainstorming-telegram/internal/config"
+	"codex-brainstorming-telegram/internal/sessionstore"
 	"codex-brainstorming-telegram/internal/telegramapi"
 	"codex-brainstorming-telegram/internal/telegrambrainstorm"
+	"codex-brainstorming-telegram/internal/telegramformat"
 )
 
 type promptAPI interface {
-	SendMessage(ctx context.Context, chatID string, text string) (int64, error)
+	SendMessageWithOptions(ctx context.Context, chatID string, text string, opts telegramapi.SendOptions) (int64, error)
 	GetUpdates(ctx context.Context, offset int64, timeoutSec int) ([]telegramapi.Update, error)
+	AnswerCallbackQuery(ctx context.Context, callbackQueryID string, text string) error
+	SendDocument(ctx context.Context, chatID string, doc telegramapi.InputFile, opts telegramapi.DocumentOptions) (int64, error)
+	GetFile(ctx context.Context, fileID string) (telegramapi.File, error)
+	DownloadFile(ctx context.Context, filePath string) ([]byte, error)
+	EditMessageText(ctx context.Context, chatID string, messageID int64, text string, opts telegramapi.EditOptions) error
+	EditMessageReplyMarkup(ctx context.Context, chatID string, messageID int64, markup *telegramapi.InlineKeyboardMarkup) error
+	EffectiveChatID(chatID string) string
 }
 
 type promptResult = telegrambrainstorm.PromptResult
 
-var runPrompt = func(ctx context.Context, api promptAPI, chatID string, prompt string, timeout time.Duration) (promptResult, error) {
-	return telegrambrainstorm.RunPrompt(ctx, api, chatID, prompt, timeout)
+type promptOptions = telegrambrainstorm.PromptOptions
+
+var runPrompt = func(ctx context.Context, api promptAPI, chatID string, prompt string, timeout time.Duration, opts promptOptions) (promptResult, error) {
+	return telegrambrainstorm.RunPrompt(ctx, api, chatID, prompt, timeout, opts)
+}
+
+const matchFlagUsage = "reply matching: any (first message after the prompt) or reply (only replies to the prompt); default any, or reply when reading through a broker without TELEGRAM_THREAD_ID"
+
+const graceWindowFlagUsage = "after the first answer, wait this long for an edit or /undo that replaces it (0 disables)"
+
+const (
+	remindFlagUsage     = "reminder schedule such as 50%,90% or 2m,4m; off disables (default: TELEGRAM_REMINDERS)"
+	markStatusFlagUsage = "edit the prompt to show the recorded answer, or that it expired, and remove its buttons"
+)
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
 }
 
 func main() {
@@ -33,66 +63,259 @@ func main() {
 }
 
 func run(parent context.Context, stdout io.Writer, stderr io.Writer, args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "conversation":
+			return runConversation(parent, os.Stdin, stdout, stderr, args[1:])
+		case "approve":
+			return runApprove(parent, os.Stdin, stdout, stderr, args[1:])
+		case "spool":
+			return runSpool(stdout, stderr, args[1:])
+		case "broker":
+			return runBroker(parent, stderr, args[1:])
+		case "export":
+			return runExport(stdout, stderr, args[1:])
+		case "summary":
+			return runSummary(parent, os.Stdin, stdout, stderr, args[1:])
+		case "config":
+			return runConfig(stdout, stderr, args[1:])
+		}
+	}
+
 	fs := flag.NewFlagSet("telegram-brainstorming", flag.ContinueOnError)
 	fs.SetOutput(stderr)
 
-	envPath := fs.String("env", ".env", "path to .env file")
+	cf := addConfigFlags(fs)
+	envPath := cf.envPath
 	apiBase := fs.String("api-base", "https://api.telegram.org", "telegram API base URL")
 	overrideTimeout := fs.Duration("session-timeout", 0, "override TELEGRAM_REPLY_TIMEOUT")
+	cf.replyTimeout = overrideTimeout
 	promptFlag := fs.String("prompt", "", "prompt text to send to Telegram")
+	var options stringList
+	fs.Var(&options, "option", "answer option shown as an inline keyboard button (repeatable)")
+	multiSelect := fs.Bool("multi-select", false, "accept replies naming several options, such as 1,3")
+	allowFreeText := fs.Bool("allow-free-text", false, "return replies that match no option instead of re-asking")
+	matchMode := fs.String("match", "", matchFlagUsage)
+	var attachFiles stringList
+	fs.Var(&attachFiles, "attach-file", "file sent as a document before the prompt (repeatable)")
+	planFile := fs.String("plan-file", "", "plan or diff sent as a .md/.diff document before the prompt (- reads stdin); the prompt defaults to an approval question")
+	parseModeFlag := fs.String("parse-mode", "none", "prompt formatting: none, markdownv2 or html (Markdown headings, lists and code blocks are converted)")
+	webhookURL := fs.String("webhook-url", "", "receive updates via this public webhook URL instead of getUpdates polling")
+	webhookListen := fs.String("webhook-listen", "127.0.0.1:8443", "local address serving the webhook (behind a reverse proxy)")
+	brokerFlag := fs.String("broker", brokerAuto, brokerFlagUsage)
+	spool := fs.Bool("spool", false, spoolFlagUsage)
+	spoolFile := fs.String("spool-file", "", spoolFileFlagUsage)
+	graceWindow := fs.Duration("grace-window", 0, graceWindowFlagUsage)
+	cf.reminders = fs.String("remind", "", remindFlagUsage)
+	markStatus := fs.Bool("mark-status", true, markStatusFlagUsage)
+	attachmentDir := fs.String("attachment-dir", "", attachmentDirFlagUsage)
+	sessionID := fs.String("session", "", "session ID used to persist the round and resume it after a restart")
+	transcriptID := fs.String("transcript", "", "transcript ID: append this round to its transcript (see the export subcommand)")
+	sessionDir := fs.String("session-dir", "", "session state directory (default: .telegram-sessions next to --env)")
+	retryAttempts := fs.Int("retry-attempts", telegramapi.DefaultRetryPolicy.MaxAttempts, "attempts per Telegram request, including the first (1 disables retries)")
+	outputFormat := fs.String("output", outputText, "stdout format: text (reply only) or json (one result object)")
 
 	if err := fs.Parse(args); err != nil {
 		return 2
 	}
-	if *overrideTimeout < 0 {
-		fmt.Fprintln(stderr, "session-timeout must be >= 0")
+	out, err := newResultWriter(stdout, *outputFormat)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
 		return 2
 	}
+	if *overrideTimeout < 0 {
+		return usageError(out, stderr, errors.New("session-timeout must be >= 0"))
+	}
+	if *matchMode != "" && !isValidMatchMode(*matchMode) {
+		return usageError(out, stderr, errors.New("match must be any or reply"))
+	}
+	if *graceWindow < 0 {
+		return usageError(out, stderr, errors.New("grace-window must be >= 0"))
+	}
+	if *retryAttempts < 1 {
+		return usageError(out, stderr, errors.New("retry-attempts must be >= 1"))
+	}
+	parseMode, err := telegramformat.ParseParseMode(*parseModeFlag)
+	if err != nil {
+		return usageError(out, stderr, err)
+	}
+	if err := telegrambrainstorm.ValidateOptions(options); err != nil {
+		return usageError(out, stderr, err)
+	}
+	if *webhookURL != "" && *brokerFlag != brokerAuto && *brokerFlag != brokerOff {
+		return usageError(out, stderr, errors.New("use either --webhook-url or --broker, not both"))
+	}
 
-	cfg, err := config.LoadTelegramConfig(*envPath)
+	cfg, err := cf.load()
 	if err != nil {
-		fmt.Fprintf(stderr, "load config failed: %v\n", err)
-		return 2
+		return usageError(out, stderr, fmt.Errorf("load config failed: %w", err))
+	}
+
+	promptText := defaultPlanPrompt
+	if *planFile == "" || strings.TrimSpace(*promptFlag) != "" || len(fs.Args()) > 0 {
+		promptText, err = buildPromptText(*promptFlag, fs.Args())
+		if err != nil {
+			return usageError(out, stderr, err)
+		}
 	}
-	if *overrideTimeout > 0 {
-		cfg.ReplyTimeout = *overrideTimeout
+
+	attachments, err := loadAttachments(attachFiles, *planFile, os.Stdin)
+	if err != nil {
+		return usageError(out, stderr, err)
 	}
 
-	promptText, err := buildPromptText(*promptFlag, fs.Args())
+	opts := promptOptions{
+		Options:        options,
+		MultiSelect:    *multiSelect,
+		AllowFreeText:  *allowFreeText,
+		ParseMode:      parseMode,
+		AllowedUserIDs: cfg.AllowedUserIDs,
+		ThreadID:       cfg.ThreadID,
+		Attachments:    attachments,
+		OnUnrelated:    spoolUnrelated(*spool, *spoolFile, *envPath),
+		Transcriber:    newTranscriber(cfg, stderr),
+		AttachmentDir:  receivedDir(*attachmentDir, *envPath),
+		GraceWindow:    *graceWindow,
+		Reminders:      cfg.Reminders.Delays(cfg.ReplyTimeout),
+		MarkStatus:     *markStatus,
+		Escalation:     escalationTargets(cfg),
+		OnEscalate:     printEscalation(stderr),
+	}
+
+	transcript, err := newTranscriptRecorder(*sessionDir, *envPath, *transcriptID, stderr)
 	if err != nil {
-		fmt.Fprintln(stderr, err)
-		return 2
+		return usageError(out, stderr, fmt.Errorf("load transcript failed: %w", err))
+	}
+
+	var tracker *sessionTracker
+	if *sessionID != "" {
+		dir := *sessionDir
+		if dir == "" {
+			dir = sessionstore.DefaultDir(*envPath)
+		}
+		tracker, err = openSessionTracker(dir, *sessionID, promptText+attachmentDigest(attachments), options)
+		if err != nil {
+			return usageError(out, stderr, fmt.Errorf("load session failed: %w", err))
+		}
+		if round, ok := tracker.answered(); ok {
+			fmt.Fprintln(stderr, "会话已完成：返回已记录的 Telegram 回复。")
+			out.reply(roundResult(round))
+			return 0
+		}
+		if cp := tracker.resumeCheckpoint(); cp != nil {
+			fmt.Fprintln(stderr, "恢复会话：继续等待上次已发送问题的回复。")
+			opts.Resume = cp
+		}
+		opts.OnCheckpoint = tracker.checkpoint
 	}
 
 	httpClient, err := buildHTTPClient(cfg.ProxyURL)
 	if err != nil {
-		fmt.Fprintf(stderr, "proxy config error: %v\n", err)
-		return 2
+		return usageError(out, stderr, fmt.Errorf("proxy config error: %w", err))
 	}
 
 	apiClient := telegramapi.NewClient(*apiBase, cfg.BotToken, httpClient)
+	retry := telegramapi.DefaultRetryPolicy
+	retry.MaxAttempts = *retryAttempts
+	apiClient.SetRetryPolicy(retry)
+	defer printMigrationHint(stderr, apiClient, cfg.ChatID)
+
+	ctx, cancel := context.WithTimeout(parent, cfg.ReplyTimeout+escalationWait(opts.Escalation)+30*time.Second)
+	defer cancel()
+
+	var api promptAPI = apiClient
+	if *webhookURL != "" {
+		hook, err := startWebhook(ctx, apiClient, *webhookURL, *webhookListen, cfg.WebhookSecret)
+		if err != nil {
+			fmt.Fprintf(stderr, "webhook 启动失败：%v\n", err)
+			printHint(stderr, err)
+			out.failure(classifyError(err), err)
+			return 1
+		}
+		defer func() {
+			if err := hook.Close(); err != nil {
+				fmt.Fprintf(stderr, "webhook 清理失败：%v\n", err)
+			}
+		}()
+		api = routedAPI{Client: apiClient, updates: hook.receiver}
+	} else {
+		api, err = useBroker(apiClient, *brokerFlag, cfg.BotToken)
+		if err != nil {
+			return usageError(out, stderr, err)
+		}
+		if _, ok := api.(routedAPI); ok {
+			fmt.Fprintln(stderr, "通过本地 broker 接收 Telegram 更新。")
+		}
+	}
+	opts.Match, err = resolveMatch(*matchMode, sharesUpdates(api, cfg.ThreadID))
+	if err != nil {
+		return usageError(out, stderr, err)
+	}
 
 	fmt.Fprintln(stderr, "程序正在运行中，请前往 Telegram 查看并回复。")
 	fmt.Fprintln(stderr, "终端仅显示运行状态，不显示提问内容。")
 
-	ctx, cancel := context.WithTimeout(parent, cfg.ReplyTimeout+30*time.Second)
-	defer cancel()
-
-	result, err := runPrompt(ctx, apiClient, cfg.ChatID, promptText, cfg.ReplyTimeout)
+	result, err := runPrompt(ctx, api, cfg.ChatID, promptText, cfg.ReplyTimeout, opts)
+	if tracker != nil && result.SentAt.IsZero() {
+		// Resumed rounds were sent by an earlier run.
+		result.SentAt = tracker.sess.Round.SentAt
+	}
+	transcript.record(promptText, options, result, err)
 	if err != nil {
+		out.failure(classifyError(err), err)
 		if errors.Is(err, telegrambrainstorm.ErrSessionTimeout) {
+			if tracker != nil {
+				if err := tracker.expire(); err != nil {
+					fmt.Fprintf(stderr, "保存会话失败：%v\n", err)
+				}
+			}
 			fmt.Fprintln(stderr, "会话超时：未在规定时间内完成 Telegram 对话")
 			return 1
 		}
 		fmt.Fprintf(stderr, "会话失败：%v\n", err)
+		printHint(stderr, err)
 		return 1
 	}
 
+	if tracker != nil {
+		if err := tracker.recordReply(result); err != nil {
+			fmt.Fprintf(stderr, "保存会话失败：%v\n", err)
+		}
+	}
+
 	fmt.Fprintln(stderr, "会话完成：已收到 Telegram 回复。")
-	fmt.Fprintln(stdout, result.NormalizedReply)
+	out.reply(result)
 	return 0
 }
 
+func usageError(out *resultWriter, stderr io.Writer, err error) int {
+	fmt.Fprintln(stderr, err)
+	out.failure(errCodeConfig, err)
+	return 2
+}
+
+func printHint(stderr io.Writer, err error) {
+	if hint := telegramapi.Hint(err); hint != "" {
+		fmt.Fprintf(stderr, "提示：%s\n", hint)
+	}
+}
+
+// printMigrationHint is deferred by every command that talks to the chat.
+func printMigrationHint(stderr io.Writer, api *telegramapi.Client, chatID string) {
+	if hint := api.MigrationHint(chatID); hint != "" {
+		fmt.Fprintf(stderr, "提示：%s\n", hint)
+	}
+}
+
+func isValidMatchMode(mode string) bool {
+	switch telegrambrainstorm.MatchMode(mode) {
+	case telegrambrainstorm.MatchAny, telegrambrainstorm.MatchReply:
+		return true
+	default:
+		return false
+	}
+}
+
 func buildPromptText(promptFlag string, positional []string) (string, error) {
 	fromFlag := strings.TrimSpace(promptFlag)
 	fromPositional := strings.TrimSpace(strings.Join(positional, " "))
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"codex-brainstorming-telegram/internal/telegramapi"
+	"codex-brainstorming-telegram/internal/telegrambrainstorm"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// Machine-readable error codes for --output json.
+const (
+	errCodeConfig  = "config"
+	errCodeTimeout = "timeout"
+	errCodeAuth    = "auth"
+	errCodeNetwork = "network"
+	errCodeAPI     = "api"
+)
+
+type jsonSender struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username,omitempty"`
+	IsBot    bool   `json:"is_bot,omitempty"`
+}
+
+type jsonFile struct {
+	Path     string `json:"path"`
+	FileName string `json:"file_name"`
+	MimeType string `json:"mime_type,omitempty"`
+	Size     int64  `json:"size"`
+}
+
+type jsonError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+type jsonResult struct {
+	OK              bool        `json:"ok"`
+	ChatID          string      `json:"chat_id,omitempty"`
+	RawReply        string      `json:"raw_reply,omitempty"`
+	NormalizedReply string      `json:"normalized_reply,omitempty"`
+	Choices         []string    `json:"choices,omitempty"`
+	PromptMessageID int64       `json:"prompt_message_id,omitempty"`
+	ReplyMessageID  int64       `json:"reply_message_id,omitempty"`
+	Sender          *jsonSender `json:"sender,omitempty"`
+	Transcribed     bool        `json:"transcribed,omitempty"`
+	Files           []jsonFile  `json:"files,omitempty"`
+	Corrected       bool        `json:"corrected,omitempty"`
+	SentAt          *time.Time  `json:"sent_at,omitempty"`
+	RepliedAt       *time.Time  `json:"replied_at,omitempty"`
+	ElapsedMS       int64       `json:"elapsed_ms"`
+	Error           *jsonError  `json:"error,omitempty"`
+}
+
+// resultWriter prints the final outcome on stdout: the bare reply in text
+// mode, or a single JSON object (also for failures) in json mode.
+type resultWriter struct {
+	stdout io.Writer
+	format string
+	start  time.Time
+}
+
+func newResultWriter(stdout io.Writer, format string) (*resultWriter, error) {
+	switch format {
+	case outputText, outputJSON:
+	default:
+		return nil, fmt.Errorf("output must be %s or %s", outputText, outputJSON)
+	}
+	return &resultWriter{stdout: stdout, format: format, start: time.Now()}, nil
+}
+
+func (w *resultWriter) reply(result promptResult) {
+	if w.format == outputText {
+		// A photo or document reply prints its caption (if any) and then
+		// one saved path per line.
+		if result.NormalizedReply != "" || len(result.Files) == 0 {
+			fmt.Fprintln(w.stdout, result.NormalizedReply)
+		}
+		for _, f := range result.Files {
+			fmt.Fprintln(w.stdout, f.Path)
+		}
+		return
+	}
+
+	out := jsonResult{
+		OK:              true,
+		ChatID:          result.ChatID,
+		RawReply:        result.RawReply,
+		NormalizedReply: result.NormalizedReply,
+		Choices:         result.Choices,
+		PromptMessageID: result.PromptMessageID,
+		ReplyMessageID:  result.ReplyMessageID,
+		Transcribed:     result.Transcribed,
+		Files:           jsonFiles(result.Files),
+		Corrected:       result.Corrected,
+		SentAt:          optionalTime(result.SentAt),
+		RepliedAt:       optionalTime(result.RepliedAt),
+		ElapsedMS:       time.Since(w.start).Milliseconds(),
+	}
+	if result.Sender.ID != 0 {
+		out.Sender = &jsonSender{
+			ID:       result.Sender.ID,
+			Username: result.Sender.Username,
+			IsBot:    result.Sender.IsBot,
+		}
+	}
+	w.writeJSON(out)
+}
+
+func jsonFiles(files []telegrambrainstorm.ReceivedFile) []jsonFile {
+	var out []jsonFile
+	for _, f := range files {
+		out = append(out, jsonFile{Path: f.Path, FileName: f.FileName, MimeType: f.MimeType, Size: f.Size})
+	}
+	return out
+}
+
+func (w *resultWriter) failure(code string, err error) {
+	if w.format == outputText {
+		return
+	}
+	w.writeJSON(jsonResult{
+		ElapsedMS: time.Since(w.start).Milliseconds(),
+		Error:     &jsonError{Code: code, Message: err.Error(), Hint: telegramapi.Hint(err)},
+	})
+}
+
+func (w *resultWriter) writeJSON(v jsonResult) {
+	_ = json.NewEncoder(w.stdout).Encode(v)
+}
+
+func classifyError(err error) string {
+	var netErr net.Error
+	var urlErr *url.Error
+	switch {
+	case errors.Is(err, telegrambrainstorm.ErrSessionTimeout), errors.Is(err, context.DeadlineExceeded):
+		// Checked before net.Error, which context.DeadlineExceeded satisfies.
+		return errCodeTimeout
+	case errors.Is(err, telegramapi.ErrUnauthorized):
+		return errCodeAuth
+	case errors.As(err, &urlErr), errors.As(err, &netErr):
+		return errCodeNetwork
+	default:
+		return errCodeAPI
+	}
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"codex-brainstorming-telegram/internal/telegramapi"
+	"codex-brainstorming-telegram/internal/telegrambrainstorm"
+	"codex-brainstorming-telegram/internal/telegramfake"
+)
+
+func TestClassifyError(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("wait: %w", telegrambrainstorm.ErrSessionTimeout), errCodeTimeout},
+		{fmt.Errorf("wait: %w", context.DeadlineExceeded), errCodeTimeout},
+		{fmt.Errorf("poll: %w", &url.Error{Op: "Get", URL: "https://api.telegram.org", Err: context.DeadlineExceeded}), errCodeTimeout},
+		{fmt.Errorf("send prompt: %w", telegramapi.ErrUnauthorized), errCodeAuth},
+		{fmt.Errorf("poll: %w", &url.Error{Op: "Get", URL: "https://api.telegram.org", Err: errors.New("connection refused")}), errCodeNetwork},
+		{errors.New("sendMessage failed: Bad Request: chat not found"), errCodeAPI},
+	}
+	for _, tc := range cases {
+		if got := classifyError(tc.err); got != tc.want {
+			t.Fatalf("classifyError(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestRunJSONOutputSuccess(t *testing.T) {
+	envPath := filepath.Join(t.TempDir(), ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=123\nTELEGRAM_REPLY_TIMEOUT=1m\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	sentAt := time.Date(2026, 2, 19, 10, 0, 0, 0, time.UTC)
+	orig := runPrompt
+	runPrompt = func(context.Context, promptAPI, string, string, time.Duration, promptOptions) (promptResult, error) {
+		return promptResult{
+			RawReply:        " b ",
+			NormalizedReply: "b",
+			Choices:         []string{"B"},
+			PromptMessageID: 10,
+			ReplyMessageID:  11,
+			Sender:          telegramapi.User{ID: 55, Username: "alice"},
+			SentAt:          sentAt,
+			RepliedAt:       sentAt.Add(time.Minute),
+		}, nil
+	}
+	t.Cleanup(func() {
+		runPrompt = orig
+	})
+
+	var stdout, stderr bytes.Buffer
+	if exitCode := run(context.Background(), &stdout, &stderr, []string{"--env", envPath, "--output", "json", "A/B?"}); exitCode != 0 {
+		t.Fatalf("run() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+
+	var got jsonResult
+	if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal(%q) error = %v", stdout.String(), err)
+	}
+	if !got.OK || got.RawReply != " b " || got.NormalizedReply != "b" || len(got.Choices) != 1 {
+		t.Fatalf("result = %+v", got)
+	}
+	if got.PromptMessageID != 10 || got.ReplyMessageID != 11 {
+		t.Fatalf("message IDs = %d/%d", got.PromptMessageID, got.ReplyMessageID)
+	}
+	if got.Sender == nil || got.Sender.ID != 55 || got.Sender.Username != "alice" {
+		t.Fatalf("sender = %+v", got.Sender)
+	}
+	if got.SentAt == nil || !got.SentAt.Equal(sentAt) || got.RepliedAt == nil {
+		t.Fatalf("timestamps = %v / %v", got.SentAt, got.RepliedAt)
+	}
+	if got.Error != nil {
+		t.Fatalf("error = %+v, want nil", got.Error)
+	}
+}
+
+func TestRunJSONOutputConfigError(t *testing.T) {
+	t.Parallel()
+
+	var stdout, stderr bytes.Buffer
+	missing := filepath.Join(t.TempDir(), ".env")
+	if exitCode := run(context.Background(), &stdout, &stderr, []string{"--env", missing, "--output", "json", "A/B?"}); exitCode != 2 {
+		t.Fatalf("run() exitCode = %d, want 2", exitCode)
+	}
+
+	var got jsonResult
+	if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal(%q) error = %v", stdout.String(), err)
+	}
+	if got.OK || got.Error == nil || got.Error.Code != errCodeConfig {
+		t.Fatalf("result = %+v, want config error", got)
+	}
+}
+
+func TestRunJSONOutputBlankOptionIsConfigError(t *testing.T) {
+	t.Parallel()
+
+	envPath := filepath.Join(t.TempDir(), ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=123\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if exitCode := run(context.Background(), &stdout, &stderr, []string{"--env", envPath, "--api-base", "http://127.0.0.1:1", "--broker", "off", "--option", " ", "--output", "json", "A/B?"}); exitCode != 2 {
+		t.Fatalf("run() exitCode = %d, want 2", exitCode)
+	}
+
+	var got jsonResult
+	if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal(%q) error = %v", stdout.String(), err)
+	}
+	if got.OK || got.Error == nil || got.Error.Code != errCodeConfig || got.Error.Message != "options must not be empty" {
+		t.Fatalf("result = %+v, want config error for the blank option", got)
+	}
+}
+
+func TestRunJSONOutputAuthErrorAgainstFakeServer(t *testing.T) {
+	srv := httptest.NewServer(telegramfake.NewServer("real-token"))
+	defer srv.Close()
+
+	envPath := filepath.Join(t.TempDir(), ".env")
+	content := "TELEGRAM_BOT_TOKEN=revoked\nTELEGRAM_CHAT_ID=123\nTELEGRAM_REPLY_TIMEOUT=5s\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if exitCode := run(context.Background(), &stdout, &stderr, []string{"--env", envPath, "--api-base", srv.URL, "--output", "json", "A/B?"}); exitCode != 1 {
+		t.Fatalf("run() exitCode = %d, want 1", exitCode)
+	}
+
+	var got jsonResult
+	if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal(%q) error = %v", stdout.String(), err)
+	}
+	if got.Error == nil || got.Error.Code != errCodeAuth {
+		t.Fatalf("result = %+v, want auth error", got)
+	}
+	if !strings.Contains(got.Error.Hint, "TELEGRAM_BOT_TOKEN") {
+		t.Fatalf("error hint = %q, want token hint", got.Error.Hint)
+	}
+	if !strings.Contains(stderr.String(), "提示：") {
+		t.Fatalf("stderr = %q, want hint line", stderr.String())
+	}
+}
+
+func TestRunJSONOutputNetworkErrorRedactsToken(t *testing.T) {
+	t.Parallel()
+
+	envPath := filepath.Join(t.TempDir(), ".env")
+	content := "TELEGRAM_BOT_TOKEN=123456:SECRETTOKEN\nTELEGRAM_CHAT_ID=123\nTELEGRAM_REPLY_TIMEOUT=5s\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"--env", envPath, "--api-base", "http://127.0.0.1:1", "--retry-attempts", "1", "--broker", "off", "--output", "json", "A/B?"}
+	if exitCode := run(context.Background(), &stdout, &stderr, args); exitCode != 1 {
+		t.Fatalf("run() exitCode = %d, want 1, stderr = %s", exitCode, stderr.String())
+	}
+
+	if strings.Contains(stdout.String(), "SECRETTOKEN") || strings.Contains(stderr.String(), "SECRETTOKEN") {
+		t.Fatalf("stdout = %q, stderr = %q, want the token redacted", stdout.String(), stderr.String())
+	}
+	var got jsonResult
+	if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal(%q) error = %v", stdout.String(), err)
+	}
+	if got.Error == nil || got.Error.Code != errCodeNetwork || !strings.Contains(got.Error.Message, "123456:***") {
+		t.Fatalf("result = %+v, want a network error with the redacted URL", got)
+	}
+}
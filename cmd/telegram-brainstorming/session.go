@@ -0,0 +1,95 @@
+package main
+
+import (
+	"path/filepath"
+	"time"
+
+	"codex-brainstorming-telegram/internal/sessionstore"
+	"codex-brainstorming-telegram/internal/telegrambrainstorm"
+)
+
+type sessionTracker struct {
+	store *sessionstore.Store
+	sess  sessionstore.Session
+}
+
+func openSessionTracker(dir string, id string, prompt string, options []string) (*sessionTracker, error) {
+	store := sessionstore.New(dir)
+	sess, err := store.Load(id)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sessionstore.HashPrompt(prompt, options)
+	if sess.Round == nil || sess.Round.PromptHash != hash {
+		sess.Round = &sessionstore.Round{PromptHash: hash}
+	}
+
+	return &sessionTracker{store: store, sess: sess}, nil
+}
+
+func (t *sessionTracker) answered() (sessionstore.Round, bool) {
+	return *t.sess.Round, t.sess.Round.Answered
+}
+
+func (t *sessionTracker) resumeCheckpoint() *telegrambrainstorm.Checkpoint {
+	if t.sess.Round.MessageID == 0 {
+		return nil
+	}
+	return &telegrambrainstorm.Checkpoint{
+		MessageID: t.sess.Round.MessageID,
+		Offset:    t.sess.Offset,
+		ChatID:    t.sess.Round.ChatID,
+	}
+}
+
+func (t *sessionTracker) checkpoint(cp telegrambrainstorm.Checkpoint) error {
+	if t.sess.Round.MessageID != cp.MessageID {
+		t.sess.Round.MessageID = cp.MessageID
+		t.sess.Round.SentAt = time.Now().UTC()
+	}
+	t.sess.Round.ChatID = cp.ChatID
+	t.sess.Offset = cp.Offset
+	return t.store.Save(t.sess)
+}
+
+// expire forgets the prompt of a timed-out round. The chat now shows it as
+// expired without buttons, so running the same prompt again sends a fresh
+// one instead of resuming the dead message.
+func (t *sessionTracker) expire() error {
+	t.sess.Round = &sessionstore.Round{PromptHash: t.sess.Round.PromptHash}
+	return t.store.Save(t.sess)
+}
+
+func (t *sessionTracker) recordReply(result promptResult) error {
+	t.sess.Round.Answered = true
+	t.sess.Round.ChatID = result.ChatID
+	t.sess.Round.RawReply = result.RawReply
+	t.sess.Round.NormalizedReply = result.NormalizedReply
+	t.sess.Round.Choices = result.Choices
+	t.sess.Round.ReplyMessageID = result.ReplyMessageID
+	t.sess.Round.FilePaths = nil
+	for _, f := range result.Files {
+		t.sess.Round.FilePaths = append(t.sess.Round.FilePaths, f.Path)
+	}
+	t.sess.Round.RepliedAt = time.Now().UTC()
+	return t.store.Save(t.sess)
+}
+
+func roundResult(round sessionstore.Round) promptResult {
+	var files []telegrambrainstorm.ReceivedFile
+	for _, p := range round.FilePaths {
+		files = append(files, telegrambrainstorm.ReceivedFile{Path: p, FileName: filepath.Base(p)})
+	}
+	return promptResult{
+		ChatID:          round.ChatID,
+		RawReply:        round.RawReply,
+		NormalizedReply: round.NormalizedReply,
+		Choices:         round.Choices,
+		PromptMessageID: round.MessageID,
+		ReplyMessageID:  round.ReplyMessageID,
+		Files:           files,
+		SentAt:          round.SentAt,
+		RepliedAt:       round.RepliedAt,
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"codex-brainstorming-telegram/internal/telegramapi"
+	"codex-brainstorming-telegram/internal/updatespool"
+)
+
+const (
+	spoolFlagUsage     = "append updates acknowledged without being used (other chats, topics, senders, or sent before the prompt) to the spool file for other tools"
+	spoolFileFlagUsage = "spool file (default: " + updatespool.DefaultFileName + " next to --env)"
+)
+
+// spoolUnrelated returns the PromptOptions.OnUnrelated hook for --spool, or
+// nil when spooling is off.
+func spoolUnrelated(enabled bool, path string, envPath string) func([]telegramapi.Update) error {
+	if !enabled {
+		return nil
+	}
+	if path == "" {
+		path = updatespool.DefaultPath(envPath)
+	}
+	return func(updates []telegramapi.Update) error {
+		return updatespool.Append(path, updates...)
+	}
+}
+
+// runSpool prints spooled updates to stdout as JSON lines, oldest first.
+func runSpool(stdout io.Writer, stderr io.Writer, args []string) int {
+	fs := flag.NewFlagSet("telegram-brainstorming spool", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	envPath := fs.String("env", ".env", "path to .env file (locates the default spool file)")
+	spoolFile := fs.String("spool-file", "", spoolFileFlagUsage)
+	chatID := fs.Int64("chat-id", 0, "only print updates from this chat")
+	clear := fs.Bool("clear", false, "remove the spool after printing it")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *clear && *chatID != 0 {
+		fmt.Fprintln(stderr, "clear cannot be combined with chat-id")
+		return 2
+	}
+	path := *spoolFile
+	if path == "" {
+		path = updatespool.DefaultPath(*envPath)
+	}
+
+	entries, err := updatespool.Read(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "读取暂存更新失败：%v\n", err)
+		return 1
+	}
+
+	enc := json.NewEncoder(stdout)
+	printed := 0
+	for _, e := range entries {
+		if *chatID != 0 && spooledChatID(e.Update) != *chatID {
+			continue
+		}
+		if err := enc.Encode(e.Update); err != nil {
+			fmt.Fprintf(stderr, "write update failed: %v\n", err)
+			return 1
+		}
+		printed++
+	}
+
+	if *clear {
+		if err := updatespool.Clear(path); err != nil {
+			fmt.Fprintf(stderr, "清空暂存更新失败：%v\n", err)
+			return 1
+		}
+		fmt.Fprintf(stderr, "已输出 %d 条暂存更新，并清空暂存文件。\n", printed)
+		return 0
+	}
+	fmt.Fprintf(stderr, "已输出 %d 条暂存更新。\n", printed)
+	return 0
+}
+
+func spooledChatID(u telegramapi.Update) int64 {
+	if u.CallbackQuery != nil && u.CallbackQuery.Message != nil {
+		return u.CallbackQuery.Message.Chat.ID
+	}
+	return u.Message.Chat.ID
+}
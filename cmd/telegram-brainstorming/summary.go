@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"codex-brainstorming-telegram/internal/sessionstore"
+	"codex-brainstorming-telegram/internal/telegramapi"
+	"codex-brainstorming-telegram/internal/telegramformat"
+)
+
+const (
+	// maxSummaryQuestion and maxSummaryDecision keep one round to a couple
+	// of lines; the full text is in the transcript and the chat above.
+	maxSummaryQuestion = 120
+	maxSummaryDecision = 300
+)
+
+func runSummary(parent context.Context, stdin io.Reader, stdout io.Writer, stderr io.Writer, args []string) int {
+	fs := flag.NewFlagSet("telegram-brainstorming summary", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	cf := addConfigFlags(fs)
+	envPath := cf.envPath
+	apiBase := fs.String("api-base", "https://api.telegram.org", "telegram API base URL")
+	transcriptID := fs.String("transcript", "", "transcript ID to summarize")
+	sessionDir := fs.String("session-dir", "", "session state directory (default: .telegram-sessions next to --env)")
+	title := fs.String("title", "", "digest title (default: the transcript ID)")
+	specFile := fs.String("spec-file", "", "settled spec (purpose, constraints, success criteria) appended to the digest as Markdown (- reads stdin)")
+	parseModeFlag := fs.String("parse-mode", string(telegramformat.ParseModeMarkdownV2), "digest formatting: none, markdownv2 or html")
+	pin := fs.Bool("pin", true, "pin the digest in the chat")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *transcriptID == "" {
+		fmt.Fprintln(stderr, "transcript is required")
+		return 2
+	}
+	if err := sessionstore.ValidateID(*transcriptID); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	parseMode, err := telegramformat.ParseParseMode(*parseModeFlag)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	var spec string
+	if *specFile != "" {
+		spec, err = readSpecFile(*specFile, stdin)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+	}
+
+	cfg, err := cf.load()
+	if err != nil {
+		fmt.Fprintf(stderr, "load config failed: %v\n", err)
+		return 2
+	}
+
+	dir := *sessionDir
+	if dir == "" {
+		dir = sessionstore.DefaultDir(*envPath)
+	}
+	entries, err := sessionstore.New(dir).Transcript(*transcriptID)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(stderr, "会话 %s 没有记录：请先用 --transcript %s 运行提问。\n", *transcriptID, *transcriptID)
+			return 1
+		}
+		fmt.Fprintf(stderr, "读取会话记录失败：%v\n", err)
+		return 1
+	}
+	if len(entries) == 0 && spec == "" {
+		fmt.Fprintf(stderr, "会话 %s 没有可总结的问题。\n", *transcriptID)
+		return 1
+	}
+
+	heading := strings.TrimSpace(*title)
+	if heading == "" {
+		heading = *transcriptID
+	}
+	chunks := telegramformat.Render(buildSummary(heading, entries, spec), parseMode, telegramformat.MaxMessageLength)
+
+	httpClient, err := buildHTTPClient(cfg.ProxyURL)
+	if err != nil {
+		fmt.Fprintf(stderr, "proxy config error: %v\n", err)
+		return 2
+	}
+	api := telegramapi.NewClient(*apiBase, cfg.BotToken, httpClient)
+	defer printMigrationHint(stderr, api, cfg.ChatID)
+
+	ctx, cancel := context.WithTimeout(parent, 2*time.Minute)
+	defer cancel()
+
+	// The first chunk starts the digest, so that is the one pinned and
+	// printed for later reference.
+	var digestID int64
+	for i, chunk := range chunks {
+		id, err := api.SendMessageWithOptions(ctx, cfg.ChatID, chunk, telegramapi.SendOptions{
+			ParseMode:       string(parseMode),
+			MessageThreadID: cfg.ThreadID,
+		})
+		if err != nil {
+			fmt.Fprintf(stderr, "发送总结失败：%v\n", err)
+			printHint(stderr, err)
+			return 1
+		}
+		if i == 0 {
+			digestID = id
+		}
+	}
+
+	if *pin {
+		if err := api.PinChatMessage(ctx, cfg.ChatID, digestID); err != nil {
+			fmt.Fprintf(stderr, "总结已发送，但置顶失败：%v\n", err)
+			printHint(stderr, err)
+			fmt.Fprintln(stdout, digestID)
+			return 1
+		}
+		fmt.Fprintln(stderr, "总结已发送并置顶。")
+	} else {
+		fmt.Fprintln(stderr, "总结已发送。")
+	}
+	fmt.Fprintln(stdout, digestID)
+	return 0
+}
+
+// buildSummary writes the digest as Markdown for telegramformat: a heading,
+// one entry per round with its decision, then the settled spec.
+func buildSummary(title string, entries []sessionstore.TranscriptEntry, spec string) string {
+	answered := 0
+	for _, e := range entries {
+		if e.Status == sessionstore.StatusAnswered {
+			answered++
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# 📋 头脑风暴总结：%s\n\n", title)
+	if len(entries) > 0 {
+		fmt.Fprintf(&b, "共 %d 个问题，已回答 %d 个。\n", len(entries), answered)
+	}
+	for i, e := range entries {
+		fmt.Fprintf(&b, "\n**%d. %s**\n", i+1, summaryQuestion(e.Prompt))
+		fmt.Fprintf(&b, "%s\n", summaryDecision(e))
+	}
+	if spec != "" {
+		b.WriteString("\n## 结论\n\n")
+		b.WriteString(spec)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// summaryQuestion is the first line of the prompt with Markdown markers
+// removed, since prompts often open with a heading or a bullet.
+func summaryQuestion(prompt string) string {
+	for _, line := range strings.Split(prompt, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(line, "#>*-+ \t"))
+		line = strings.ReplaceAll(line, "**", "")
+		if line != "" {
+			return truncateRunes(line, maxSummaryQuestion)
+		}
+	}
+	return "（空问题）"
+}
+
+func summaryDecision(e sessionstore.TranscriptEntry) string {
+	if e.Status != sessionstore.StatusAnswered {
+		return "⌛ 未回答（已超时）"
+	}
+	decision := strings.Join(strings.Fields(e.NormalizedReply), " ")
+	if len(e.FilePaths) > 0 {
+		files := fmt.Sprintf("（附 %d 个文件）", len(e.FilePaths))
+		decision = strings.TrimSpace(decision + " " + files)
+	}
+	if decision == "" {
+		decision = "（空回复）"
+	}
+	return "✅ " + strings.ReplaceAll(truncateRunes(decision, maxSummaryDecision), "**", "")
+}
+
+func readSpecFile(path string, stdin io.Reader) (string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("read spec file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func truncateRunes(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n]) + "…"
+	}
+	return s
+}
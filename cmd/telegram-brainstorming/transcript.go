@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"codex-brainstorming-telegram/internal/sessionstore"
+	"codex-brainstorming-telegram/internal/telegrambrainstorm"
+)
+
+const (
+	exportMarkdown = "markdown"
+	exportJSON     = "json"
+)
+
+// transcriptRecorder appends finished rounds to a session transcript. A nil
+// recorder records nothing.
+type transcriptRecorder struct {
+	store  *sessionstore.Store
+	id     string
+	stderr io.Writer
+}
+
+func newTranscriptRecorder(sessionDir string, envPath string, id string, stderr io.Writer) (*transcriptRecorder, error) {
+	if id == "" {
+		return nil, nil
+	}
+	if err := sessionstore.ValidateID(id); err != nil {
+		return nil, err
+	}
+	if sessionDir == "" {
+		sessionDir = sessionstore.DefaultDir(envPath)
+	}
+	return &transcriptRecorder{store: sessionstore.New(sessionDir), id: id, stderr: stderr}, nil
+}
+
+// record appends an answered or timed-out round. Other failures are not
+// rounds and are left out. A transcript that cannot be written is reported
+// on stderr; the answer is still returned.
+func (t *transcriptRecorder) record(prompt string, options []string, result promptResult, err error) {
+	if t == nil {
+		return
+	}
+
+	entry := sessionstore.TranscriptEntry{
+		Prompt:          prompt,
+		Options:         options,
+		PromptMessageID: result.PromptMessageID,
+		SentAt:          result.SentAt.UTC(),
+	}
+	switch {
+	case err == nil:
+		entry.Status = sessionstore.StatusAnswered
+	case errors.Is(err, telegrambrainstorm.ErrSessionTimeout):
+		entry.Status = sessionstore.StatusTimeout
+	default:
+		return
+	}
+	if entry.Status == sessionstore.StatusAnswered {
+		entry.RawReply = result.RawReply
+		entry.NormalizedReply = result.NormalizedReply
+		entry.Choices = result.Choices
+		entry.ReplyMessageID = result.ReplyMessageID
+		entry.SenderID = result.Sender.ID
+		entry.SenderUsername = result.Sender.Username
+		for _, f := range result.Files {
+			entry.FilePaths = append(entry.FilePaths, f.Path)
+		}
+		entry.Transcribed = result.Transcribed
+		entry.Corrected = result.Corrected
+		entry.RepliedAt = result.RepliedAt.UTC()
+	}
+
+	if err := t.store.AppendTranscript(t.id, entry); err != nil {
+		fmt.Fprintf(t.stderr, "保存会话记录失败：%v\n", err)
+	}
+}
+
+func runExport(stdout io.Writer, stderr io.Writer, args []string) int {
+	fs := flag.NewFlagSet("telegram-brainstorming export", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	envPath := fs.String("env", ".env", "path to .env file (locates the default session directory)")
+	transcriptID := fs.String("transcript", "", "transcript ID to export")
+	sessionDir := fs.String("session-dir", "", "session state directory (default: .telegram-sessions next to --env)")
+	format := fs.String("format", exportMarkdown, "output format: markdown or json")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *transcriptID == "" {
+		fmt.Fprintln(stderr, "transcript is required")
+		return 2
+	}
+	if *format != exportMarkdown && *format != exportJSON {
+		fmt.Fprintln(stderr, "format must be markdown or json")
+		return 2
+	}
+	if err := sessionstore.ValidateID(*transcriptID); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	dir := *sessionDir
+	if dir == "" {
+		dir = sessionstore.DefaultDir(*envPath)
+	}
+	entries, err := sessionstore.New(dir).Transcript(*transcriptID)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(stderr, "会话 %s 没有记录：请先用 --transcript %s 运行提问。\n", *transcriptID, *transcriptID)
+			return 1
+		}
+		fmt.Fprintf(stderr, "读取会话记录失败：%v\n", err)
+		return 1
+	}
+
+	if *format == exportJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(transcriptExport{Session: *transcriptID, Rounds: entries}); err != nil {
+			fmt.Fprintf(stderr, "write export failed: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	if _, err := io.WriteString(stdout, renderTranscriptMarkdown(*transcriptID, entries)); err != nil {
+		fmt.Fprintf(stderr, "write export failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+type transcriptExport struct {
+	Session string                         `json:"session"`
+	Rounds  []sessionstore.TranscriptEntry `json:"rounds"`
+}
+
+// renderTranscriptMarkdown lays the rounds out for a design doc or PR:
+// one section per round with the prompt quoted and the answer below it.
+func renderTranscriptMarkdown(id string, entries []sessionstore.TranscriptEntry) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Brainstorming session `%s`\n", id)
+
+	for i, e := range entries {
+		fmt.Fprintf(&b, "\n## Round %d\n\n", i+1)
+		fmt.Fprintf(&b, "- Asked: %s%s\n", formatTranscriptTime(e.SentAt), messageRef(e.PromptMessageID))
+		if e.Status == sessionstore.StatusTimeout {
+			b.WriteString("- Status: timed out without an answer\n")
+		} else {
+			fmt.Fprintf(&b, "- Answered: %s%s%s\n", formatTranscriptTime(e.RepliedAt), senderRef(e), messageRef(e.ReplyMessageID))
+		}
+
+		b.WriteString("\n")
+		for _, line := range strings.Split(e.Prompt, "\n") {
+			b.WriteString(strings.TrimRight("> "+line, " ") + "\n")
+		}
+		if len(e.Options) > 0 {
+			fmt.Fprintf(&b, "\nOptions: %s\n", strings.Join(e.Options, " / "))
+		}
+		if e.Status == sessionstore.StatusTimeout {
+			continue
+		}
+
+		answer := e.NormalizedReply
+		if answer == "" && len(e.FilePaths) == 0 {
+			answer = "(empty)"
+		}
+		var notes []string
+		if e.Transcribed {
+			notes = append(notes, "voice")
+		}
+		if e.Corrected {
+			notes = append(notes, "corrected")
+		}
+		note := ""
+		if len(notes) > 0 {
+			note = " _(" + strings.Join(notes, ", ") + ")_"
+		}
+		if strings.Contains(answer, "\n") {
+			fmt.Fprintf(&b, "\n**Answer**%s:\n\n", note)
+			for _, line := range strings.Split(answer, "\n") {
+				b.WriteString(strings.TrimRight("    "+line, " ") + "\n")
+			}
+		} else if answer != "" {
+			fmt.Fprintf(&b, "\n**Answer**%s: %s\n", note, answer)
+		}
+		for _, p := range e.FilePaths {
+			fmt.Fprintf(&b, "\n- File: `%s`", p)
+		}
+		if len(e.FilePaths) > 0 {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+func formatTranscriptTime(t time.Time) string {
+	if t.IsZero() {
+		return "unknown time"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func messageRef(id int64) string {
+	if id == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (message %d)", id)
+}
+
+func senderRef(e sessionstore.TranscriptEntry) string {
+	switch {
+	case e.SenderUsername != "":
+		return " by @" + e.SenderUsername
+	case e.SenderID != 0:
+		return fmt.Sprintf(" by user %d", e.SenderID)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"codex-brainstorming-telegram/internal/config"
+	"codex-brainstorming-telegram/internal/transcribe"
+)
+
+// newTranscriber returns the whisper.cpp transcriber configured in .env, or
+// nil when voice replies are not enabled.
+func newTranscriber(cfg config.TelegramConfig, stderr io.Writer) transcribe.Transcriber {
+	if cfg.WhisperBinary == "" {
+		return nil
+	}
+	return loggingTranscriber{
+		next: transcribe.WhisperCPP{
+			Binary:   cfg.WhisperBinary,
+			Model:    cfg.WhisperModel,
+			Language: cfg.WhisperLanguage,
+		},
+		stderr: stderr,
+	}
+}
+
+// loggingTranscriber reports failures on stderr; the chat only gets a generic
+// retry notice, so this is where a misconfigured model shows up.
+type loggingTranscriber struct {
+	next   transcribe.Transcriber
+	stderr io.Writer
+}
+
+func (t loggingTranscriber) Transcribe(ctx context.Context, in transcribe.Input) (string, error) {
+	text, err := t.next.Transcribe(ctx, in)
+	if err != nil {
+		fmt.Fprintf(t.stderr, "语音转写失败：%v\n", err)
+	}
+	return text, err
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"codex-brainstorming-telegram/internal/telegramapi"
+	"codex-brainstorming-telegram/internal/telegramwebhook"
+)
+
+type updateSource interface {
+	GetUpdates(ctx context.Context, offset int64, timeoutSec int) ([]telegramapi.Update, error)
+}
+
+// routedAPI sends through the Bot API client but reads updates from another
+// source, such as a webhook receiver.
+type routedAPI struct {
+	*telegramapi.Client
+	updates updateSource
+}
+
+func (a routedAPI) GetUpdates(ctx context.Context, offset int64, timeoutSec int) ([]telegramapi.Update, error) {
+	return a.updates.GetUpdates(ctx, offset, timeoutSec)
+}
+
+type webhookListener struct {
+	receiver *telegramwebhook.Receiver
+	server   *http.Server
+	client   *telegramapi.Client
+}
+
+// startWebhook serves the receiver on listenAddr and registers publicURL with
+// Telegram. The path of publicURL is the path served locally, so a reverse
+// proxy can forward it unchanged.
+func startWebhook(ctx context.Context, client *telegramapi.Client, publicURL string, listenAddr string, secret string) (*webhookListener, error) {
+	u, err := url.Parse(publicURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q", publicURL)
+	}
+	if secret == "" {
+		secret, err = randomSecret()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	receiver := telegramwebhook.NewReceiver(secret)
+	mux := http.NewServeMux()
+	mux.Handle(path, receiver)
+
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("listen webhook: %w", err)
+	}
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		_ = server.Serve(ln)
+	}()
+
+	if err := client.SetWebhook(ctx, publicURL, secret); err != nil {
+		_ = server.Close()
+		return nil, fmt.Errorf("set webhook: %w", err)
+	}
+
+	return &webhookListener{receiver: receiver, server: server, client: client}, nil
+}
+
+// Close removes the webhook so later polling runs keep working, then stops
+// the local server.
+func (w *webhookListener) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	deleteErr := w.client.DeleteWebhook(ctx, false)
+	if deleteErr != nil {
+		deleteErr = fmt.Errorf("delete webhook: %w", deleteErr)
+	}
+	return errors.Join(deleteErr, w.server.Shutdown(ctx))
+}
+
+func randomSecret() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("generate webhook secret: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
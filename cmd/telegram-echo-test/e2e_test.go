@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"codex-brainstorming-telegram/internal/telegramfake"
+)
+
+func TestRunEndToEndAgainstFakeServer(t *testing.T) {
+	t.Parallel()
+
+	fake := telegramfake.NewServer("token")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	tmpDir := t.TempDir()
+	envPath := filepath.Join(tmpDir, ".env")
+	content := "TELEGRAM_BOT_TOKEN=token\nTELEGRAM_CHAT_ID=123\nTELEGRAM_REPLY_TIMEOUT=10s\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		sent, err := fake.WaitForSent(ctx, 1)
+		if err != nil {
+			return
+		}
+		code := regexp.MustCompile(`\[(\d{6})\]`).FindStringSubmatch(sent[0].Text)
+		if code == nil {
+			return
+		}
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: 123, Text: "000000"})
+		fake.InjectMessage(telegramfake.UserMessage{ChatID: 123, Text: code[1]})
+	}()
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"--env", envPath, "--api-base", srv.URL})
+	if exitCode != 0 {
+		t.Fatalf("run() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "测试成功") {
+		t.Fatalf("stdout = %q, want success", stdout.String())
+	}
+}
@@ -67,16 +67,25 @@ func run(parent context.Context, stdout io.Writer, stderr io.Writer, args []stri
 	ctx, cancel := context.WithTimeout(parent, cfg.ReplyTimeout+30*time.Second)
 	defer cancel()
 
-	err = telegramtest.RunChallenge(ctx, apiClient, cfg.ChatID, code, cfg.ReplyTimeout)
+	err = telegramtest.RunChallenge(ctx, apiClient, cfg.ChatID, code, cfg.ReplyTimeout, telegramtest.ChallengeOptions{
+		AllowedUserIDs: cfg.AllowedUserIDs,
+		ThreadID:       cfg.ThreadID,
+	})
 	if err != nil {
 		if errors.Is(err, telegramtest.ErrChallengeTimeout) {
 			fmt.Fprintln(stderr, "测试失败: 等待超时，未收到匹配回复")
 			return 1
 		}
 		fmt.Fprintf(stderr, "测试失败: %v\n", err)
+		if hint := telegramapi.Hint(err); hint != "" {
+			fmt.Fprintf(stderr, "提示: %s\n", hint)
+		}
 		return 1
 	}
 
+	if hint := apiClient.MigrationHint(cfg.ChatID); hint != "" {
+		fmt.Fprintf(stderr, "提示: %s\n", hint)
+	}
 	fmt.Fprintln(stdout, "测试成功: 收到匹配回复，链路未被篡改")
 	return 0
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"codex-brainstorming-telegram/internal/telegramfake"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	os.Exit(run(ctx, os.Stdout, os.Stderr, os.Args[1:]))
+}
+
+func run(ctx context.Context, stdout io.Writer, stderr io.Writer, args []string) int {
+	fs := flag.NewFlagSet("telegram-fake-server", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	addr := fs.String("addr", "127.0.0.1:8081", "listen address")
+	token := fs.String("token", "", "bot token accepted by the fake API")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if strings.TrimSpace(*token) == "" {
+		fmt.Fprintln(stderr, "token is required")
+		return 2
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fmt.Fprintf(stderr, "listen failed: %v\n", err)
+		return 1
+	}
+
+	srv := &http.Server{
+		Handler:           telegramfake.NewServer(*token),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	base := "http://" + ln.Addr().String()
+	fmt.Fprintf(stdout, "fake Telegram API listening on %s\n", base)
+	fmt.Fprintf(stdout, "point the CLIs at it with --api-base %s\n", base)
+	fmt.Fprintf(stdout, "inject replies: POST %s/control/message {\"chat_id\":123,\"text\":\"B\"}\n", base)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(stderr, "serve failed: %v\n", err)
+			return 1
+		}
+		return 0
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		fmt.Fprintf(stderr, "shutdown failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRunRequiresToken(t *testing.T) {
+	t.Parallel()
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	exitCode := run(context.Background(), &stdout, &stderr, []string{"--addr", "127.0.0.1:0"})
+	if exitCode != 2 {
+		t.Fatalf("run() exitCode = %d, want 2", exitCode)
+	}
+	if !strings.Contains(stderr.String(), "token is required") {
+		t.Fatalf("stderr = %q, want token error", stderr.String())
+	}
+}
+
+func TestRunStopsWhenContextIsCancelled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	exitCode := run(ctx, &stdout, &stderr, []string{"--addr", "127.0.0.1:0", "--token", "token"})
+	if exitCode != 0 {
+		t.Fatalf("run() exitCode = %d, stderr = %s", exitCode, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "--api-base http://127.0.0.1:") {
+		t.Fatalf("stdout = %q, want api-base hint", stdout.String())
+	}
+}
@@ -0,0 +1,129 @@
+// Package approval keeps a local, append-only log of plan decisions made in
+// Telegram. Each record is HMAC-signed so tools can check that a plan was
+// approved through the bot instead of trusting free-text interpretation.
+package approval
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"codex-brainstorming-telegram/internal/jsonl"
+)
+
+const DefaultFileName = ".telegram-approvals.jsonl"
+
+type Decision string
+
+const (
+	Approved Decision = "approved"
+	Rejected Decision = "rejected"
+	Revise   Decision = "revise"
+)
+
+type Record struct {
+	Decision         Decision  `json:"decision"`
+	PlanSHA256       string    `json:"plan_sha256"`
+	ApproverID       int64     `json:"approver_id"`
+	ApproverUsername string    `json:"approver_username,omitempty"`
+	ChatID           string    `json:"chat_id"`
+	PromptMessageID  int64     `json:"prompt_message_id"`
+	ReplyMessageID   int64     `json:"reply_message_id,omitempty"`
+	Comments         string    `json:"comments,omitempty"`
+	DecidedAt        time.Time `json:"decided_at"`
+	Signature        string    `json:"signature,omitempty"`
+}
+
+// ErrNotApproved is returned by Verify when the latest record for a plan is
+// missing, unsigned, or not an approval.
+var ErrNotApproved = errors.New("plan has no valid approval")
+
+func DefaultPath(envPath string) string {
+	return filepath.Join(filepath.Dir(envPath), DefaultFileName)
+}
+
+func HashPlan(plan []byte) string {
+	sum := sha256.Sum256(plan)
+	return hex.EncodeToString(sum[:])
+}
+
+// KeyFromToken derives the signing key from the bot token, so only holders
+// of the bot's credentials can produce records that verify.
+func KeyFromToken(botToken string) []byte {
+	mac := hmac.New(sha256.New, []byte(botToken))
+	mac.Write([]byte("telegram-brainstorming approval v1"))
+	return mac.Sum(nil)
+}
+
+// Sign returns rec with Signature set to the HMAC of its other fields.
+func Sign(rec Record, key []byte) (Record, error) {
+	sig, err := signature(rec, key)
+	if err != nil {
+		return Record{}, err
+	}
+	rec.Signature = sig
+	return rec, nil
+}
+
+func (r Record) ValidSignature(key []byte) bool {
+	want, err := signature(r, key)
+	if err != nil || r.Signature == "" {
+		return false
+	}
+	return hmac.Equal([]byte(want), []byte(r.Signature))
+}
+
+func signature(rec Record, key []byte) (string, error) {
+	rec.Signature = ""
+	rec.DecidedAt = rec.DecidedAt.UTC()
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return "", fmt.Errorf("encode approval record: %w", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Append signs rec and adds it as one JSON line to the log at path.
+func Append(path string, rec Record, key []byte) (Record, error) {
+	rec.DecidedAt = rec.DecidedAt.UTC()
+	signed, err := Sign(rec, key)
+	if err != nil {
+		return Record{}, err
+	}
+	if err := jsonl.Append(path, signed); err != nil {
+		return Record{}, fmt.Errorf("append approval log: %w", err)
+	}
+	return signed, nil
+}
+
+// Verify returns the latest validly signed record for planHash if it is an
+// approval. Records with bad signatures are ignored, so an edited log cannot
+// turn a rejection into an approval.
+func Verify(path string, planHash string, key []byte) (Record, error) {
+	records, err := jsonl.Read[Record](path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Record{}, ErrNotApproved
+	}
+	if err != nil {
+		return Record{}, fmt.Errorf("read approval log: %w", err)
+	}
+
+	var latest *Record
+	for i, rec := range records {
+		if rec.PlanSHA256 == planHash && rec.ValidSignature(key) {
+			latest = &records[i]
+		}
+	}
+	if latest == nil || latest.Decision != Approved {
+		return Record{}, ErrNotApproved
+	}
+	return *latest, nil
+}
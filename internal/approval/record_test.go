@@ -0,0 +1,95 @@
+package approval
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendAndVerify(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "nested", DefaultFileName)
+	key := KeyFromToken("123:abc")
+	planHash := HashPlan([]byte("# Plan\n"))
+
+	if _, err := Verify(path, planHash, key); !errors.Is(err, ErrNotApproved) {
+		t.Fatalf("Verify() on missing log error = %v, want %v", err, ErrNotApproved)
+	}
+
+	rec := Record{
+		Decision:        Approved,
+		PlanSHA256:      planHash,
+		ApproverID:      42,
+		ChatID:          "123",
+		PromptMessageID: 7,
+		DecidedAt:       time.Date(2026, 1, 2, 3, 4, 5, 0, time.FixedZone("CST", 8*3600)),
+	}
+	signed, err := Append(path, rec, key)
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if signed.Signature == "" {
+		t.Fatal("Append() returned an unsigned record")
+	}
+
+	got, err := Verify(path, planHash, key)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if got.ApproverID != 42 || got.PromptMessageID != 7 || !got.DecidedAt.Equal(rec.DecidedAt) {
+		t.Fatalf("Verify() = %+v", got)
+	}
+
+	if _, err := Verify(path, planHash, KeyFromToken("other")); !errors.Is(err, ErrNotApproved) {
+		t.Fatalf("Verify() with wrong key error = %v, want %v", err, ErrNotApproved)
+	}
+	if _, err := Verify(path, HashPlan([]byte("other plan")), key); !errors.Is(err, ErrNotApproved) {
+		t.Fatalf("Verify() for another plan error = %v, want %v", err, ErrNotApproved)
+	}
+}
+
+func TestVerifyUsesLatestDecision(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), DefaultFileName)
+	key := KeyFromToken("123:abc")
+	planHash := HashPlan([]byte("plan"))
+
+	for _, d := range []Decision{Approved, Rejected} {
+		if _, err := Append(path, Record{Decision: d, PlanSHA256: planHash, ApproverID: 1, DecidedAt: time.Now()}, key); err != nil {
+			t.Fatalf("Append(%s) error = %v", d, err)
+		}
+	}
+	if _, err := Verify(path, planHash, key); !errors.Is(err, ErrNotApproved) {
+		t.Fatalf("Verify() error = %v, want %v after rejection", err, ErrNotApproved)
+	}
+}
+
+func TestVerifyIgnoresTamperedRecords(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), DefaultFileName)
+	key := KeyFromToken("123:abc")
+	planHash := HashPlan([]byte("plan"))
+
+	if _, err := Append(path, Record{Decision: Rejected, PlanSHA256: planHash, ApproverID: 1, DecidedAt: time.Now()}, key); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	forged := strings.Replace(string(data), `"decision":"rejected"`, `"decision":"approved"`, 1)
+	if err := os.WriteFile(path, []byte(forged), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if _, err := Verify(path, planHash, key); !errors.Is(err, ErrNotApproved) {
+		t.Fatalf("Verify() error = %v, want %v for edited record", err, ErrNotApproved)
+	}
+}
@@ -1,10 +1,9 @@
 package config
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -12,49 +11,159 @@ import (
 const defaultReplyTimeout = 5 * time.Minute
 
 type TelegramConfig struct {
-	BotToken     string
-	ChatID       string
-	ProxyURL     string
-	ReplyTimeout time.Duration
+	BotToken string
+	// ChatID is the chat prompts go to first, ChatIDs[0].ChatID.
+	ChatID string
+	// ChatIDs are the chats a prompt escalates through, in order, each with
+	// how long to wait for it. Without TELEGRAM_CHAT_IDS it holds ChatID
+	// alone.
+	ChatIDs  []ChatTarget
+	ProxyURL string
+	// ReplyTimeout is how long the first chat is waited for.
+	ReplyTimeout  time.Duration
+	WebhookSecret string
+	// AllowedUserIDs limits who may answer prompts. Empty allows any
+	// non-bot member of the chat.
+	AllowedUserIDs []int64
+	// ThreadID is the forum topic prompts are sent to; replies from other
+	// topics are ignored. Zero uses the chat without a topic.
+	ThreadID int64
+	// WhisperBinary and WhisperModel enable voice replies through a local
+	// whisper.cpp build. WhisperLanguage defaults to auto-detection.
+	WhisperBinary   string
+	WhisperModel    string
+	WhisperLanguage string
+	// Reminders are when to nudge the chat about an unanswered prompt.
+	Reminders ReminderSchedule
 }
 
-func LoadTelegramConfig(path string) (TelegramConfig, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return TelegramConfig{}, fmt.Errorf("%s 不存在，请根据 .env.example 创建对应的 .env 文件", path)
+// ChatTarget is one chat of the escalation order.
+type ChatTarget struct {
+	ChatID  string
+	Timeout time.Duration
+}
+
+// ParseChatTargets reads a comma, semicolon or space separated list such as
+// "123456789, -1001234567890:30m": chat IDs in escalation order, each with
+// an optional timeout after a colon. Entries without one get Timeout 0.
+func ParseChatTargets(raw string) ([]ChatTarget, error) {
+	fields := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t'
+	})
+	if len(fields) == 0 {
+		return nil, errors.New("no chat IDs")
+	}
+
+	targets := make([]ChatTarget, 0, len(fields))
+	for _, f := range fields {
+		id, timeout, hasTimeout := strings.Cut(f, ":")
+		if id == "" {
+			return nil, fmt.Errorf("invalid chat target %q: missing chat ID", f)
+		}
+		t := ChatTarget{ChatID: id}
+		if hasTimeout {
+			d, err := time.ParseDuration(timeout)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid chat target %q: want a positive timeout like 30m after the colon", f)
+			}
+			t.Timeout = d
 		}
-		return TelegramConfig{}, fmt.Errorf("open env file: %w", err)
+		targets = append(targets, t)
 	}
-	defer f.Close()
+	return targets, nil
+}
+
+// Reminder is one entry of a reminder schedule: either a fraction of the
+// reply timeout ("50%") or a fixed delay after the prompt ("4m").
+type Reminder struct {
+	Fraction float64
+	After    time.Duration
+}
+
+type ReminderSchedule []Reminder
 
-	values := map[string]string{}
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
+// ParseReminders reads a comma or space separated schedule such as
+// "50%,90%" or "2m,4m". "off" and "none" give an empty schedule.
+func ParseReminders(raw string) (ReminderSchedule, error) {
+	raw = strings.TrimSpace(raw)
+	if strings.EqualFold(raw, "off") || strings.EqualFold(raw, "none") {
+		return nil, nil
+	}
+
+	fields := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	schedule := make(ReminderSchedule, 0, len(fields))
+	for _, f := range fields {
+		if pct, ok := strings.CutSuffix(f, "%"); ok {
+			v, err := strconv.ParseFloat(pct, 64)
+			if err != nil || v <= 0 || v >= 100 {
+				return nil, fmt.Errorf("invalid reminder %q: percentage must be between 0 and 100", f)
+			}
+			schedule = append(schedule, Reminder{Fraction: v / 100})
 			continue
 		}
+		d, err := time.ParseDuration(f)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid reminder %q: want a percentage like 50%% or a duration like 4m", f)
+		}
+		schedule = append(schedule, Reminder{After: d})
+	}
+	return schedule, nil
+}
 
-		key, val, ok := strings.Cut(line, "=")
-		if !ok {
+// Delays resolves the schedule against a reply timeout. Delays at or past
+// the timeout are kept; the prompt runner drops them.
+func (s ReminderSchedule) Delays(timeout time.Duration) []time.Duration {
+	delays := make([]time.Duration, 0, len(s))
+	for _, r := range s {
+		if r.Fraction > 0 {
+			delays = append(delays, time.Duration(r.Fraction*float64(timeout)))
 			continue
 		}
+		delays = append(delays, r.After)
+	}
+	return delays
+}
+
+// LoadTelegramConfig resolves the configuration from defaults, the .env
+// file at path (optional), and the process environment.
+func LoadTelegramConfig(path string) (TelegramConfig, error) {
+	return Load(Layers{EnvFile: path})
+}
 
-		key = strings.TrimSpace(key)
-		val = strings.TrimSpace(val)
-		val = strings.Trim(val, `"'`)
-		values[key] = val
+// Load resolves l and validates the result.
+func Load(l Layers) (TelegramConfig, error) {
+	r, err := Resolve(l)
+	if err != nil {
+		return TelegramConfig{}, err
+	}
+	return r.Config()
+}
+
+// Config parses and validates the resolved values.
+func (r Resolved) Config() (TelegramConfig, error) {
+	values := make(map[string]string, len(r.settings))
+	for key, s := range r.settings {
+		values[key] = s.Value
 	}
-	if err := scanner.Err(); err != nil {
-		return TelegramConfig{}, fmt.Errorf("scan env file: %w", err)
+	if !r.EnvFileFound && (values["TELEGRAM_BOT_TOKEN"] == "" || values["TELEGRAM_CHAT_ID"] == "" && values["TELEGRAM_CHAT_IDS"] == "") {
+		return TelegramConfig{}, fmt.Errorf("%s 不存在，请根据 .env.example 创建对应的 .env 文件，或设置 TELEGRAM_BOT_TOKEN 和 TELEGRAM_CHAT_ID 环境变量", r.EnvFile)
 	}
 
 	cfg := TelegramConfig{
-		BotToken:     strings.TrimSpace(values["TELEGRAM_BOT_TOKEN"]),
-		ChatID:       strings.TrimSpace(values["TELEGRAM_CHAT_ID"]),
-		ProxyURL:     strings.TrimSpace(values["TELEGRAM_PROXY_URL"]),
-		ReplyTimeout: defaultReplyTimeout,
+		BotToken:      strings.TrimSpace(values["TELEGRAM_BOT_TOKEN"]),
+		ChatID:        strings.TrimSpace(values["TELEGRAM_CHAT_ID"]),
+		ProxyURL:      strings.TrimSpace(values["TELEGRAM_PROXY_URL"]),
+		ReplyTimeout:  defaultReplyTimeout,
+		WebhookSecret: strings.TrimSpace(values["TELEGRAM_WEBHOOK_SECRET"]),
+
+		WhisperBinary:   strings.TrimSpace(values["TELEGRAM_WHISPER_BIN"]),
+		WhisperModel:    strings.TrimSpace(values["TELEGRAM_WHISPER_MODEL"]),
+		WhisperLanguage: strings.TrimSpace(values["TELEGRAM_WHISPER_LANGUAGE"]),
+	}
+	if cfg.WhisperBinary != "" && cfg.WhisperModel == "" {
+		return TelegramConfig{}, errors.New("TELEGRAM_WHISPER_MODEL is required when TELEGRAM_WHISPER_BIN is set")
 	}
 
 	if raw := strings.TrimSpace(values["TELEGRAM_REPLY_TIMEOUT"]); raw != "" {
@@ -68,6 +177,53 @@ func LoadTelegramConfig(path string) (TelegramConfig, error) {
 		cfg.ReplyTimeout = d
 	}
 
+	if raw := strings.TrimSpace(values["TELEGRAM_ALLOWED_USER_IDS"]); raw != "" {
+		ids, err := parseUserIDs(raw)
+		if err != nil {
+			return TelegramConfig{}, fmt.Errorf("parse TELEGRAM_ALLOWED_USER_IDS: %w", err)
+		}
+		cfg.AllowedUserIDs = ids
+	}
+
+	if raw := strings.TrimSpace(values["TELEGRAM_REMINDERS"]); raw != "" {
+		schedule, err := ParseReminders(raw)
+		if err != nil {
+			return TelegramConfig{}, fmt.Errorf("parse TELEGRAM_REMINDERS: %w", err)
+		}
+		cfg.Reminders = schedule
+	}
+
+	if raw := strings.TrimSpace(values["TELEGRAM_THREAD_ID"]); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id <= 0 {
+			return TelegramConfig{}, fmt.Errorf("parse TELEGRAM_THREAD_ID: invalid topic ID %q", raw)
+		}
+		cfg.ThreadID = id
+	}
+
+	if r.usesChatList() {
+		targets, err := ParseChatTargets(values["TELEGRAM_CHAT_IDS"])
+		if err != nil {
+			return TelegramConfig{}, fmt.Errorf("parse TELEGRAM_CHAT_IDS: %w", err)
+		}
+		// A timeout on the first entry replaces TELEGRAM_REPLY_TIMEOUT,
+		// unless --session-timeout set that on the command line.
+		base := cfg.ReplyTimeout
+		if targets[0].Timeout > 0 && r.Setting("TELEGRAM_REPLY_TIMEOUT").Source != SourceFlag {
+			cfg.ReplyTimeout = targets[0].Timeout
+		}
+		for i := range targets {
+			if targets[i].Timeout == 0 {
+				targets[i].Timeout = base
+			}
+		}
+		targets[0].Timeout = cfg.ReplyTimeout
+		cfg.ChatID = targets[0].ChatID
+		cfg.ChatIDs = targets
+	} else if cfg.ChatID != "" {
+		cfg.ChatIDs = []ChatTarget{{ChatID: cfg.ChatID, Timeout: cfg.ReplyTimeout}}
+	}
+
 	if cfg.BotToken == "" {
 		return TelegramConfig{}, errors.New("TELEGRAM_BOT_TOKEN is required")
 	}
@@ -77,3 +233,41 @@ func LoadTelegramConfig(path string) (TelegramConfig, error) {
 
 	return cfg, nil
 }
+
+// usesChatList reports whether TELEGRAM_CHAT_IDS decides the chats. It does
+// unless TELEGRAM_CHAT_ID comes from a later layer, such as --chat-id over a
+// list in .env.
+func (r Resolved) usesChatList() bool {
+	list := r.Setting("TELEGRAM_CHAT_IDS")
+	if list.Source == SourceUnset {
+		return false
+	}
+	single := r.Setting("TELEGRAM_CHAT_ID")
+	return single.Source == SourceUnset || sourceRank[list.Source] >= sourceRank[single.Source]
+}
+
+// sourceRank orders the layers; a higher rank overrides a lower one.
+var sourceRank = map[Source]int{
+	SourceDefault:     1,
+	SourceEnvFile:     2,
+	SourceEnvironment: 3,
+	SourceFlag:        4,
+}
+
+// parseUserIDs reads a comma, semicolon or space separated list of numeric
+// Telegram user IDs.
+func parseUserIDs(raw string) ([]int64, error) {
+	fields := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t'
+	})
+
+	ids := make([]int64, 0, len(fields))
+	for _, f := range fields {
+		id, err := strconv.ParseInt(f, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid user ID %q", f)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -13,7 +14,7 @@ func TestLoadTelegramConfigFromEnvFile(t *testing.T) {
 
 	tmpDir := t.TempDir()
 	envPath := filepath.Join(tmpDir, ".env")
-	content := "# sample\nTELEGRAM_BOT_TOKEN=abc123\nTELEGRAM_CHAT_ID=987654\nTELEGRAM_PROXY_URL=http://127.0.0.1:7890\nTELEGRAM_REPLY_TIMEOUT=4m\n"
+	content := "# sample\nTELEGRAM_BOT_TOKEN=abc123\nTELEGRAM_CHAT_ID=987654\nTELEGRAM_PROXY_URL=http://127.0.0.1:7890\nTELEGRAM_REPLY_TIMEOUT=4m\nTELEGRAM_WEBHOOK_SECRET=hook-secret\n"
 	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
 		t.Fatalf("WriteFile() error = %v", err)
 	}
@@ -35,6 +36,9 @@ func TestLoadTelegramConfigFromEnvFile(t *testing.T) {
 	if cfg.ReplyTimeout != 4*time.Minute {
 		t.Fatalf("ReplyTimeout = %s, want %s", cfg.ReplyTimeout, 4*time.Minute)
 	}
+	if cfg.WebhookSecret != "hook-secret" {
+		t.Fatalf("WebhookSecret = %q, want %q", cfg.WebhookSecret, "hook-secret")
+	}
 }
 
 func TestLoadTelegramConfigMissingEnvFileHasActionableMessage(t *testing.T) {
@@ -88,3 +92,129 @@ func TestLoadTelegramConfigUsesDefaultTimeout(t *testing.T) {
 		t.Fatalf("ReplyTimeout = %s, want %s", cfg.ReplyTimeout, 5*time.Minute)
 	}
 }
+
+func TestLoadTelegramConfigGroupSettings(t *testing.T) {
+	t.Parallel()
+
+	envPath := filepath.Join(t.TempDir(), ".env")
+	content := "TELEGRAM_BOT_TOKEN=abc123\nTELEGRAM_CHAT_ID=-100987\nTELEGRAM_ALLOWED_USER_IDS=\"111, 222;333\"\nTELEGRAM_THREAD_ID=9\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	cfg, err := LoadTelegramConfig(envPath)
+	if err != nil {
+		t.Fatalf("LoadTelegramConfig() error = %v", err)
+	}
+	if got := fmt.Sprint(cfg.AllowedUserIDs); got != "[111 222 333]" {
+		t.Fatalf("AllowedUserIDs = %s, want [111 222 333]", got)
+	}
+	if cfg.ThreadID != 9 {
+		t.Fatalf("ThreadID = %d, want 9", cfg.ThreadID)
+	}
+
+	bad := "TELEGRAM_BOT_TOKEN=abc123\nTELEGRAM_CHAT_ID=-100987\nTELEGRAM_ALLOWED_USER_IDS=111,@alice\n"
+	if err := os.WriteFile(envPath, []byte(bad), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := LoadTelegramConfig(envPath); err == nil || !strings.Contains(err.Error(), "@alice") {
+		t.Fatalf("LoadTelegramConfig() error = %v, want invalid user ID error", err)
+	}
+}
+
+func TestLoadTelegramConfigWhisperRequiresModel(t *testing.T) {
+	t.Parallel()
+
+	envPath := filepath.Join(t.TempDir(), ".env")
+	content := "TELEGRAM_BOT_TOKEN=abc123\nTELEGRAM_CHAT_ID=1\nTELEGRAM_WHISPER_BIN=/opt/whisper/whisper-cli\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := LoadTelegramConfig(envPath); err == nil || !strings.Contains(err.Error(), "TELEGRAM_WHISPER_MODEL") {
+		t.Fatalf("LoadTelegramConfig() error = %v, want missing model error", err)
+	}
+
+	content += "TELEGRAM_WHISPER_MODEL=/opt/whisper/ggml-base.bin\nTELEGRAM_WHISPER_LANGUAGE=zh\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	cfg, err := LoadTelegramConfig(envPath)
+	if err != nil {
+		t.Fatalf("LoadTelegramConfig() error = %v", err)
+	}
+	if cfg.WhisperModel != "/opt/whisper/ggml-base.bin" || cfg.WhisperLanguage != "zh" {
+		t.Fatalf("cfg = %+v", cfg)
+	}
+}
+
+func TestLoadTelegramConfigReminders(t *testing.T) {
+	t.Parallel()
+
+	envPath := filepath.Join(t.TempDir(), ".env")
+	content := "TELEGRAM_BOT_TOKEN=abc123\nTELEGRAM_CHAT_ID=1\nTELEGRAM_REMINDERS=50%, 90%,4m\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	cfg, err := LoadTelegramConfig(envPath)
+	if err != nil {
+		t.Fatalf("LoadTelegramConfig() error = %v", err)
+	}
+	if got := fmt.Sprint(cfg.Reminders.Delays(10 * time.Minute)); got != "[5m0s 9m0s 4m0s]" {
+		t.Fatalf("Delays(10m) = %s, want [5m0s 9m0s 4m0s]", got)
+	}
+
+	for _, raw := range []string{"0%", "100%", "soon", "-1m"} {
+		if _, err := ParseReminders(raw); err == nil {
+			t.Fatalf("ParseReminders(%q) error = nil, want non-nil", raw)
+		}
+	}
+	if schedule, err := ParseReminders("off"); err != nil || len(schedule) != 0 {
+		t.Fatalf("ParseReminders(off) = %v, %v, want empty schedule", schedule, err)
+	}
+}
+
+func TestLoadTelegramConfigChatTargets(t *testing.T) {
+	t.Parallel()
+
+	envPath := filepath.Join(t.TempDir(), ".env")
+	content := "TELEGRAM_BOT_TOKEN=abc123\nTELEGRAM_CHAT_IDS=111, -100222:30m, 333\nTELEGRAM_REPLY_TIMEOUT=5m\n"
+	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	noEnv := fakeEnv(nil)
+
+	cfg, err := Load(Layers{EnvFile: envPath, Lookup: noEnv})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := fmt.Sprint(cfg.ChatIDs); cfg.ChatID != "111" || got != "[{111 5m0s} {-100222 30m0s} {333 5m0s}]" {
+		t.Fatalf("ChatID = %q, ChatIDs = %s", cfg.ChatID, got)
+	}
+
+	// A timeout on the first chat is its reply timeout, unless a flag says
+	// otherwise.
+	cfg, err = Load(Layers{EnvFile: envPath, Lookup: fakeEnv(map[string]string{"TELEGRAM_CHAT_IDS": "111:2m,222"})})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.ReplyTimeout != 2*time.Minute || fmt.Sprint(cfg.ChatIDs) != "[{111 2m0s} {222 5m0s}]" {
+		t.Fatalf("ReplyTimeout = %s, ChatIDs = %v", cfg.ReplyTimeout, cfg.ChatIDs)
+	}
+	cfg, err = Load(Layers{EnvFile: envPath, Lookup: fakeEnv(map[string]string{"TELEGRAM_CHAT_IDS": "111:2m"}), Flags: map[string]string{"TELEGRAM_REPLY_TIMEOUT": "1m"}})
+	if err != nil || cfg.ReplyTimeout != time.Minute || cfg.ChatIDs[0].Timeout != time.Minute {
+		t.Fatalf("Load() = %+v, %v, want the flag timeout", cfg, err)
+	}
+
+	// --chat-id beats a list from .env.
+	cfg, err = Load(Layers{EnvFile: envPath, Lookup: noEnv, Flags: map[string]string{"TELEGRAM_CHAT_ID": "999"}})
+	if err != nil || cfg.ChatID != "999" || len(cfg.ChatIDs) != 1 {
+		t.Fatalf("Load() = %+v, %v, want only chat 999", cfg, err)
+	}
+
+	for _, raw := range []string{"111:soon", ":5m", "111:-1m", " , "} {
+		if _, err := ParseChatTargets(raw); err == nil {
+			t.Fatalf("ParseChatTargets(%q) error = nil, want non-nil", raw)
+		}
+	}
+}
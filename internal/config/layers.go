@@ -0,0 +1,185 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"codex-brainstorming-telegram/internal/telegramapi"
+)
+
+// Source names the layer a setting was taken from. Later layers win:
+// defaults, then the .env file, then the process environment, then flags.
+type Source string
+
+const (
+	SourceUnset       Source = "unset"
+	SourceDefault     Source = "default"
+	SourceEnvFile     Source = "env file"
+	SourceEnvironment Source = "environment"
+	SourceFlag        Source = "flag"
+)
+
+// Keys lists every setting in display order. Only these names are read from
+// the process environment.
+var Keys = []string{
+	"TELEGRAM_BOT_TOKEN",
+	"TELEGRAM_CHAT_ID",
+	"TELEGRAM_CHAT_IDS",
+	"TELEGRAM_PROXY_URL",
+	"TELEGRAM_REPLY_TIMEOUT",
+	"TELEGRAM_WEBHOOK_SECRET",
+	"TELEGRAM_ALLOWED_USER_IDS",
+	"TELEGRAM_THREAD_ID",
+	"TELEGRAM_WHISPER_BIN",
+	"TELEGRAM_WHISPER_MODEL",
+	"TELEGRAM_WHISPER_LANGUAGE",
+	"TELEGRAM_REMINDERS",
+}
+
+var defaults = map[string]string{
+	"TELEGRAM_REPLY_TIMEOUT": defaultReplyTimeout.String(),
+}
+
+type Setting struct {
+	Key    string
+	Value  string
+	Source Source
+}
+
+// Layers are the inputs Resolve merges over the defaults.
+type Layers struct {
+	// EnvFile is the .env path. A missing file is skipped, so secrets can
+	// come from the environment alone.
+	EnvFile string
+	// Lookup reads the process environment; nil uses os.LookupEnv.
+	Lookup func(string) (string, bool)
+	// Flags are command-line overrides keyed by setting name.
+	Flags map[string]string
+}
+
+type Resolved struct {
+	EnvFile      string
+	EnvFileFound bool
+	settings     map[string]Setting
+}
+
+// Resolve merges the layers without validating values. Empty values never
+// override a lower layer, so an exported but blank variable is ignored.
+func Resolve(l Layers) (Resolved, error) {
+	r := Resolved{EnvFile: l.EnvFile, settings: map[string]Setting{}}
+	set := func(key, val string, src Source) {
+		if val = strings.TrimSpace(val); val != "" {
+			r.settings[key] = Setting{Key: key, Value: val, Source: src}
+		}
+	}
+
+	for key, val := range defaults {
+		set(key, val, SourceDefault)
+	}
+
+	if l.EnvFile != "" {
+		values, err := readEnvFile(l.EnvFile)
+		switch {
+		case err == nil:
+			r.EnvFileFound = true
+			for key, val := range values {
+				set(key, val, SourceEnvFile)
+			}
+		case !errors.Is(err, os.ErrNotExist):
+			return Resolved{}, err
+		}
+	}
+
+	lookup := l.Lookup
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	for _, key := range Keys {
+		if val, ok := lookup(key); ok {
+			set(key, val, SourceEnvironment)
+		}
+	}
+
+	for key, val := range l.Flags {
+		set(key, val, SourceFlag)
+	}
+	return r, nil
+}
+
+// Setting returns the effective value of key, or an unset setting.
+func (r Resolved) Setting(key string) Setting {
+	if s, ok := r.settings[key]; ok {
+		return s
+	}
+	return Setting{Key: key, Source: SourceUnset}
+}
+
+// Settings returns every known setting in Keys order, set or not.
+func (r Resolved) Settings() []Setting {
+	out := make([]Setting, 0, len(Keys))
+	for _, key := range Keys {
+		out = append(out, r.Setting(key))
+	}
+	return out
+}
+
+// Redacted returns the value safe to print: the bot token keeps only its
+// public bot ID, the webhook secret is hidden, and a proxy password is
+// masked.
+func (s Setting) Redacted() string {
+	if s.Value == "" {
+		return ""
+	}
+	switch s.Key {
+	case "TELEGRAM_BOT_TOKEN":
+		return telegramapi.RedactToken(s.Value)
+	case "TELEGRAM_WEBHOOK_SECRET":
+		return "***"
+	case "TELEGRAM_PROXY_URL":
+		u, err := url.Parse(s.Value)
+		if err != nil {
+			return "***"
+		}
+		return u.Redacted()
+	default:
+		return s.Value
+	}
+}
+
+func readEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("open env file: %w", err)
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+
+		key = strings.TrimSpace(key)
+		val = strings.TrimSpace(val)
+		val = strings.Trim(val, `"'`)
+		values[key] = val
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan env file: %w", err)
+	}
+	return values, nil
+}
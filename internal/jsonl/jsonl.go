@@ -0,0 +1,132 @@
+// Package jsonl appends to and reads the JSON Lines logs kept next to .env:
+// approvals, spooled updates and session transcripts.
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// MaxLine bounds one encoded record.
+const MaxLine = 1 << 24
+
+// Append encodes each value as one line and adds them to path in a single
+// write, creating the file with mode 0600 and its directory with 0700. A
+// torn final line left by a writer that crashed mid-append is removed first,
+// so it cannot merge with the new records.
+func Append[T any](path string, values ...T) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	var buf []byte
+	for _, v := range values {
+		line, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("encode record: %w", err)
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("create dir: %w", err)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o600)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	if err := dropTornTail(f); err != nil {
+		f.Close()
+		return fmt.Errorf("repair %s: %w", path, err)
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", path, err)
+	}
+	return nil
+}
+
+// Read decodes every line of path. Only a malformed final line is skipped,
+// as the remains of a crashed append; a malformed line anywhere else is
+// corruption and an error. A missing file is an error wrapping
+// os.ErrNotExist.
+func Read[T any](path string) ([]T, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var out []T
+	var torn error
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxLine)
+	for n := 1; scanner.Scan(); n++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		if torn != nil {
+			return nil, torn
+		}
+		var v T
+		if err := json.Unmarshal(scanner.Bytes(), &v); err != nil {
+			torn = fmt.Errorf("%s line %d: %w", path, n, err)
+			continue
+		}
+		out = append(out, v)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return out, nil
+}
+
+// dropTornTail makes f end with a newline. A final line that is complete
+// JSON, as from a hand edit, is kept and terminated; anything else after the
+// last newline is a partial record and is truncated.
+func dropTornTail(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	keep := int64(0)
+	buf := make([]byte, 4096)
+	for end := size; end > 0; {
+		start := max(end-int64(len(buf)), 0)
+		chunk := buf[:end-start]
+		if _, err := f.ReadAt(chunk, start); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			keep = start + int64(i) + 1
+			break
+		}
+		end = start
+	}
+	if keep == size {
+		return nil
+	}
+
+	tail := make([]byte, size-keep)
+	if _, err := f.ReadAt(tail, keep); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if tail = bytes.TrimSpace(tail); len(tail) > 0 && json.Valid(tail) {
+		_, err := f.Write([]byte("\n"))
+		return err
+	}
+	return f.Truncate(keep)
+}
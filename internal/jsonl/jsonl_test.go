@@ -0,0 +1,95 @@
+package jsonl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type record struct {
+	N int `json:"n"`
+}
+
+func TestAppendAndRead(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "sub", "log.jsonl")
+	if _, err := Read[record](path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Read() error = %v, want os.ErrNotExist", err)
+	}
+
+	if err := Append(path, record{1}, record{2}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if err := Append(path, record{3}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	got, err := Read[record](path)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(got) != 3 || got[0].N != 1 || got[2].N != 3 {
+		t.Fatalf("Read() = %+v", got)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("mode = %o, want 600", perm)
+	}
+}
+
+func TestReadSkipsOnlyTornFinalLine(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	torn := filepath.Join(dir, "torn.jsonl")
+	if err := os.WriteFile(torn, []byte("{\"n\":1}\n\n{\"n\":2}\n{\"n\":"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	got, err := Read[record](torn)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("Read() = %+v, %v, want two records and the torn line skipped", got, err)
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.jsonl")
+	if err := os.WriteFile(corrupt, []byte("{\"n\":1}\nnot json\n{\"n\":3}\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := Read[record](corrupt); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("Read() error = %v, want an error naming line 2", err)
+	}
+}
+
+func TestAppendRepairsTornTail(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	torn := filepath.Join(dir, "torn.jsonl")
+	if err := os.WriteFile(torn, []byte("{\"n\":1}\n{\"n\":"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := Append(torn, record{2}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if got, err := Read[record](torn); err != nil || len(got) != 2 || got[1].N != 2 {
+		t.Fatalf("Read() = %+v, %v, want the partial record dropped", got, err)
+	}
+
+	// A complete record missing only its newline is kept.
+	unterminated := filepath.Join(dir, "unterminated.jsonl")
+	if err := os.WriteFile(unterminated, []byte("{\"n\":1}"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := Append(unterminated, record{2}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if got, err := Read[record](unterminated); err != nil || len(got) != 2 || got[0].N != 1 {
+		t.Fatalf("Read() = %+v, %v, want both records", got, err)
+	}
+}
@@ -0,0 +1,127 @@
+package sessionstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const DefaultDirName = ".telegram-sessions"
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+type Store struct {
+	dir string
+}
+
+type Session struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+	Round  *Round `json:"round,omitempty"`
+}
+
+type Round struct {
+	PromptHash string    `json:"prompt_hash"`
+	MessageID  int64     `json:"message_id"`
+	SentAt     time.Time `json:"sent_at"`
+	// ChatID is the escalation target the prompt waits in, empty for the
+	// configured chat; once answered, the chat that answered.
+	ChatID          string    `json:"chat_id,omitempty"`
+	Answered        bool      `json:"answered"`
+	RawReply        string    `json:"raw_reply,omitempty"`
+	NormalizedReply string    `json:"normalized_reply,omitempty"`
+	Choices         []string  `json:"choices,omitempty"`
+	ReplyMessageID  int64     `json:"reply_message_id,omitempty"`
+	FilePaths       []string  `json:"file_paths,omitempty"`
+	RepliedAt       time.Time `json:"replied_at,omitempty"`
+}
+
+func New(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+func DefaultDir(envPath string) string {
+	return filepath.Join(filepath.Dir(envPath), DefaultDirName)
+}
+
+func ValidateID(id string) error {
+	if !validID.MatchString(id) || id == "." || id == ".." {
+		return fmt.Errorf("invalid session id %q: use letters, digits, '.', '_' or '-'", id)
+	}
+	return nil
+}
+
+func HashPrompt(prompt string, options []string) string {
+	h := sha256.New()
+	h.Write([]byte(prompt))
+	for _, opt := range options {
+		h.Write([]byte{0})
+		h.Write([]byte(opt))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *Store) Load(id string) (Session, error) {
+	if err := ValidateID(id); err != nil {
+		return Session{}, err
+	}
+
+	data, err := os.ReadFile(s.path(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Session{ID: id}, nil
+		}
+		return Session{}, fmt.Errorf("read session %s: %w", id, err)
+	}
+
+	var sess Session
+	if err := json.Unmarshal(data, &sess); err != nil {
+		return Session{}, fmt.Errorf("decode session %s: %w", id, err)
+	}
+	sess.ID = id
+	return sess, nil
+}
+
+func (s *Store) Save(sess Session) error {
+	if err := ValidateID(sess.ID); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("create session dir: %w", err)
+	}
+
+	data, err := json.MarshalIndent(sess, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode session %s: %w", sess.ID, err)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, sess.ID+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create session temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write session %s: %w", sess.ID, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close session temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(sess.ID)); err != nil {
+		return fmt.Errorf("replace session %s: %w", sess.ID, err)
+	}
+
+	return nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, strings.TrimSpace(id)+".json")
+}
@@ -0,0 +1,72 @@
+package sessionstore
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadMissingSessionReturnsEmpty(t *testing.T) {
+	t.Parallel()
+
+	store := New(filepath.Join(t.TempDir(), DefaultDirName))
+	sess, err := store.Load("design-1")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if sess.ID != "design-1" || sess.Round != nil || sess.Offset != 0 {
+		t.Fatalf("Load() = %+v, want empty session", sess)
+	}
+}
+
+func TestSaveAndLoadRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	store := New(filepath.Join(t.TempDir(), DefaultDirName))
+	sentAt := time.Date(2026, 2, 19, 10, 0, 0, 0, time.UTC)
+	in := Session{
+		ID:     "design-1",
+		Offset: 42,
+		Round: &Round{
+			PromptHash: HashPrompt("A/B?", []string{"A", "B"}),
+			MessageID:  7,
+			SentAt:     sentAt,
+		},
+	}
+	if err := store.Save(in); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	out, err := store.Load("design-1")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if out.Offset != 42 || out.Round == nil || out.Round.MessageID != 7 {
+		t.Fatalf("Load() = %+v", out)
+	}
+	if out.Round.PromptHash != in.Round.PromptHash || !out.Round.SentAt.Equal(sentAt) {
+		t.Fatalf("Load().Round = %+v, want %+v", out.Round, in.Round)
+	}
+}
+
+func TestHashPromptIncludesOptions(t *testing.T) {
+	t.Parallel()
+
+	if HashPrompt("A/B?", []string{"A", "B"}) == HashPrompt("A/B?", []string{"AB"}) {
+		t.Fatal("HashPrompt() ignores option boundaries")
+	}
+	if HashPrompt("A/B?", nil) != HashPrompt("A/B?", nil) {
+		t.Fatal("HashPrompt() is not deterministic")
+	}
+}
+
+func TestLoadRejectsPathLikeIDs(t *testing.T) {
+	t.Parallel()
+
+	store := New(t.TempDir())
+	for _, id := range []string{"", "..", "../x", "a/b"} {
+		if _, err := store.Load(id); err == nil {
+			t.Fatalf("Load(%q) error = nil, want invalid id", id)
+		}
+	}
+}
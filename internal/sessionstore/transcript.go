@@ -0,0 +1,70 @@
+package sessionstore
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"codex-brainstorming-telegram/internal/jsonl"
+)
+
+const (
+	StatusAnswered = "answered"
+	StatusTimeout  = "timeout"
+)
+
+// TranscriptEntry is one finished round of a session: what was asked, what
+// came back, and when.
+type TranscriptEntry struct {
+	Prompt          string    `json:"prompt"`
+	Options         []string  `json:"options,omitempty"`
+	PromptMessageID int64     `json:"prompt_message_id,omitempty"`
+	SentAt          time.Time `json:"sent_at"`
+	Status          string    `json:"status"`
+	RawReply        string    `json:"raw_reply,omitempty"`
+	NormalizedReply string    `json:"normalized_reply,omitempty"`
+	Choices         []string  `json:"choices,omitempty"`
+	ReplyMessageID  int64     `json:"reply_message_id,omitempty"`
+	SenderID        int64     `json:"sender_id,omitempty"`
+	SenderUsername  string    `json:"sender_username,omitempty"`
+	FilePaths       []string  `json:"file_paths,omitempty"`
+	Transcribed     bool      `json:"transcribed,omitempty"`
+	Corrected       bool      `json:"corrected,omitempty"`
+	RepliedAt       time.Time `json:"replied_at,omitempty"`
+	RecordedAt      time.Time `json:"recorded_at"`
+}
+
+// AppendTranscript adds a finished round to the session's transcript,
+// creating it with mode 0600. Unlike the session file, which only holds the
+// current round, the transcript keeps every round.
+func (s *Store) AppendTranscript(id string, entry TranscriptEntry) error {
+	if err := ValidateID(id); err != nil {
+		return err
+	}
+	if entry.RecordedAt.IsZero() {
+		entry.RecordedAt = time.Now().UTC()
+	}
+	if err := jsonl.Append(s.transcriptPath(id), entry); err != nil {
+		return fmt.Errorf("append transcript %s: %w", id, err)
+	}
+	return nil
+}
+
+// Transcript returns the session's rounds in the order they finished. A
+// missing transcript is an error wrapping os.ErrNotExist. Only a torn final
+// line is skipped; a corrupted round elsewhere is an error, not left out.
+func (s *Store) Transcript(id string) ([]TranscriptEntry, error) {
+	if err := ValidateID(id); err != nil {
+		return nil, err
+	}
+	entries, err := jsonl.Read[TranscriptEntry](s.transcriptPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("read transcript %s: %w", id, err)
+	}
+	return entries, nil
+}
+
+func (s *Store) transcriptPath(id string) string {
+	return filepath.Join(s.dir, strings.TrimSpace(id)+".transcript.jsonl")
+}
@@ -0,0 +1,71 @@
+package sessionstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTranscriptAppendsRoundsInOrder(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), DefaultDirName)
+	store := New(dir)
+	if _, err := store.Transcript("design-1"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Transcript() error = %v, want os.ErrNotExist", err)
+	}
+
+	rounds := []TranscriptEntry{
+		{Prompt: "A/B?", Options: []string{"A", "B"}, PromptMessageID: 7, Status: StatusAnswered, NormalizedReply: "B", ReplyMessageID: 8},
+		{Prompt: "Anything else?", PromptMessageID: 9, Status: StatusTimeout},
+	}
+	for _, e := range rounds {
+		if err := store.AppendTranscript("design-1", e); err != nil {
+			t.Fatalf("AppendTranscript() error = %v", err)
+		}
+	}
+
+	// A torn line from a crashed writer is skipped.
+	path := filepath.Join(dir, "design-1.transcript.jsonl")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	f.WriteString(`{"prompt":"torn`)
+	f.Close()
+
+	got, err := store.Transcript("design-1")
+	if err != nil {
+		t.Fatalf("Transcript() error = %v", err)
+	}
+	if len(got) != 2 || got[0].NormalizedReply != "B" || got[1].Status != StatusTimeout {
+		t.Fatalf("Transcript() = %+v", got)
+	}
+	if got[0].RecordedAt.IsZero() {
+		t.Fatal("RecordedAt is zero, want the append time")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("transcript mode = %o, want 600", perm)
+	}
+}
+
+func TestTranscriptRejectsCorruptedRound(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store := New(dir)
+	content := `{"prompt":"A/B?","status":"answered"}` + "\n" + `{"prompt":` + "\n" + `{"prompt":"Next?","status":"timeout"}` + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "design-1.transcript.jsonl"), []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if got, err := store.Transcript("design-1"); err == nil {
+		t.Fatalf("Transcript() = %+v, want an error for the corrupted middle round", got)
+	}
+}
@@ -1,31 +1,189 @@
 package telegramapi
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Client struct {
 	baseURL    string
 	botToken   string
 	httpClient *http.Client
+	retry      RetryPolicy
+	sleep      func(ctx context.Context, d time.Duration) error
+
+	mu             sync.Mutex
+	migratedChatID map[string]string
 }
 
 type Update struct {
-	UpdateID int64 `json:"update_id"`
-	Message  struct {
-		Text string `json:"text"`
-		Chat struct {
-			ID int64 `json:"id"`
-		} `json:"chat"`
-	} `json:"message"`
+	UpdateID      int64          `json:"update_id"`
+	Message       Message        `json:"message"`
+	EditedMessage *Message       `json:"edited_message,omitempty"`
+	CallbackQuery *CallbackQuery `json:"callback_query"`
+}
+
+type Message struct {
+	MessageID       int64       `json:"message_id"`
+	MessageThreadID int64       `json:"message_thread_id"`
+	Date            int64       `json:"date"`
+	Text            string      `json:"text"`
+	Chat            Chat        `json:"chat"`
+	From            *User       `json:"from"`
+	ReplyToMessage  *Message    `json:"reply_to_message"`
+	Voice           *Voice      `json:"voice,omitempty"`
+	Audio           *Audio      `json:"audio,omitempty"`
+	Photo           []PhotoSize `json:"photo,omitempty"`
+	Document        *Document   `json:"document,omitempty"`
+	Caption         string      `json:"caption,omitempty"`
+}
+
+// PhotoSize is one of the sizes Telegram generates for a photo; a message
+// lists them smallest first.
+type PhotoSize struct {
+	FileID       string `json:"file_id"`
+	FileUniqueID string `json:"file_unique_id"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	FileSize     int64  `json:"file_size,omitempty"`
+}
+
+type Document struct {
+	FileID       string `json:"file_id"`
+	FileUniqueID string `json:"file_unique_id"`
+	FileName     string `json:"file_name,omitempty"`
+	MimeType     string `json:"mime_type,omitempty"`
+	FileSize     int64  `json:"file_size,omitempty"`
+}
+
+// Voice is a voice note recorded in Telegram, usually OGG/Opus.
+type Voice struct {
+	FileID       string `json:"file_id"`
+	FileUniqueID string `json:"file_unique_id"`
+	Duration     int    `json:"duration"`
+	MimeType     string `json:"mime_type,omitempty"`
+	FileSize     int64  `json:"file_size,omitempty"`
+}
+
+// Audio is an audio file sent as music rather than recorded as a voice note.
+type Audio struct {
+	FileID       string `json:"file_id"`
+	FileUniqueID string `json:"file_unique_id"`
+	Duration     int    `json:"duration"`
+	FileName     string `json:"file_name,omitempty"`
+	MimeType     string `json:"mime_type,omitempty"`
+	FileSize     int64  `json:"file_size,omitempty"`
+}
+
+// File is the result of getFile; FilePath is passed to DownloadFile.
+type File struct {
+	FileID       string `json:"file_id"`
+	FileUniqueID string `json:"file_unique_id"`
+	FileSize     int64  `json:"file_size"`
+	FilePath     string `json:"file_path"`
+}
+
+type Chat struct {
+	ID int64 `json:"id"`
+}
+
+type User struct {
+	ID       int64  `json:"id"`
+	IsBot    bool   `json:"is_bot"`
+	Username string `json:"username"`
+}
+
+// SenderAllowed reports whether u may answer a prompt. Bots never may; when
+// allowed is non-empty, only the listed user IDs may. A missing sender (for
+// example an anonymous group admin) passes only an empty allowlist.
+func SenderAllowed(u *User, allowed []int64) bool {
+	if u == nil {
+		return len(allowed) == 0
+	}
+	if u.IsBot {
+		return false
+	}
+	if len(allowed) == 0 {
+		return true
+	}
+	return slices.Contains(allowed, u.ID)
+}
+
+type CallbackQuery struct {
+	ID      string   `json:"id"`
+	From    User     `json:"from"`
+	Message *Message `json:"message"`
+	Data    string   `json:"data"`
+}
+
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+type WebhookInfo struct {
+	URL                  string `json:"url"`
+	HasCustomCertificate bool   `json:"has_custom_certificate"`
+	PendingUpdateCount   int    `json:"pending_update_count"`
+	LastErrorDate        int64  `json:"last_error_date"`
+	LastErrorMessage     string `json:"last_error_message"`
+}
+
+type SendOptions struct {
+	ReplyMarkup      *InlineKeyboardMarkup
+	ReplyToMessageID int64
+	// MessageThreadID sends into a forum topic of a supergroup.
+	MessageThreadID int64
+	// ParseMode is sent as parse_mode ("MarkdownV2" or "HTML") when set.
+	ParseMode string
 }
 
+// EditOptions apply to editMessageText. A nil ReplyMarkup removes the
+// message's inline keyboard, as in the Bot API.
+type EditOptions struct {
+	ParseMode   string
+	ReplyMarkup *InlineKeyboardMarkup
+}
+
+// InputFile is an upload for sendDocument. The content is kept in memory so
+// a retried request can send it again.
+type InputFile struct {
+	Name string
+	Data []byte
+}
+
+type DocumentOptions struct {
+	Caption          string
+	ParseMode        string
+	ReplyToMessageID int64
+	MessageThreadID  int64
+}
+
+// MaxUploadSize is the Bot API limit for files uploaded by bots.
+const MaxUploadSize = 50 << 20
+
+// MaxDownloadSize is the Bot API limit for files bots can download.
+const MaxDownloadSize = 20 << 20
+
+// errResponseTooLarge stops reading a body that goes past MaxDownloadSize,
+// whatever getFile announced as the file size.
+var errResponseTooLarge = errors.New("response too large")
+
 type sendMessageResult struct {
 	MessageID int64 `json:"message_id"`
 }
@@ -40,35 +198,197 @@ func NewClient(baseURL string, botToken string, httpClient *http.Client) *Client
 	}
 
 	return &Client{
-		baseURL:    trimmed,
-		botToken:   strings.TrimSpace(botToken),
-		httpClient: httpClient,
+		baseURL:        trimmed,
+		botToken:       strings.TrimSpace(botToken),
+		httpClient:     httpClient,
+		retry:          DefaultRetryPolicy,
+		sleep:          sleepContext,
+		migratedChatID: map[string]string{},
 	}
 }
 
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	c.retry = policy
+}
+
 func (c *Client) SendMessage(ctx context.Context, chatID string, text string) (int64, error) {
+	return c.SendMessageWithOptions(ctx, chatID, text, SendOptions{})
+}
+
+func (c *Client) SendMessageWithOptions(ctx context.Context, chatID string, text string, opts SendOptions) (int64, error) {
 	form := url.Values{}
 	form.Set("chat_id", chatID)
 	form.Set("text", text)
+	if opts.ReplyMarkup != nil {
+		markup, err := json.Marshal(opts.ReplyMarkup)
+		if err != nil {
+			return 0, fmt.Errorf("encode reply_markup: %w", err)
+		}
+		form.Set("reply_markup", string(markup))
+	}
+	if opts.ReplyToMessageID != 0 {
+		form.Set("reply_to_message_id", fmt.Sprintf("%d", opts.ReplyToMessageID))
+	}
+	if opts.MessageThreadID != 0 {
+		form.Set("message_thread_id", fmt.Sprintf("%d", opts.MessageThreadID))
+	}
+	if opts.ParseMode != "" {
+		form.Set("parse_mode", opts.ParseMode)
+	}
 
 	respBody, err := c.postForm(ctx, "sendMessage", form)
 	if err != nil {
 		return 0, err
 	}
 
-	var apiResp struct {
-		OK          bool              `json:"ok"`
-		Description string            `json:"description"`
-		Result      sendMessageResult `json:"result"`
+	var result sendMessageResult
+	if err := decodeResponse("sendMessage", respBody, &result); err != nil {
+		return 0, err
 	}
-	if err := json.Unmarshal(respBody, &apiResp); err != nil {
-		return 0, fmt.Errorf("decode sendMessage response: %w", err)
+
+	return result.MessageID, nil
+}
+
+func (c *Client) SendDocument(ctx context.Context, chatID string, doc InputFile, opts DocumentOptions) (int64, error) {
+	if doc.Name == "" {
+		return 0, errors.New("sendDocument: file name is required")
 	}
-	if !apiResp.OK {
-		return 0, fmt.Errorf("sendMessage failed: %s", apiResp.Description)
+	if len(doc.Data) > MaxUploadSize {
+		return 0, fmt.Errorf("sendDocument: %s is %d bytes, over the %d byte upload limit", doc.Name, len(doc.Data), MaxUploadSize)
+	}
+
+	form := url.Values{}
+	form.Set("chat_id", chatID)
+	if opts.Caption != "" {
+		form.Set("caption", opts.Caption)
+	}
+	if opts.ParseMode != "" {
+		form.Set("parse_mode", opts.ParseMode)
+	}
+	if opts.ReplyToMessageID != 0 {
+		form.Set("reply_to_message_id", fmt.Sprintf("%d", opts.ReplyToMessageID))
+	}
+	if opts.MessageThreadID != 0 {
+		form.Set("message_thread_id", fmt.Sprintf("%d", opts.MessageThreadID))
 	}
 
-	return apiResp.Result.MessageID, nil
+	respBody, err := c.postMultipart(ctx, "sendDocument", form, "document", doc)
+	if err != nil {
+		return 0, err
+	}
+
+	var result sendMessageResult
+	if err := decodeResponse("sendDocument", respBody, &result); err != nil {
+		return 0, err
+	}
+
+	return result.MessageID, nil
+}
+
+func (c *Client) EditMessageText(ctx context.Context, chatID string, messageID int64, text string, opts EditOptions) error {
+	form := url.Values{}
+	form.Set("chat_id", chatID)
+	form.Set("message_id", fmt.Sprintf("%d", messageID))
+	form.Set("text", text)
+	if opts.ParseMode != "" {
+		form.Set("parse_mode", opts.ParseMode)
+	}
+	if opts.ReplyMarkup != nil {
+		markup, err := json.Marshal(opts.ReplyMarkup)
+		if err != nil {
+			return fmt.Errorf("encode reply_markup: %w", err)
+		}
+		form.Set("reply_markup", string(markup))
+	}
+
+	respBody, err := c.postForm(ctx, "editMessageText", form)
+	if err != nil {
+		return err
+	}
+	return decodeResponse("editMessageText", respBody, nil)
+}
+
+// EditMessageReplyMarkup replaces a message's inline keyboard; nil removes
+// it.
+func (c *Client) EditMessageReplyMarkup(ctx context.Context, chatID string, messageID int64, markup *InlineKeyboardMarkup) error {
+	form := url.Values{}
+	form.Set("chat_id", chatID)
+	form.Set("message_id", fmt.Sprintf("%d", messageID))
+	if markup != nil {
+		raw, err := json.Marshal(markup)
+		if err != nil {
+			return fmt.Errorf("encode reply_markup: %w", err)
+		}
+		form.Set("reply_markup", string(raw))
+	}
+
+	respBody, err := c.postForm(ctx, "editMessageReplyMarkup", form)
+	if err != nil {
+		return err
+	}
+	return decodeResponse("editMessageReplyMarkup", respBody, nil)
+}
+
+// PinChatMessage pins a message without notifying chat members.
+func (c *Client) PinChatMessage(ctx context.Context, chatID string, messageID int64) error {
+	form := url.Values{}
+	form.Set("chat_id", chatID)
+	form.Set("message_id", fmt.Sprintf("%d", messageID))
+	form.Set("disable_notification", "true")
+
+	respBody, err := c.postForm(ctx, "pinChatMessage", form)
+	if err != nil {
+		return err
+	}
+	return decodeResponse("pinChatMessage", respBody, nil)
+}
+
+func (c *Client) GetFile(ctx context.Context, fileID string) (File, error) {
+	form := url.Values{}
+	form.Set("file_id", fileID)
+
+	respBody, err := c.postForm(ctx, "getFile", form)
+	if err != nil {
+		return File{}, err
+	}
+
+	var file File
+	if err := decodeResponse("getFile", respBody, &file); err != nil {
+		return File{}, err
+	}
+	if file.FilePath == "" {
+		return File{}, fmt.Errorf("getFile: no file_path for %s", fileID)
+	}
+	return file, nil
+}
+
+// DownloadFile fetches the content of a file located with GetFile.
+func (c *Client) DownloadFile(ctx context.Context, filePath string) ([]byte, error) {
+	endpoint := fmt.Sprintf("%s/file/bot%s/%s", c.baseURL, c.botToken, strings.TrimPrefix(filePath, "/"))
+	body, err := c.call(ctx, "downloadFile", nil, func(url.Values) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	})
+	if errors.Is(err, errResponseTooLarge) {
+		return nil, fmt.Errorf("downloadFile: %s is over the %d byte download limit", filePath, MaxDownloadSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (c *Client) AnswerCallbackQuery(ctx context.Context, callbackQueryID string, text string) error {
+	form := url.Values{}
+	form.Set("callback_query_id", callbackQueryID)
+	if text != "" {
+		form.Set("text", text)
+	}
+
+	respBody, err := c.postForm(ctx, "answerCallbackQuery", form)
+	if err != nil {
+		return err
+	}
+	return decodeResponse("answerCallbackQuery", respBody, nil)
 }
 
 func (c *Client) GetUpdates(ctx context.Context, offset int64, timeoutSec int) ([]Update, error) {
@@ -83,73 +403,185 @@ func (c *Client) GetUpdates(ctx context.Context, offset int64, timeoutSec int) (
 		return nil, err
 	}
 
-	var apiResp struct {
-		OK          bool     `json:"ok"`
-		Description string   `json:"description"`
-		Result      []Update `json:"result"`
-	}
-	if err := json.Unmarshal(respBody, &apiResp); err != nil {
-		return nil, fmt.Errorf("decode getUpdates response: %w", err)
+	var updates []Update
+	if err := decodeResponse("getUpdates", respBody, &updates); err != nil {
+		return nil, err
 	}
-	if !apiResp.OK {
-		return nil, fmt.Errorf("getUpdates failed: %s", apiResp.Description)
+
+	return updates, nil
+}
+
+func (c *Client) SetWebhook(ctx context.Context, webhookURL string, secretToken string) error {
+	form := url.Values{}
+	form.Set("url", webhookURL)
+	if secretToken != "" {
+		form.Set("secret_token", secretToken)
 	}
 
-	return apiResp.Result, nil
+	respBody, err := c.postForm(ctx, "setWebhook", form)
+	if err != nil {
+		return err
+	}
+	return decodeResponse("setWebhook", respBody, nil)
 }
 
-func (c *Client) get(ctx context.Context, method string, q url.Values) ([]byte, error) {
-	endpoint := fmt.Sprintf("%s/bot%s/%s", c.baseURL, c.botToken, method)
-	if len(q) > 0 {
-		endpoint += "?" + q.Encode()
+func (c *Client) DeleteWebhook(ctx context.Context, dropPendingUpdates bool) error {
+	form := url.Values{}
+	if dropPendingUpdates {
+		form.Set("drop_pending_updates", "true")
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	respBody, err := c.postForm(ctx, "deleteWebhook", form)
 	if err != nil {
-		return nil, fmt.Errorf("build request: %w", err)
+		return err
 	}
+	return decodeResponse("deleteWebhook", respBody, nil)
+}
 
-	resp, err := c.httpClient.Do(req)
+func (c *Client) GetWebhookInfo(ctx context.Context) (WebhookInfo, error) {
+	respBody, err := c.get(ctx, "getWebhookInfo", nil)
 	if err != nil {
-		return nil, fmt.Errorf("request %s: %w", method, err)
+		return WebhookInfo{}, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("request %s: status %d", method, resp.StatusCode)
+	var info WebhookInfo
+	if err := decodeResponse("getWebhookInfo", respBody, &info); err != nil {
+		return WebhookInfo{}, err
 	}
+	return info, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("read %s response: %w", method, err)
+// decodeResponse unwraps the {"ok":...,"result":...} envelope into result,
+// which may be nil when the caller only needs the ok flag. A 2xx body with
+// "ok":false is reported as an *APIError like any other API failure.
+func decodeResponse(method string, body []byte, result any) error {
+	var apiResp struct {
+		OK          bool               `json:"ok"`
+		ErrorCode   int                `json:"error_code"`
+		Description string             `json:"description"`
+		Parameters  ResponseParameters `json:"parameters"`
+		Result      json.RawMessage    `json:"result"`
+	}
+	if err := json.Unmarshal(body, &apiResp); err != nil {
+		return fmt.Errorf("decode %s response: %w", method, err)
+	}
+	if !apiResp.OK {
+		return &APIError{
+			Method:      method,
+			StatusCode:  http.StatusOK,
+			ErrorCode:   apiResp.ErrorCode,
+			Description: apiResp.Description,
+			Parameters:  apiResp.Parameters,
+		}
 	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(apiResp.Result, result); err != nil {
+		return fmt.Errorf("decode %s result: %w", method, err)
+	}
+	return nil
+}
 
-	return body, nil
+func (c *Client) get(ctx context.Context, method string, q url.Values) ([]byte, error) {
+	return c.call(ctx, method, q, func(params url.Values) (*http.Request, error) {
+		endpoint := c.endpoint(method)
+		if len(params) > 0 {
+			endpoint += "?" + params.Encode()
+		}
+		return http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	})
 }
 
 func (c *Client) postForm(ctx context.Context, method string, form url.Values) ([]byte, error) {
-	endpoint := fmt.Sprintf("%s/bot%s/%s", c.baseURL, c.botToken, method)
+	return c.call(ctx, method, form, func(params url.Values) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint(method), strings.NewReader(params.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	})
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
-	if err != nil {
-		return nil, fmt.Errorf("build request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+// postMultipart uploads file under fileField alongside the form fields. The
+// body is rebuilt for every attempt because a retry or chat migration may
+// change the fields.
+func (c *Client) postMultipart(ctx context.Context, method string, form url.Values, fileField string, file InputFile) ([]byte, error) {
+	return c.call(ctx, method, form, func(params url.Values) (*http.Request, error) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for key, vals := range params {
+			for _, v := range vals {
+				if err := mw.WriteField(key, v); err != nil {
+					return nil, err
+				}
+			}
+		}
+		part, err := mw.CreateFormFile(fileField, file.Name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write(file.Data); err != nil {
+			return nil, err
+		}
+		if err := mw.Close(); err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint(method), &body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return req, nil
+	})
+}
 
+func (c *Client) endpoint(method string) string {
+	return fmt.Sprintf("%s/bot%s/%s", c.baseURL, c.botToken, method)
+}
+
+func (c *Client) do(method string, req *http.Request) ([]byte, error) {
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request %s: %w", method, err)
+		return nil, fmt.Errorf("request %s: %w", method, c.redactToken(err))
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("request %s: status %d", method, resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
+	// No Bot API response, not even a download, may exceed MaxDownloadSize;
+	// the extra byte tells an oversized body from one exactly at the limit.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxDownloadSize+1))
 	if err != nil {
 		return nil, fmt.Errorf("read %s response: %w", method, err)
 	}
+	if len(body) > MaxDownloadSize {
+		return nil, fmt.Errorf("read %s response: %w", method, errResponseTooLarge)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, newAPIError(method, resp.StatusCode, body)
+	}
 
 	return body, nil
 }
+
+// redactToken hides the bot token in the request URL that a *url.Error
+// repeats in its message, so transport errors are safe to log and print.
+func (c *Client) redactToken(err error) error {
+	var urlErr *url.Error
+	if c.botToken == "" || !errors.As(err, &urlErr) {
+		return err
+	}
+	redacted := *urlErr
+	redacted.URL = strings.ReplaceAll(urlErr.URL, c.botToken, RedactToken(c.botToken))
+	return &redacted
+}
+
+// RedactToken keeps only the public bot ID of a token: "123456:***".
+func RedactToken(token string) string {
+	if id, _, ok := strings.Cut(token, ":"); ok {
+		return id + ":***"
+	}
+	return "***"
+}
@@ -2,11 +2,13 @@ package telegramapi
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 )
 
 type roundTripFunc func(*http.Request) (*http.Response, error)
@@ -104,3 +106,576 @@ func TestGetUpdates(t *testing.T) {
 		t.Fatalf("chatID = %d, want 777", updates[0].Message.Chat.ID)
 	}
 }
+
+func TestSendMessageWithInlineKeyboard(t *testing.T) {
+	t.Parallel()
+
+	var gotMarkup string
+	var gotReplyTo string
+	var gotParseMode string
+	var gotThread string
+
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			vals, err := url.ParseQuery(string(body))
+			if err != nil {
+				t.Fatalf("ParseQuery() error = %v", err)
+			}
+			gotMarkup = vals.Get("reply_markup")
+			gotReplyTo = vals.Get("reply_to_message_id")
+			gotParseMode = vals.Get("parse_mode")
+			gotThread = vals.Get("message_thread_id")
+
+			return &http.Response{
+				StatusCode: 200,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(strings.NewReader(`{"ok":true,"result":{"message_id":43}}`)),
+			}, nil
+		}),
+	}
+
+	client := NewClient("https://api.telegram.test", "token123", httpClient)
+	markup := &InlineKeyboardMarkup{
+		InlineKeyboard: [][]InlineKeyboardButton{
+			{{Text: "A", CallbackData: "opt:0"}},
+			{{Text: "B", CallbackData: "opt:1"}},
+		},
+	}
+	if _, err := client.SendMessageWithOptions(context.Background(), "777", "pick", SendOptions{ReplyMarkup: markup, ReplyToMessageID: 41, ParseMode: "MarkdownV2", MessageThreadID: 9}); err != nil {
+		t.Fatalf("SendMessageWithOptions() error = %v", err)
+	}
+
+	want := `{"inline_keyboard":[[{"text":"A","callback_data":"opt:0"}],[{"text":"B","callback_data":"opt:1"}]]}`
+	if gotMarkup != want {
+		t.Fatalf("reply_markup = %s, want %s", gotMarkup, want)
+	}
+	if gotReplyTo != "41" {
+		t.Fatalf("reply_to_message_id = %q, want 41", gotReplyTo)
+	}
+	if gotParseMode != "MarkdownV2" {
+		t.Fatalf("parse_mode = %q, want MarkdownV2", gotParseMode)
+	}
+	if gotThread != "9" {
+		t.Fatalf("message_thread_id = %q, want 9", gotThread)
+	}
+}
+
+func TestAnswerCallbackQuery(t *testing.T) {
+	t.Parallel()
+
+	var gotID string
+
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			if r.URL.Path != "/bottoken123/answerCallbackQuery" {
+				t.Fatalf("path = %q", r.URL.Path)
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			vals, err := url.ParseQuery(string(body))
+			if err != nil {
+				t.Fatalf("ParseQuery() error = %v", err)
+			}
+			gotID = vals.Get("callback_query_id")
+
+			return &http.Response{
+				StatusCode: 200,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(strings.NewReader(`{"ok":true,"result":true}`)),
+			}, nil
+		}),
+	}
+
+	client := NewClient("https://api.telegram.test", "token123", httpClient)
+	if err := client.AnswerCallbackQuery(context.Background(), "cb-1", ""); err != nil {
+		t.Fatalf("AnswerCallbackQuery() error = %v", err)
+	}
+	if gotID != "cb-1" {
+		t.Fatalf("callback_query_id = %q, want cb-1", gotID)
+	}
+}
+
+func TestEditMessageTextRemovesKeyboardWithoutMarkup(t *testing.T) {
+	t.Parallel()
+
+	var got url.Values
+
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			if r.URL.Path != "/bottoken123/editMessageText" {
+				t.Fatalf("path = %q", r.URL.Path)
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			got, err = url.ParseQuery(string(body))
+			if err != nil {
+				t.Fatalf("ParseQuery() error = %v", err)
+			}
+
+			return &http.Response{
+				StatusCode: 200,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(strings.NewReader(`{"ok":true,"result":{"message_id":42}}`)),
+			}, nil
+		}),
+	}
+
+	client := NewClient("https://api.telegram.test", "token123", httpClient)
+	err := client.EditMessageText(context.Background(), "777", 42, "done", EditOptions{ParseMode: "MarkdownV2"})
+	if err != nil {
+		t.Fatalf("EditMessageText() error = %v", err)
+	}
+	if got.Get("chat_id") != "777" || got.Get("message_id") != "42" || got.Get("text") != "done" {
+		t.Fatalf("form = %v", got)
+	}
+	if got.Get("parse_mode") != "MarkdownV2" {
+		t.Fatalf("parse_mode = %q, want MarkdownV2", got.Get("parse_mode"))
+	}
+	if got.Has("reply_markup") {
+		t.Fatalf("reply_markup = %q, want omitted", got.Get("reply_markup"))
+	}
+}
+
+func TestEditMessageReplyMarkup(t *testing.T) {
+	t.Parallel()
+
+	var forms []url.Values
+
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			if r.URL.Path != "/bottoken123/editMessageReplyMarkup" {
+				t.Fatalf("path = %q", r.URL.Path)
+			}
+			if err := r.ParseForm(); err != nil {
+				t.Fatalf("ParseForm() error = %v", err)
+			}
+			forms = append(forms, r.PostForm)
+
+			return &http.Response{
+				StatusCode: 200,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(strings.NewReader(`{"ok":true,"result":{"message_id":42}}`)),
+			}, nil
+		}),
+	}
+
+	client := NewClient("https://api.telegram.test", "token123", httpClient)
+	markup := &InlineKeyboardMarkup{InlineKeyboard: [][]InlineKeyboardButton{{{Text: "A", CallbackData: "opt:0"}}}}
+	if err := client.EditMessageReplyMarkup(context.Background(), "777", 42, markup); err != nil {
+		t.Fatalf("EditMessageReplyMarkup() error = %v", err)
+	}
+	if err := client.EditMessageReplyMarkup(context.Background(), "777", 42, nil); err != nil {
+		t.Fatalf("EditMessageReplyMarkup(nil) error = %v", err)
+	}
+
+	if got := forms[0].Get("reply_markup"); !strings.Contains(got, `"callback_data":"opt:0"`) {
+		t.Fatalf("reply_markup = %q, want the keyboard", got)
+	}
+	if forms[1].Get("message_id") != "42" || forms[1].Has("reply_markup") {
+		t.Fatalf("form = %v, want reply_markup omitted to remove the keyboard", forms[1])
+	}
+}
+
+func TestGetUpdatesDecodesCallbackQuery(t *testing.T) {
+	t.Parallel()
+
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: 200,
+				Header:     make(http.Header),
+				Body: io.NopCloser(strings.NewReader(`{"ok":true,"result":[{"update_id":7,"callback_query":{"id":"cb-1","from":{"id":55},` +
+					`"message":{"message_id":42,"chat":{"id":777}},"data":"opt:1"}}]}`)),
+			}, nil
+		}),
+	}
+
+	client := NewClient("https://api.telegram.test", "token123", httpClient)
+	updates, err := client.GetUpdates(context.Background(), 0, 0)
+	if err != nil {
+		t.Fatalf("GetUpdates() error = %v", err)
+	}
+
+	if len(updates) != 1 || updates[0].CallbackQuery == nil {
+		t.Fatalf("updates = %+v, want one callback query", updates)
+	}
+	cb := updates[0].CallbackQuery
+	if cb.ID != "cb-1" || cb.Data != "opt:1" || cb.From.ID != 55 {
+		t.Fatalf("callback = %+v", cb)
+	}
+	if cb.Message == nil || cb.Message.MessageID != 42 || cb.Message.Chat.ID != 777 {
+		t.Fatalf("callback message = %+v", cb.Message)
+	}
+}
+
+func TestGetUpdatesDecodesReplyToMessage(t *testing.T) {
+	t.Parallel()
+
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: 200,
+				Header:     make(http.Header),
+				Body: io.NopCloser(strings.NewReader(`{"ok":true,"result":[{"update_id":8,"message":{"message_id":50,"text":"B",` +
+					`"chat":{"id":777},"reply_to_message":{"message_id":42,"text":"A/B?","chat":{"id":777}}}}]}`)),
+			}, nil
+		}),
+	}
+
+	client := NewClient("https://api.telegram.test", "token123", httpClient)
+	updates, err := client.GetUpdates(context.Background(), 0, 0)
+	if err != nil {
+		t.Fatalf("GetUpdates() error = %v", err)
+	}
+
+	msg := updates[0].Message
+	if msg.MessageID != 50 {
+		t.Fatalf("messageID = %d, want 50", msg.MessageID)
+	}
+	if msg.ReplyToMessage == nil || msg.ReplyToMessage.MessageID != 42 {
+		t.Fatalf("reply_to_message = %+v, want message 42", msg.ReplyToMessage)
+	}
+}
+
+func TestWebhookMethods(t *testing.T) {
+	t.Parallel()
+
+	var calls []string
+	var setForm url.Values
+
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			method := strings.TrimPrefix(r.URL.Path, "/bottoken123/")
+			calls = append(calls, method)
+
+			body := `{"ok":true,"result":true}`
+			switch method {
+			case "setWebhook":
+				raw, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Fatalf("ReadAll() error = %v", err)
+				}
+				setForm, err = url.ParseQuery(string(raw))
+				if err != nil {
+					t.Fatalf("ParseQuery() error = %v", err)
+				}
+			case "getWebhookInfo":
+				body = `{"ok":true,"result":{"url":"https://bot.example/hook","pending_update_count":3}}`
+			}
+
+			return &http.Response{
+				StatusCode: 200,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}, nil
+		}),
+	}
+
+	client := NewClient("https://api.telegram.test", "token123", httpClient)
+	ctx := context.Background()
+
+	if err := client.SetWebhook(ctx, "https://bot.example/hook", "s3cret"); err != nil {
+		t.Fatalf("SetWebhook() error = %v", err)
+	}
+	if setForm.Get("url") != "https://bot.example/hook" || setForm.Get("secret_token") != "s3cret" {
+		t.Fatalf("setWebhook form = %v", setForm)
+	}
+
+	info, err := client.GetWebhookInfo(ctx)
+	if err != nil {
+		t.Fatalf("GetWebhookInfo() error = %v", err)
+	}
+	if info.URL != "https://bot.example/hook" || info.PendingUpdateCount != 3 {
+		t.Fatalf("GetWebhookInfo() = %+v", info)
+	}
+
+	if err := client.DeleteWebhook(ctx, false); err != nil {
+		t.Fatalf("DeleteWebhook() error = %v", err)
+	}
+
+	if got := strings.Join(calls, ","); got != "setWebhook,getWebhookInfo,deleteWebhook" {
+		t.Fatalf("calls = %s", got)
+	}
+}
+
+func TestUnauthorizedStatusWrapsErrUnauthorized(t *testing.T) {
+	t.Parallel()
+
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: 401,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(strings.NewReader(`{"ok":false,"error_code":401,"description":"Unauthorized"}`)),
+			}, nil
+		}),
+	}
+
+	client := NewClient("https://api.telegram.test", "revoked", httpClient)
+	if _, err := client.SendMessage(context.Background(), "777", "hi"); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("SendMessage() error = %v, want %v", err, ErrUnauthorized)
+	}
+	if _, err := client.GetUpdates(context.Background(), 0, 0); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("GetUpdates() error = %v, want %v", err, ErrUnauthorized)
+	}
+}
+
+func TestTransportErrorsRedactBotToken(t *testing.T) {
+	t.Parallel()
+
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return nil, errors.New("connection refused")
+		}),
+	}
+
+	client := NewClient("https://api.telegram.test", "123456:SECRETTOKEN", httpClient)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	_, err := client.GetUpdates(context.Background(), 0, 0)
+	if err == nil {
+		t.Fatal("GetUpdates() error = nil, want transport error")
+	}
+	if strings.Contains(err.Error(), "SECRETTOKEN") || !strings.Contains(err.Error(), "/bot123456:***/getUpdates") {
+		t.Fatalf("GetUpdates() error = %q, want the token redacted", err)
+	}
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		t.Fatalf("GetUpdates() error = %v, want *url.Error", err)
+	}
+}
+
+func TestErrorResponsesDecodeAPIError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantStatus int
+		wantCode   int
+		wantDesc   string
+		wantHint   string
+	}{
+		{
+			name:       "bad request",
+			status:     400,
+			body:       `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`,
+			wantStatus: 400,
+			wantCode:   400,
+			wantDesc:   "Bad Request: chat not found",
+			wantHint:   "TELEGRAM_CHAT_ID",
+		},
+		{
+			name:       "ok false in 200",
+			status:     200,
+			body:       `{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`,
+			wantStatus: 200,
+			wantCode:   403,
+			wantDesc:   "Forbidden: bot was blocked by the user",
+			wantHint:   "屏蔽",
+		},
+		{
+			name:       "unauthorized",
+			status:     401,
+			body:       `{"ok":false,"error_code":401,"description":"Unauthorized"}`,
+			wantStatus: 401,
+			wantCode:   401,
+			wantDesc:   "Unauthorized",
+			wantHint:   "TELEGRAM_BOT_TOKEN",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			httpClient := &http.Client{
+				Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+					return &http.Response{
+						StatusCode: tt.status,
+						Header:     make(http.Header),
+						Body:       io.NopCloser(strings.NewReader(tt.body)),
+					}, nil
+				}),
+			}
+			client := NewClient("https://api.telegram.test", "token", httpClient)
+
+			_, err := client.SendMessage(context.Background(), "777", "hi")
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("SendMessage() error = %v, want *APIError", err)
+			}
+			if apiErr.Method != "sendMessage" || apiErr.StatusCode != tt.wantStatus || apiErr.ErrorCode != tt.wantCode || apiErr.Description != tt.wantDesc {
+				t.Fatalf("APIError = %+v", apiErr)
+			}
+			if hint := Hint(err); !strings.Contains(hint, tt.wantHint) {
+				t.Fatalf("Hint() = %q, want it to mention %q", hint, tt.wantHint)
+			}
+		})
+	}
+}
+
+func TestSendDocumentUploadsMultipartAndRetries(t *testing.T) {
+	t.Parallel()
+
+	var uploads []string
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			if !strings.HasSuffix(r.URL.Path, "/bottoken123/sendDocument") {
+				t.Fatalf("path = %s", r.URL.Path)
+			}
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Fatalf("ParseMultipartForm() error = %v", err)
+			}
+			if r.FormValue("chat_id") != "777" || r.FormValue("caption") != "Plan v2" {
+				t.Fatalf("fields = %v", r.MultipartForm.Value)
+			}
+			file, header, err := r.FormFile("document")
+			if err != nil {
+				t.Fatalf("FormFile() error = %v", err)
+			}
+			data, _ := io.ReadAll(file)
+			uploads = append(uploads, header.Filename+":"+string(data))
+
+			if len(uploads) == 1 {
+				// Uploads are retried only after a 429, which Telegram did
+				// not act on.
+				return &http.Response{
+					StatusCode: 429,
+					Header:     make(http.Header),
+					Body:       io.NopCloser(strings.NewReader(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`)),
+				}, nil
+			}
+			return &http.Response{
+				StatusCode: 200,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(strings.NewReader(`{"ok":true,"result":{"message_id":51}}`)),
+			}, nil
+		}),
+	}
+
+	client := NewClient("https://api.telegram.test", "token123", httpClient)
+	client.sleep = func(context.Context, time.Duration) error { return nil }
+
+	id, err := client.SendDocument(context.Background(), "777", InputFile{Name: "plan.md", Data: []byte("# Plan\n")}, DocumentOptions{Caption: "Plan v2"})
+	if err != nil {
+		t.Fatalf("SendDocument() error = %v", err)
+	}
+	if id != 51 {
+		t.Fatalf("message_id = %d, want 51", id)
+	}
+	if got := strings.Join(uploads, "|"); got != "plan.md:# Plan\n|plan.md:# Plan\n" {
+		t.Fatalf("uploads = %q, want the same file twice", got)
+	}
+}
+
+func TestGetFileAndDownloadVoice(t *testing.T) {
+	t.Parallel()
+
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			var body string
+			switch r.URL.Path {
+			case "/bottoken123/getUpdates":
+				body = `{"ok":true,"result":[{"update_id":3,"message":{"message_id":9,"chat":{"id":777},"voice":{"file_id":"voice-1","file_unique_id":"u1","duration":4,"mime_type":"audio/ogg","file_size":5}}}]}`
+			case "/bottoken123/getFile":
+				if err := r.ParseForm(); err != nil || r.PostForm.Get("file_id") != "voice-1" {
+					t.Fatalf("getFile form = %v, %v", r.PostForm, err)
+				}
+				body = `{"ok":true,"result":{"file_id":"voice-1","file_unique_id":"u1","file_size":5,"file_path":"voice/file_1.oga"}}`
+			case "/file/bottoken123/voice/file_1.oga":
+				body = "OggS!"
+			default:
+				t.Fatalf("path = %q", r.URL.Path)
+			}
+			return &http.Response{
+				StatusCode: 200,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}, nil
+		}),
+	}
+
+	client := NewClient("https://api.telegram.test", "token123", httpClient)
+	updates, err := client.GetUpdates(context.Background(), 0, 0)
+	if err != nil {
+		t.Fatalf("GetUpdates() error = %v", err)
+	}
+	voice := updates[0].Message.Voice
+	if voice == nil || voice.FileID != "voice-1" || voice.Duration != 4 {
+		t.Fatalf("Voice = %+v", voice)
+	}
+
+	file, err := client.GetFile(context.Background(), voice.FileID)
+	if err != nil {
+		t.Fatalf("GetFile() error = %v", err)
+	}
+	data, err := client.DownloadFile(context.Background(), file.FilePath)
+	if err != nil {
+		t.Fatalf("DownloadFile() error = %v", err)
+	}
+	if string(data) != "OggS!" {
+		t.Fatalf("DownloadFile() = %q", data)
+	}
+}
+
+// endlessReader is a response body that never ends; read counts what was
+// taken from it.
+type endlessReader struct{ read int64 }
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	r.read += int64(len(p))
+	return len(p), nil
+}
+
+func TestDownloadFileStopsReadingOverLimit(t *testing.T) {
+	t.Parallel()
+
+	body := &endlessReader{}
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: 200, Header: make(http.Header), Body: io.NopCloser(body)}, nil
+		}),
+	}
+
+	client := NewClient("https://api.telegram.test", "token123", httpClient)
+	_, err := client.DownloadFile(context.Background(), "documents/file_1.bin")
+	if err == nil || !strings.Contains(err.Error(), "over the 20971520 byte download limit") {
+		t.Fatalf("DownloadFile() error = %v, want download limit error", err)
+	}
+	if body.read > MaxDownloadSize+1 {
+		t.Fatalf("read %d bytes, want at most %d", body.read, MaxDownloadSize+1)
+	}
+}
+
+func TestSenderAllowed(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		user    *User
+		allowed []int64
+		want    bool
+	}{
+		{name: "any user without allowlist", user: &User{ID: 1}, want: true},
+		{name: "bot without allowlist", user: &User{ID: 1, IsBot: true}, want: false},
+		{name: "listed user", user: &User{ID: 2}, allowed: []int64{1, 2}, want: true},
+		{name: "unlisted user", user: &User{ID: 3}, allowed: []int64{1, 2}, want: false},
+		{name: "listed bot", user: &User{ID: 2, IsBot: true}, allowed: []int64{2}, want: false},
+		{name: "no sender without allowlist", want: true},
+		{name: "no sender with allowlist", allowed: []int64{1}, want: false},
+	}
+	for _, tt := range tests {
+		if got := SenderAllowed(tt.user, tt.allowed); got != tt.want {
+			t.Errorf("%s: SenderAllowed() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
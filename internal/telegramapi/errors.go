@@ -0,0 +1,111 @@
+package telegramapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrUnauthorized matches APIErrors for HTTP 401 responses, which Telegram
+// returns when the bot token is invalid or revoked.
+var ErrUnauthorized = errors.New("telegram rejected the bot token")
+
+type ResponseParameters struct {
+	MigrateToChatID int64 `json:"migrate_to_chat_id"`
+	RetryAfter      int   `json:"retry_after"`
+}
+
+// APIError is a non-2xx response from the Bot API, decoded from its JSON
+// error body when one is present.
+type APIError struct {
+	Method      string
+	StatusCode  int
+	ErrorCode   int
+	Description string
+	Parameters  ResponseParameters
+}
+
+func newAPIError(method string, statusCode int, body []byte) *APIError {
+	apiErr := &APIError{Method: method, StatusCode: statusCode}
+
+	var apiResp struct {
+		ErrorCode   int                `json:"error_code"`
+		Description string             `json:"description"`
+		Parameters  ResponseParameters `json:"parameters"`
+	}
+	if err := json.Unmarshal(body, &apiResp); err == nil {
+		apiErr.ErrorCode = apiResp.ErrorCode
+		apiErr.Description = apiResp.Description
+		apiErr.Parameters = apiResp.Parameters
+	}
+	if apiErr.ErrorCode == 0 {
+		apiErr.ErrorCode = statusCode
+	}
+	if strings.TrimSpace(apiErr.Description) == "" {
+		apiErr.Description = http.StatusText(statusCode)
+	}
+
+	return apiErr
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("request %s: status %d: %s", e.Method, e.StatusCode, e.Description)
+}
+
+func (e *APIError) Is(target error) bool {
+	return target == ErrUnauthorized && (e.StatusCode == http.StatusUnauthorized || e.ErrorCode == http.StatusUnauthorized)
+}
+
+// Retryable reports whether the same request may succeed later: flood
+// control (429) and server or proxy failures (5xx).
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Hint turns well-known Telegram failures into an actionable message for
+// CLI users. It returns "" when err carries no recognizable APIError.
+func Hint(err error) string {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return ""
+	}
+
+	desc := strings.ToLower(apiErr.Description)
+	switch {
+	case errors.Is(apiErr, ErrUnauthorized):
+		return "bot token 无效或已被吊销，请在 @BotFather 重新获取并更新 TELEGRAM_BOT_TOKEN"
+	case apiErr.StatusCode == http.StatusNotFound || apiErr.ErrorCode == http.StatusNotFound:
+		return "Bot API 返回 404：请检查 TELEGRAM_BOT_TOKEN 格式和 --api-base 地址"
+	case strings.Contains(desc, "chat not found"):
+		return "找不到聊天：请检查 TELEGRAM_CHAT_ID，并确认已先在该聊天中给机器人发过消息"
+	case strings.Contains(desc, "bot was blocked by the user"):
+		return "机器人已被该用户屏蔽：请在 Telegram 中解除屏蔽后重试"
+	case strings.Contains(desc, "bot was kicked"), strings.Contains(desc, "not a member"):
+		return "机器人不在目标群组中：请重新把机器人加入群组"
+	case strings.Contains(desc, "not enough rights to manage pinned messages"):
+		return "机器人没有置顶权限：请在群组中把机器人设为管理员并允许置顶消息"
+	case apiErr.Parameters.MigrateToChatID != 0:
+		return fmt.Sprintf("群组已升级为超级群组：请把 TELEGRAM_CHAT_ID 更新为 %d", apiErr.Parameters.MigrateToChatID)
+	case strings.Contains(desc, "message thread not found"):
+		return "找不到话题：请检查 TELEGRAM_THREAD_ID，并确认群组已开启话题功能"
+	case apiErr.ErrorCode == http.StatusConflict:
+		return "另一个进程正在使用同一个 bot token 拉取更新（getUpdates 或 webhook），请先停止它"
+	case apiErr.ErrorCode == http.StatusTooManyRequests:
+		return "触发 Telegram 频率限制，请稍后重试"
+	default:
+		return ""
+	}
+}
+
+// MigrationHint asks the user to update TELEGRAM_CHAT_ID once requests for
+// chatID have been redirected to the supergroup the group was upgraded to.
+// Every new process pays one failed request until then. It returns "" when
+// chatID was not migrated.
+func (c *Client) MigrationHint(chatID string) string {
+	if to := c.EffectiveChatID(chatID); to != chatID {
+		return fmt.Sprintf("群组已升级为超级群组，消息已改发到 %s：请把 TELEGRAM_CHAT_ID 更新为 %s", to, to)
+	}
+	return ""
+}
@@ -0,0 +1,174 @@
+package telegramapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy bounds how often a failed request is repeated. Attempts count
+// the first request, so MaxAttempts <= 1 disables retries.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// call sends a request built from params, retrying network failures, 429s
+// and 5xx responses with exponential backoff. Methods in nonIdempotent are
+// retried only after a 429 or when the connection was never made. A "group
+// migrated to supergroup" error is retried once against the new chat, which
+// is then used for later requests to the old chat ID as well.
+func (c *Client) call(ctx context.Context, method string, params url.Values, build func(url.Values) (*http.Request, error)) ([]byte, error) {
+	params = c.applyMigration(params)
+	attempts := max(c.retry.MaxAttempts, 1)
+	migrated := false
+
+	for attempt := 1; ; attempt++ {
+		req, err := build(params)
+		if err != nil {
+			return nil, fmt.Errorf("build request: %w", c.redactToken(err))
+		}
+
+		body, err := c.do(method, req)
+		if err == nil {
+			return body, nil
+		}
+
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.Parameters.MigrateToChatID != 0 && params.Has("chat_id") && !migrated {
+			migrated = true
+			params = c.recordMigration(params, apiErr.Parameters.MigrateToChatID)
+			continue
+		}
+
+		if attempt >= attempts || ctx.Err() != nil || !isRetryable(method, err) {
+			return nil, err
+		}
+		if sleepErr := c.sleep(ctx, c.retryDelay(attempt, err)); sleepErr != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) retryDelay(attempt int, err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Parameters.RetryAfter > 0 {
+		return time.Duration(apiErr.Parameters.RetryAfter) * time.Second
+	}
+
+	delay := c.retry.BaseDelay << (attempt - 1)
+	if c.retry.MaxDelay > 0 && (delay > c.retry.MaxDelay || delay <= 0) {
+		delay = c.retry.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// Full jitter in [delay/2, delay) keeps parallel agents from retrying in
+	// lockstep.
+	half := delay / 2
+	return half + rand.N(delay-half)
+}
+
+// nonIdempotent methods post something new to the chat. A timeout or a 5xx
+// from a proxy after the request reached Telegram would repeat the prompt,
+// upload or pin.
+var nonIdempotent = map[string]bool{
+	"sendMessage":    true,
+	"sendDocument":   true,
+	"pinChatMessage": true,
+}
+
+func isRetryable(method string, err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if nonIdempotent[method] {
+			// A 429 is a refusal; Telegram did not act on the request.
+			return apiErr.StatusCode == http.StatusTooManyRequests
+		}
+		return apiErr.Retryable()
+	}
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return false
+	}
+	if !nonIdempotent[method] {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "proxyconnect")
+}
+
+// EffectiveChatID returns the chat that requests naming chatID go to: the
+// supergroup it was upgraded to once a request has seen the migration, and
+// chatID itself otherwise. Replies arrive from the effective chat.
+func (c *Client) EffectiveChatID(chatID string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if to, ok := c.migratedChatID[chatID]; ok {
+		return to
+	}
+	return chatID
+}
+
+func (c *Client) applyMigration(params url.Values) url.Values {
+	chatID := params.Get("chat_id")
+	if chatID == "" {
+		return params
+	}
+	to := c.EffectiveChatID(chatID)
+	if to == chatID {
+		return params
+	}
+
+	out := cloneValues(params)
+	out.Set("chat_id", to)
+	return out
+}
+
+func (c *Client) recordMigration(params url.Values, to int64) url.Values {
+	newID := strconv.FormatInt(to, 10)
+
+	c.mu.Lock()
+	c.migratedChatID[params.Get("chat_id")] = newID
+	c.mu.Unlock()
+
+	out := cloneValues(params)
+	out.Set("chat_id", newID)
+	return out
+}
+
+func cloneValues(v url.Values) url.Values {
+	out := make(url.Values, len(v))
+	for k, vals := range v {
+		out[k] = append([]string(nil), vals...)
+	}
+	return out
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,259 @@
+package telegramapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type scriptedResponse struct {
+	status int
+	body   string
+}
+
+func scriptedClient(t *testing.T, responses []scriptedResponse, seen *[]url.Values) (*Client, *[]time.Duration) {
+	t.Helper()
+
+	calls := 0
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			if calls >= len(responses) {
+				t.Fatalf("unexpected request #%d", calls+1)
+			}
+			if seen != nil {
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Fatalf("ReadAll() error = %v", err)
+				}
+				vals, err := url.ParseQuery(string(body))
+				if err != nil {
+					t.Fatalf("ParseQuery() error = %v", err)
+				}
+				*seen = append(*seen, vals)
+			}
+			resp := responses[calls]
+			calls++
+			return &http.Response{
+				StatusCode: resp.status,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(strings.NewReader(resp.body)),
+			}, nil
+		}),
+	}
+
+	client := NewClient("https://api.telegram.test", "token123", httpClient)
+	var sleeps []time.Duration
+	client.sleep = func(_ context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return nil
+	}
+	return client, &sleeps
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	client, sleeps := scriptedClient(t, []scriptedResponse{
+		{429, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 3","parameters":{"retry_after":3}}`},
+		{200, `{"ok":true,"result":{"message_id":9}}`},
+	}, nil)
+
+	messageID, err := client.SendMessage(context.Background(), "777", "hi")
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if messageID != 9 {
+		t.Fatalf("messageID = %d, want 9", messageID)
+	}
+	if len(*sleeps) != 1 || (*sleeps)[0] != 3*time.Second {
+		t.Fatalf("sleeps = %v, want [3s]", *sleeps)
+	}
+}
+
+func TestRetryBacksOffOnServerErrorsUntilExhausted(t *testing.T) {
+	t.Parallel()
+
+	client, sleeps := scriptedClient(t, []scriptedResponse{
+		{502, `<html>Bad Gateway</html>`},
+		{503, `{"ok":false,"error_code":503,"description":"Service Unavailable"}`},
+		{502, `<html>Bad Gateway</html>`},
+	}, nil)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second})
+
+	_, err := client.GetUpdates(context.Background(), 0, 0)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("GetUpdates() error = %v, want *APIError", err)
+	}
+	if apiErr.Method != "getUpdates" || apiErr.StatusCode != 502 || apiErr.Description != "Bad Gateway" {
+		t.Fatalf("APIError = %+v", apiErr)
+	}
+
+	if len(*sleeps) != 2 {
+		t.Fatalf("sleeps = %v, want 2 backoffs", *sleeps)
+	}
+	if d := (*sleeps)[0]; d < 50*time.Millisecond || d >= 100*time.Millisecond {
+		t.Fatalf("first backoff = %s, want [50ms,100ms)", d)
+	}
+	if d := (*sleeps)[1]; d < 100*time.Millisecond || d >= 200*time.Millisecond {
+		t.Fatalf("second backoff = %s, want [100ms,200ms)", d)
+	}
+}
+
+func TestRetrySkipsClientErrors(t *testing.T) {
+	t.Parallel()
+
+	client, sleeps := scriptedClient(t, []scriptedResponse{
+		{400, `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`},
+	}, nil)
+
+	_, err := client.SendMessage(context.Background(), "777", "hi")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode != 400 || apiErr.Description != "Bad Request: chat not found" {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if len(*sleeps) != 0 {
+		t.Fatalf("sleeps = %v, want none", *sleeps)
+	}
+}
+
+func TestRetryFollowsChatMigration(t *testing.T) {
+	t.Parallel()
+
+	var seen []url.Values
+	client, sleeps := scriptedClient(t, []scriptedResponse{
+		{400, `{"ok":false,"error_code":400,"description":"Bad Request: group chat was upgraded to a supergroup chat","parameters":{"migrate_to_chat_id":-1001234}}`},
+		{200, `{"ok":true,"result":{"message_id":1}}`},
+		{200, `{"ok":true,"result":{"message_id":2}}`},
+	}, &seen)
+
+	ctx := context.Background()
+	if _, err := client.SendMessage(ctx, "-777", "first"); err != nil {
+		t.Fatalf("SendMessage() #1 error = %v", err)
+	}
+	if _, err := client.SendMessage(ctx, "-777", "second"); err != nil {
+		t.Fatalf("SendMessage() #2 error = %v", err)
+	}
+
+	got := []string{seen[0].Get("chat_id"), seen[1].Get("chat_id"), seen[2].Get("chat_id")}
+	if strings.Join(got, ",") != "-777,-1001234,-1001234" {
+		t.Fatalf("chat_ids = %v", got)
+	}
+	if got := client.EffectiveChatID("-777"); got != "-1001234" {
+		t.Fatalf("EffectiveChatID(-777) = %q, want -1001234", got)
+	}
+	if got := client.EffectiveChatID("555"); got != "555" {
+		t.Fatalf("EffectiveChatID(555) = %q, want 555", got)
+	}
+	if len(*sleeps) != 0 {
+		t.Fatalf("sleeps = %v, want none", *sleeps)
+	}
+}
+
+func TestRetryNetworkErrorsOnlyBeforeSendingNonIdempotentRequests(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		transport error
+		status    int // answered instead of failing when transport is nil
+		send      func(*Client) error
+		wantCalls int
+	}{
+		{
+			name:      "sendMessage after the request may have arrived",
+			transport: errors.New("read: connection reset by peer"),
+			send: func(c *Client) error {
+				_, err := c.SendMessage(context.Background(), "777", "hi")
+				return err
+			},
+			wantCalls: 1,
+		},
+		{
+			name:      "sendMessage that never connected",
+			transport: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+			send: func(c *Client) error {
+				_, err := c.SendMessage(context.Background(), "777", "hi")
+				return err
+			},
+			wantCalls: 3,
+		},
+		{
+			name:   "sendMessage answered 502 by a proxy",
+			status: http.StatusBadGateway,
+			send: func(c *Client) error {
+				_, err := c.SendMessage(context.Background(), "777", "hi")
+				return err
+			},
+			wantCalls: 1,
+		},
+		{
+			name:   "pinChatMessage answered 504 by a proxy",
+			status: http.StatusGatewayTimeout,
+			send: func(c *Client) error {
+				return c.PinChatMessage(context.Background(), "777", 5)
+			},
+			wantCalls: 1,
+		},
+		{
+			name:   "sendMessage rate limited",
+			status: http.StatusTooManyRequests,
+			send: func(c *Client) error {
+				_, err := c.SendMessage(context.Background(), "777", "hi")
+				return err
+			},
+			wantCalls: 3,
+		},
+		{
+			name:   "getUpdates answered 502",
+			status: http.StatusBadGateway,
+			send: func(c *Client) error {
+				_, err := c.GetUpdates(context.Background(), 0, 0)
+				return err
+			},
+			wantCalls: 3,
+		},
+		{
+			name:      "getUpdates",
+			transport: errors.New("read: connection reset by peer"),
+			send: func(c *Client) error {
+				_, err := c.GetUpdates(context.Background(), 0, 0)
+				return err
+			},
+			wantCalls: 3,
+		},
+	}
+	for _, tc := range cases {
+		calls := 0
+		httpClient := &http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				calls++
+				if tc.transport != nil {
+					return nil, tc.transport
+				}
+				body := fmt.Sprintf(`{"ok":false,"error_code":%d,"description":"%s"}`, tc.status, http.StatusText(tc.status))
+				return &http.Response{
+					StatusCode: tc.status,
+					Header:     make(http.Header),
+					Body:       io.NopCloser(strings.NewReader(body)),
+				}, nil
+			}),
+		}
+		client := NewClient("https://api.telegram.test", "token123", httpClient)
+		client.sleep = func(context.Context, time.Duration) error { return nil }
+
+		if err := tc.send(client); err == nil {
+			t.Fatalf("%s: error = nil, want failure", tc.name)
+		}
+		if calls != tc.wantCalls {
+			t.Fatalf("%s: calls = %d, want %d", tc.name, calls, tc.wantCalls)
+		}
+	}
+}
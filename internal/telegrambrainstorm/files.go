@@ -0,0 +1,114 @@
+package telegrambrainstorm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"codex-brainstorming-telegram/internal/telegramapi"
+)
+
+const (
+	filesUnsupportedNotice = "暂不支持图片或文件回复，请用文字回答。"
+	fileFailedNotice       = "文件下载失败，请重新发送或改用文字回答。"
+	fileTooLargeNotice     = "文件过大（超过 20 MB），无法下载，请压缩后重新发送或改用文字回答。"
+)
+
+// ReceivedFile is a photo or document reply saved under
+// PromptOptions.AttachmentDir.
+type ReceivedFile struct {
+	Path     string
+	FileName string
+	MimeType string
+	Size     int64
+}
+
+func hasFile(msg telegramapi.Message) bool {
+	return len(msg.Photo) > 0 || msg.Document != nil
+}
+
+// saveFileReply downloads a photo or document reply into dir. Like
+// transcribeReply it returns a notice instead of an error when the file
+// cannot be saved, so the round keeps waiting for another answer.
+func (c *Conversation) saveFileReply(ctx context.Context, msg telegramapi.Message, dir string, promptMessageID int64) (ReceivedFile, string) {
+	if dir == "" {
+		return ReceivedFile{}, filesUnsupportedNotice
+	}
+
+	var fileID, name string
+	out := ReceivedFile{}
+	if msg.Document != nil {
+		fileID, name = msg.Document.FileID, msg.Document.FileName
+		out.MimeType, out.Size = msg.Document.MimeType, msg.Document.FileSize
+	} else {
+		photo, ok := largestPhoto(msg.Photo)
+		if !ok {
+			return ReceivedFile{}, fileTooLargeNotice
+		}
+		fileID, out.Size = photo.FileID, photo.FileSize
+		out.MimeType = "image/jpeg"
+	}
+	if out.Size > telegramapi.MaxDownloadSize {
+		return ReceivedFile{}, fileTooLargeNotice
+	}
+
+	file, err := c.api.GetFile(ctx, fileID)
+	if err != nil {
+		return ReceivedFile{}, fileFailedNotice
+	}
+	if file.FileSize > telegramapi.MaxDownloadSize {
+		return ReceivedFile{}, fileTooLargeNotice
+	}
+	if name == "" {
+		name = path.Base(file.FilePath)
+	}
+	data, err := c.api.DownloadFile(ctx, file.FilePath)
+	if err != nil {
+		return ReceivedFile{}, fileFailedNotice
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return ReceivedFile{}, fileFailedNotice
+	}
+	out.FileName = safeFileName(name)
+	out.Path = filepath.Join(dir, fmt.Sprintf("%d-%d-%s", promptMessageID, msg.MessageID, out.FileName))
+	if err := os.WriteFile(out.Path, data, 0o600); err != nil {
+		return ReceivedFile{}, fileFailedNotice
+	}
+	out.Size = int64(len(data))
+	return out, ""
+}
+
+// largestPhoto picks the biggest size that can still be downloaded.
+func largestPhoto(sizes []telegramapi.PhotoSize) (telegramapi.PhotoSize, bool) {
+	var best telegramapi.PhotoSize
+	found := false
+	for _, p := range sizes {
+		if p.FileSize > telegramapi.MaxDownloadSize {
+			continue
+		}
+		if !found || p.Width*p.Height > best.Width*best.Height {
+			best, found = p, true
+		}
+	}
+	return best, found
+}
+
+// safeFileName keeps a sender-chosen name from escaping the attachment
+// directory or producing awkward paths.
+func safeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == ".." {
+		return "file"
+	}
+	return name
+}
@@ -0,0 +1,107 @@
+package telegrambrainstorm
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	// declaredLabel matches an explicit label at the start of an option,
+	// such as "A)", "b.", "3:" or "（2）".
+	declaredLabel = regexp.MustCompile(`^[(（]?([A-Za-z]|\d{1,2})[)）.:：、]\s*`)
+
+	choiceSeparators = regexp.MustCompile(`\s*(?:[,，、;；/&+]|\band\b|和|与|及)\s*|\s+`)
+
+	choicePrefixes = []string{"option", "choice", "选项", "选择", "方案", "选", "#"}
+)
+
+// ValidateOptions reports an option that is blank once trimmed, which Ask
+// would reject. Callers use it to catch bad input before contacting Telegram.
+func ValidateOptions(options []string) error {
+	_, err := cleanOptions(options)
+	return err
+}
+
+// ParseChoices maps a free-text reply onto the declared options. It accepts
+// letters and numbers ("b", "B)", "选B", "option 2"), the option text itself,
+// and lists such as "1,3" or "A 和 C". The returned indexes are unique and in
+// reply order; ok is false when any part of the reply matches no option.
+func ParseChoices(reply string, options []string) ([]int, bool) {
+	if len(options) == 0 {
+		return nil, false
+	}
+
+	labels := optionLabels(options)
+	whole := normalizeChoiceToken(reply)
+	if whole == "" {
+		return nil, false
+	}
+	if idx, ok := labels[whole]; ok {
+		return []int{idx}, true
+	}
+
+	var out []int
+	seen := map[int]bool{}
+	for _, part := range choiceSeparators.Split(strings.TrimSpace(reply), -1) {
+		token := normalizeChoiceToken(part)
+		if token == "" {
+			continue
+		}
+		idx, ok := labels[token]
+		if !ok {
+			return nil, false
+		}
+		if !seen[idx] {
+			seen[idx] = true
+			out = append(out, idx)
+		}
+	}
+
+	return out, len(out) > 0
+}
+
+func optionLabels(options []string) map[string]int {
+	labels := map[string]int{}
+	add := func(label string, idx int) {
+		label = normalizeChoiceToken(label)
+		if label == "" {
+			return
+		}
+		if _, exists := labels[label]; !exists {
+			labels[label] = idx
+		}
+	}
+
+	// Explicit labels and full texts win over positional letters/numbers, so
+	// an option written as "1) ..." at position B still answers to "1".
+	for i, opt := range options {
+		if m := declaredLabel.FindStringSubmatch(opt); m != nil {
+			add(m[1], i)
+			add(opt[len(m[0]):], i)
+		}
+		add(opt, i)
+	}
+	for i := range options {
+		add(strconv.Itoa(i+1), i)
+		if i < 26 {
+			add(string(rune('a'+i)), i)
+		}
+	}
+
+	return labels
+}
+
+func normalizeChoiceToken(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, prefix := range choicePrefixes {
+		if rest, ok := strings.CutPrefix(s, prefix); ok && rest != "" {
+			s = strings.TrimSpace(rest)
+			break
+		}
+	}
+	return strings.TrimFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r) || strings.ContainsRune("（）【】「」“”‘’", r)
+	})
+}
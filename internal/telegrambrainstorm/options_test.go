@@ -0,0 +1,53 @@
+package telegrambrainstorm
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseChoices(t *testing.T) {
+	t.Parallel()
+
+	options := []string{"A) 低风险", "B) 平衡", "C) 激进"}
+	cases := []struct {
+		reply string
+		want  []int
+		ok    bool
+	}{
+		{"a", []int{0}, true},
+		{"B", []int{1}, true},
+		{" B) ", []int{1}, true},
+		{"选C", []int{2}, true},
+		{"选择 b", []int{1}, true},
+		{"方案A", []int{0}, true},
+		{"2", []int{1}, true},
+		{"option 3", []int{2}, true},
+		{"平衡", []int{1}, true},
+		{"B) 平衡", []int{1}, true},
+		{"1,3", []int{0, 2}, true},
+		{"A 和 C", []int{0, 2}, true},
+		{"c、a、c", []int{2, 0}, true},
+		{"D", nil, false},
+		{"我觉得都可以", nil, false},
+		{"A and maybe", nil, false},
+		{"", nil, false},
+	}
+	for _, tc := range cases {
+		got, ok := ParseChoices(tc.reply, options)
+		if ok != tc.ok || !slices.Equal(got, tc.want) {
+			t.Fatalf("ParseChoices(%q) = %v, %v; want %v, %v", tc.reply, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestParseChoicesPlainOptionsUsePositionalLabels(t *testing.T) {
+	t.Parallel()
+
+	options := []string{"稳健", "激进"}
+	if got, ok := ParseChoices("b", options); !ok || !slices.Equal(got, []int{1}) {
+		t.Fatalf("ParseChoices(b) = %v, %v", got, ok)
+	}
+	if got, ok := ParseChoices("稳健", options); !ok || !slices.Equal(got, []int{0}) {
+		t.Fatalf("ParseChoices(稳健) = %v, %v", got, ok)
+	}
+}
@@ -5,30 +5,280 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"codex-brainstorming-telegram/internal/telegramapi"
+	"codex-brainstorming-telegram/internal/telegramformat"
+	"codex-brainstorming-telegram/internal/transcribe"
 )
 
+// ErrSessionTimeout is returned when no answer arrives in time. The result
+// returned with it still identifies the prompt (PromptMessageID, SentAt).
 var ErrSessionTimeout = errors.New("brainstorming session timed out")
 
+const callbackDataPrefix = "opt:"
+
+// notAllowedNotice answers button taps from users outside the allowlist.
+const notAllowedNotice = "你没有权限回答这个问题"
+
+// staleTapNotice answers taps on the buttons of an earlier prompt.
+const staleTapNotice = "这个问题已结束"
+
+const (
+	undoneNotice        = "已撤销上一条回答，请重新回答。"
+	nothingToUndoNotice = "当前没有可撤销的回答。"
+)
+
+type MatchMode string
+
+const (
+	// MatchAny accepts the first message in the chat after the prompt.
+	MatchAny MatchMode = "any"
+	// MatchReply accepts only messages sent as a reply to the prompt.
+	MatchReply MatchMode = "reply"
+)
+
 type sessionAPI interface {
-	SendMessage(ctx context.Context, chatID string, text string) (int64, error)
+	SendMessageWithOptions(ctx context.Context, chatID string, text string, opts telegramapi.SendOptions) (int64, error)
 	GetUpdates(ctx context.Context, offset int64, timeoutSec int) ([]telegramapi.Update, error)
+	AnswerCallbackQuery(ctx context.Context, callbackQueryID string, text string) error
+	SendDocument(ctx context.Context, chatID string, doc telegramapi.InputFile, opts telegramapi.DocumentOptions) (int64, error)
+	GetFile(ctx context.Context, fileID string) (telegramapi.File, error)
+	DownloadFile(ctx context.Context, filePath string) ([]byte, error)
+	EditMessageText(ctx context.Context, chatID string, messageID int64, text string, opts telegramapi.EditOptions) error
+	EditMessageReplyMarkup(ctx context.Context, chatID string, messageID int64, markup *telegramapi.InlineKeyboardMarkup) error
+	EffectiveChatID(chatID string) string
+}
+
+type PromptOptions struct {
+	// Options are rendered as inline keyboard buttons, one per row. A tap
+	// returns the tapped option as the reply.
+	Options []string
+
+	// MultiSelect accepts replies naming several options, such as "1,3".
+	MultiSelect bool
+
+	// AllowFreeText returns typed replies that match no option as they are.
+	// Otherwise such replies trigger a re-ask and the round keeps waiting.
+	AllowFreeText bool
+
+	// Match selects which messages count as the answer. The zero value
+	// behaves like MatchAny.
+	Match MatchMode
+
+	// ParseMode renders the prompt's Markdown as MarkdownV2 or HTML. Prompts
+	// longer than one Telegram message are sent in numbered chunks either
+	// way; the keyboard is attached to the last chunk.
+	ParseMode telegramformat.ParseMode
+
+	// AllowedUserIDs limits whose messages and taps can answer the prompt.
+	// Messages from bots are always ignored.
+	AllowedUserIDs []int64
+
+	// ThreadID sends the prompt into a forum topic and accepts only replies
+	// posted in that topic.
+	ThreadID int64
+
+	// Attachments are uploaded as documents right before the prompt, for
+	// plans or diffs too long to read as messages. They are not sent again
+	// when resuming.
+	Attachments []Attachment
+
+	// Transcriber turns voice notes and audio replies into text, which is
+	// then handled like a typed reply. Without one, voice replies get a
+	// notice asking for text.
+	Transcriber transcribe.Transcriber
+
+	// AttachmentDir is where photo and document replies are saved. Such a
+	// reply is the answer even when Options are set; its caption becomes
+	// the reply text. Without a directory they get a notice asking for
+	// text.
+	AttachmentDir string
+
+	// GraceWindow keeps the round open for a short while after the first
+	// answer. Edits of the answer and a "/undo" message sent meanwhile
+	// replace it; any other new message ends the window early.
+	GraceWindow time.Duration
+
+	// Reminders are how long after waiting starts to nudge the chat with a
+	// reply to the prompt, while no answer has arrived. Reminders at or past
+	// the session timeout are dropped.
+	Reminders []time.Duration
+
+	// MarkStatus edits the prompt when the round ends, appending the
+	// recorded answer or an expired status and removing its keyboard, so the
+	// chat reads as a log of the session.
+	MarkStatus bool
+
+	// Resume continues waiting for a prompt that was already sent, starting
+	// from the recorded offset instead of sending the prompt again.
+	Resume *Checkpoint
+
+	// OnCheckpoint is called after the prompt is sent and whenever the
+	// update offset advances, so callers can persist the round.
+	OnCheckpoint func(Checkpoint) error
+
+	// Escalation lists further chats, in order, that the prompt moves on to
+	// when the chat before stays silent: the conversation's chat for the
+	// session timeout, each target for its own Timeout. The prompt left
+	// behind is marked as handed on and no longer accepts answers.
+	// Attachments are sent again; ThreadID applies only to the
+	// conversation's chat.
+	Escalation []EscalationTarget
+
+	// OnEscalate is called with the next chat before the prompt is sent
+	// there.
+	OnEscalate func(chatID string)
+
+	// OnUnrelated receives updates the round acknowledges without using:
+	// updates pending before the prompt and messages for other chats,
+	// topics or senders. Acknowledged updates are gone from Telegram, so
+	// callers can spool them for other tools. Session messages sent between
+	// two rounds of one Conversation are held for the next round instead.
+	// It is called before the offset moves past them; an error ends the
+	// round.
+	OnUnrelated func([]telegramapi.Update) error
+}
+
+type Attachment struct {
+	File    telegramapi.InputFile
+	Caption string
+}
+
+// EscalationTarget is a chat a prompt is handed on to, and how long it is
+// waited for.
+type EscalationTarget struct {
+	ChatID  string
+	Timeout time.Duration
+	// Reminders replace PromptOptions.Reminders in this chat.
+	Reminders []time.Duration
+}
+
+type Checkpoint struct {
+	MessageID int64
+	Offset    int64
+	// ChatID is the escalation target the prompt was sent to; empty for
+	// the conversation's own chat.
+	ChatID string
 }
 
 type PromptResult struct {
+	// ChatID is the chat the prompt was last sent to: the one that answered,
+	// or the last escalation target on timeout.
+	ChatID          string
 	RawReply        string
 	NormalizedReply string
+	Choices         []string
+	PromptMessageID int64
+	ReplyMessageID  int64
+	Sender          telegramapi.User
+	Transcribed     bool // RawReply is the transcript of a voice reply
+	Files           []ReceivedFile
+	Corrected       bool // an edit or /undo replaced an earlier answer
+	SentAt          time.Time
+	RepliedAt       time.Time
+}
+
+// Conversation keeps the update offset across prompts so several rounds can
+// share one polling loop.
+type Conversation struct {
+	api    sessionAPI
+	chatID string
+	offset int64
+	// prompts are the prompts this conversation sent or resumed, so taps
+	// on their keyboards can be told apart from taps on prompts of other
+	// sessions sharing the chat.
+	prompts map[promptRef]bool
+	// held are session messages that arrived after one round's answer and
+	// before the next prompt went out. They were acknowledged, so the next
+	// round reads them before polling instead of losing them.
+	held []telegramapi.Update
 }
 
-func RunPrompt(ctx context.Context, api sessionAPI, chatID string, prompt string, sessionTimeout time.Duration) (PromptResult, error) {
+func NewConversation(api sessionAPI, chatID string) (*Conversation, error) {
 	chatID = strings.TrimSpace(chatID)
 	if chatID == "" {
-		return PromptResult{}, errors.New("chatID is required")
+		return nil, errors.New("chatID is required")
 	}
+	return &Conversation{api: api, chatID: chatID, prompts: map[promptRef]bool{}}, nil
+}
+
+// promptRef identifies a prompt; message IDs are only unique per chat.
+type promptRef struct {
+	chatID    string
+	messageID int64
+}
+
+func RunPrompt(ctx context.Context, api sessionAPI, chatID string, prompt string, sessionTimeout time.Duration, opts PromptOptions) (PromptResult, error) {
+	conv, err := NewConversation(api, chatID)
+	if err != nil {
+		return PromptResult{}, err
+	}
+	return conv.Ask(ctx, prompt, sessionTimeout, opts)
+}
+
+// Ask sends prompt and waits for the answer, escalating through
+// opts.Escalation while chats stay silent.
+func (c *Conversation) Ask(ctx context.Context, prompt string, sessionTimeout time.Duration, opts PromptOptions) (PromptResult, error) {
+	targets := append([]EscalationTarget{{Timeout: sessionTimeout}}, opts.Escalation...)
+	for _, t := range opts.Escalation {
+		if strings.TrimSpace(t.ChatID) == "" {
+			return PromptResult{}, errors.New("escalation chat ID is required")
+		}
+		if t.Timeout <= 0 {
+			return PromptResult{}, fmt.Errorf("escalation timeout for chat %s must be greater than 0", t.ChatID)
+		}
+	}
+
+	start := 0
+	if opts.Resume != nil && opts.Resume.ChatID != "" {
+		start = slices.IndexFunc(targets, func(t EscalationTarget) bool { return t.ChatID == opts.Resume.ChatID })
+		if start < 0 {
+			return PromptResult{}, fmt.Errorf("resume: chat %s is not an escalation target", opts.Resume.ChatID)
+		}
+	}
+
+	// Each target is asked like the conversation's own chat, so chatID is
+	// switched for the duration of its round.
+	home := c.chatID
+	defer func() { c.chatID = home }()
 
+	var result PromptResult
+	var err error
+	for i := start; i < len(targets); i++ {
+		roundOpts, timeoutStatus := opts, expiredStatus
+		if i > 0 {
+			c.chatID = targets[i].ChatID
+			roundOpts.ThreadID = 0
+			roundOpts.Reminders = targets[i].Reminders
+		}
+		if i > start {
+			roundOpts.Resume = nil
+			if opts.OnEscalate != nil {
+				opts.OnEscalate(c.chatID)
+			}
+		}
+		if i < len(targets)-1 {
+			timeoutStatus = escalatedStatus
+		}
+
+		result, err = c.askChat(ctx, prompt, targets[i].Timeout, roundOpts, targets[i].ChatID, timeoutStatus)
+		if err == nil || errors.Is(err, ErrSessionTimeout) {
+			result.ChatID = c.sessionChatID()
+		}
+		if !errors.Is(err, ErrSessionTimeout) {
+			break
+		}
+	}
+	return result, err
+}
+
+// askChat runs one round in c.chatID. target is the escalation target's chat
+// ID recorded in checkpoints, empty for the conversation's own chat.
+func (c *Conversation) askChat(ctx context.Context, prompt string, sessionTimeout time.Duration, opts PromptOptions, target string, timeoutStatus string) (PromptResult, error) {
 	prompt = strings.TrimSpace(prompt)
 	if prompt == "" {
 		return PromptResult{}, errors.New("prompt is required")
@@ -37,75 +287,573 @@ func RunPrompt(ctx context.Context, api sessionAPI, chatID string, prompt string
 		return PromptResult{}, errors.New("session timeout must be greater than 0")
 	}
 
-	offset, err := latestUpdateOffset(ctx, api)
+	options, err := cleanOptions(opts.Options)
 	if err != nil {
-		return PromptResult{}, fmt.Errorf("read latest update offset: %w", err)
+		return PromptResult{}, err
+	}
+	switch opts.Match {
+	case "", MatchAny, MatchReply:
+	default:
+		return PromptResult{}, fmt.Errorf("unknown match mode %q", opts.Match)
+	}
+	switch opts.ParseMode {
+	case telegramformat.ParseModeNone, telegramformat.ParseModeMarkdownV2, telegramformat.ParseModeHTML:
+	default:
+		return PromptResult{}, fmt.Errorf("unknown parse mode %q", opts.ParseMode)
 	}
 
-	if _, err := api.SendMessage(ctx, chatID, prompt); err != nil {
-		return PromptResult{}, fmt.Errorf("send prompt: %w", err)
+	checkpoint := func(cp Checkpoint) error {
+		if opts.OnCheckpoint == nil {
+			return nil
+		}
+		if err := opts.OnCheckpoint(cp); err != nil {
+			return fmt.Errorf("save checkpoint: %w", err)
+		}
+		return nil
 	}
 
+	var promptMessageID int64
+	var sentAt time.Time
+	if opts.Resume != nil && opts.Resume.MessageID != 0 {
+		c.offset = opts.Resume.Offset
+		promptMessageID = opts.Resume.MessageID
+	} else {
+		if err := c.skipPending(ctx, opts); err != nil {
+			return PromptResult{}, fmt.Errorf("read latest update offset: %w", err)
+		}
+
+		for _, att := range opts.Attachments {
+			if _, err := c.api.SendDocument(ctx, c.chatID, att.File, telegramapi.DocumentOptions{
+				Caption:         att.Caption,
+				MessageThreadID: opts.ThreadID,
+			}); err != nil {
+				return PromptResult{}, fmt.Errorf("send attachment %s: %w", att.File.Name, err)
+			}
+		}
+
+		promptMessageID, err = c.sendPrompt(ctx, prompt, options, opts.ParseMode, opts.ThreadID)
+		if err != nil {
+			return PromptResult{}, err
+		}
+		sentAt = time.Now()
+		if err := checkpoint(Checkpoint{MessageID: promptMessageID, Offset: c.offset, ChatID: target}); err != nil {
+			return PromptResult{}, err
+		}
+	}
+	c.prompts[promptRef{c.chatID, promptMessageID}] = true
+	held := c.held
+	c.held = nil
+
 	waitCtx, cancel := context.WithTimeout(ctx, sessionTimeout)
 	defer cancel()
-	deadline := time.Now().Add(sessionTimeout)
+	waitStart := time.Now()
+	deadline := waitStart.Add(sessionTimeout)
+	reminders := reminderSchedule(opts.Reminders, sessionTimeout)
+
+	r := round{
+		opts:            opts,
+		options:         options,
+		promptMessageID: promptMessageID,
+		promptText:      lastChunk(prompt, opts.ParseMode),
+		sentAt:          sentAt,
+	}
+
+	// answer is held back during the grace window so an edit or /undo can
+	// still replace it; corrected records that one did.
+	var answer *PromptResult
+	var graceUntil time.Time
+	corrected := false
+	accept := func(result PromptResult) {
+		if answer != nil {
+			corrected = true
+		}
+		result.Corrected = corrected
+		if answer == nil {
+			graceUntil = time.Now().Add(opts.GraceWindow)
+			if graceUntil.After(deadline) {
+				graceUntil = deadline
+			}
+		}
+		answer = &result
+	}
+
+	finish := func() (PromptResult, error) {
+		if opts.MarkStatus {
+			c.markStatus(ctx, r, answeredStatusFor(*answer))
+		}
+		return *answer, nil
+	}
 
 	for {
+		if answer != nil && !time.Now().Before(graceUntil) {
+			return finish()
+		}
 		if err := waitCtx.Err(); err != nil {
+			if answer != nil {
+				return finish()
+			}
 			if errors.Is(err, context.DeadlineExceeded) {
-				return PromptResult{}, ErrSessionTimeout
+				if opts.MarkStatus {
+					c.markStatus(ctx, r, timeoutStatus)
+				}
+				return PromptResult{PromptMessageID: promptMessageID, SentAt: sentAt}, ErrSessionTimeout
 			}
 			return PromptResult{}, err
 		}
 
-		pollTimeout := computePollTimeout(time.Until(deadline))
-		updates, err := api.GetUpdates(waitCtx, offset, pollTimeout)
-		if err != nil {
-			if waitCtx.Err() != nil {
-				continue
+		if answer == nil && len(reminders) > 0 && time.Since(waitStart) >= reminders[0] {
+			// Reminders that came due together are sent as one.
+			for len(reminders) > 0 && time.Since(waitStart) >= reminders[0] {
+				reminders = reminders[1:]
 			}
-			return PromptResult{}, fmt.Errorf("poll updates: %w", err)
+			c.sendReminder(waitCtx, r, time.Until(deadline))
 		}
 
-		for _, update := range updates {
-			if update.UpdateID >= offset {
-				offset = update.UpdateID + 1
+		wait := time.Until(deadline)
+		if answer != nil {
+			wait = time.Until(graceUntil)
+		} else if len(reminders) > 0 {
+			wait = min(wait, time.Until(waitStart.Add(reminders[0])))
+		}
+		updates, fromHeld := held, len(held) > 0
+		held = nil
+		if !fromHeld {
+			var err error
+			updates, err = c.api.GetUpdates(waitCtx, c.offset, computePollTimeout(wait))
+			if err != nil {
+				if waitCtx.Err() != nil {
+					continue
+				}
+				return PromptResult{}, fmt.Errorf("poll updates: %w", err)
 			}
-			if fmt.Sprintf("%d", update.Message.Chat.ID) != chatID {
-				continue
+		}
+		// keepRest holds back what the round did not get to. Polled
+		// updates stay unacknowledged on Telegram instead.
+		keepRest := func(rest []telegramapi.Update) {
+			if fromHeld {
+				c.held = append(c.held, rest...)
+			}
+		}
+
+		batchStart := c.offset
+		var unrelated []telegramapi.Update
+		flushUnrelated := func() error {
+			if opts.OnUnrelated == nil || len(unrelated) == 0 {
+				return nil
+			}
+			if err := opts.OnUnrelated(unrelated); err != nil {
+				return fmt.Errorf("spool unrelated updates: %w", err)
+			}
+			unrelated = nil
+			return nil
+		}
+		for i, update := range updates {
+			if answer != nil && c.endsGraceWindow(update, opts) {
+				// A new message is not a correction; leave it
+				// unacknowledged for whoever reads next.
+				if err := flushUnrelated(); err != nil {
+					return PromptResult{}, err
+				}
+				keepRest(updates[i:])
+				return finish()
+			}
+			if update.UpdateID >= c.offset {
+				c.offset = update.UpdateID + 1
 			}
 
-			raw := strings.TrimSpace(update.Message.Text)
-			if raw == "" {
+			if edited := update.EditedMessage; edited != nil {
+				if answer == nil || answer.ReplyMessageID != edited.MessageID || fmt.Sprintf("%d", edited.Chat.ID) != c.sessionChatID() {
+					unrelated = append(unrelated, update)
+					continue
+				}
+				result, outcome, err := c.evaluateMessage(waitCtx, *edited, r)
+				if err != nil {
+					return PromptResult{}, err
+				}
+				if outcome == outcomeAnswer {
+					accept(result)
+				} else {
+					// The edit is no longer a valid answer, so the
+					// original must not be used either.
+					answer, corrected = nil, true
+				}
 				continue
 			}
 
-			return PromptResult{
-				RawReply:        raw,
-				NormalizedReply: normalizeReply(raw),
-			}, nil
+			if update.CallbackQuery != nil {
+				choice, ok := matchCallback(update.CallbackQuery, c.sessionChatID(), promptMessageID, options)
+				if !ok {
+					if c.isStaleTap(update.CallbackQuery, promptMessageID) {
+						// Otherwise the button keeps spinning.
+						_ = c.api.AnswerCallbackQuery(waitCtx, update.CallbackQuery.ID, staleTapNotice)
+						continue
+					}
+					unrelated = append(unrelated, update)
+					continue
+				}
+				if !telegramapi.SenderAllowed(&update.CallbackQuery.From, opts.AllowedUserIDs) {
+					_ = c.api.AnswerCallbackQuery(waitCtx, update.CallbackQuery.ID, notAllowedNotice)
+					continue
+				}
+				// A failed acknowledgement only leaves a spinner on the
+				// button; the tap itself is still a valid answer.
+				_ = c.api.AnswerCallbackQuery(waitCtx, update.CallbackQuery.ID, choice)
+
+				accept(PromptResult{
+					RawReply:        choice,
+					NormalizedReply: choice,
+					Choices:         []string{choice},
+					PromptMessageID: promptMessageID,
+					ReplyMessageID:  promptMessageID,
+					Sender:          update.CallbackQuery.From,
+					SentAt:          sentAt,
+					RepliedAt:       time.Now(),
+				})
+			} else {
+				if !c.fromSession(update.Message, opts) ||
+					(opts.Match == MatchReply && !isReplyTo(update.Message, promptMessageID) && !isUndo(update.Message.Text)) {
+					unrelated = append(unrelated, update)
+					continue
+				}
+
+				if isUndo(update.Message.Text) {
+					notice := nothingToUndoNotice
+					if answer != nil {
+						answer, corrected, notice = nil, true, undoneNotice
+					}
+					if err := c.replyNotice(waitCtx, notice, update.Message.MessageID, opts.ThreadID); err != nil && waitCtx.Err() == nil {
+						return PromptResult{}, fmt.Errorf("send undo notice: %w", err)
+					}
+					continue
+				}
+
+				result, outcome, err := c.evaluateMessage(waitCtx, update.Message, r)
+				if err != nil {
+					return PromptResult{}, err
+				}
+				switch outcome {
+				case outcomeIgnored:
+					unrelated = append(unrelated, update)
+					continue
+				case outcomeHandled:
+					continue
+				}
+				accept(result)
+			}
+
+			if opts.GraceWindow <= 0 {
+				if err := flushUnrelated(); err != nil {
+					return PromptResult{}, err
+				}
+				keepRest(updates[i+1:])
+				return finish()
+			}
+		}
+
+		if err := flushUnrelated(); err != nil {
+			return PromptResult{}, err
+		}
+		if c.offset != batchStart {
+			if err := checkpoint(Checkpoint{MessageID: promptMessageID, Offset: c.offset, ChatID: target}); err != nil {
+				return PromptResult{}, err
+			}
+		}
+	}
+}
+
+// round is what evaluateMessage needs to know about the prompt being
+// answered.
+type round struct {
+	opts            PromptOptions
+	options         []string
+	promptMessageID int64
+	promptText      string // the last chunk, as sent
+	sentAt          time.Time
+}
+
+type messageOutcome int
+
+const (
+	// outcomeIgnored: the message carries nothing to answer with.
+	outcomeIgnored messageOutcome = iota
+	// outcomeHandled: the message was answered with a re-ask or notice.
+	outcomeHandled
+	// outcomeAnswer: the message answers the prompt.
+	outcomeAnswer
+)
+
+// evaluateMessage turns a message from the session into an answer: a saved
+// file, a transcribed voice note, or text matched against the options.
+func (c *Conversation) evaluateMessage(ctx context.Context, msg telegramapi.Message, r round) (PromptResult, messageOutcome, error) {
+	result := PromptResult{
+		PromptMessageID: r.promptMessageID,
+		ReplyMessageID:  msg.MessageID,
+		SentAt:          r.sentAt,
+	}
+	if msg.From != nil {
+		result.Sender = *msg.From
+	}
+
+	if hasFile(msg) {
+		file, notice := c.saveFileReply(ctx, msg, r.opts.AttachmentDir, r.promptMessageID)
+		if notice != "" {
+			return PromptResult{}, outcomeHandled, c.noticeUnlessDone(ctx, notice, msg.MessageID, r.opts.ThreadID, "file")
+		}
+		caption := strings.TrimSpace(msg.Caption)
+		result.RawReply = caption
+		result.NormalizedReply = normalizeReply(caption)
+		result.Files = []ReceivedFile{file}
+		result.RepliedAt = time.Now()
+		return result, outcomeAnswer, nil
+	}
+
+	raw := strings.TrimSpace(msg.Text)
+	if raw == "" && hasVoice(msg) {
+		text, notice := c.transcribeReply(ctx, msg, r.opts.Transcriber)
+		if notice != "" {
+			return PromptResult{}, outcomeHandled, c.noticeUnlessDone(ctx, notice, msg.MessageID, r.opts.ThreadID, "voice")
 		}
+		raw, result.Transcribed = text, true
 	}
+	if raw == "" {
+		return PromptResult{}, outcomeIgnored, nil
+	}
+
+	choices, matched := matchTypedChoices(raw, r.options, r.opts.MultiSelect)
+	if len(r.options) > 0 && !matched && !r.opts.AllowFreeText {
+		if err := c.replyNotice(ctx, buildReaskMessage(r.options, r.opts.MultiSelect), msg.MessageID, r.opts.ThreadID); err != nil {
+			return PromptResult{}, outcomeHandled, fmt.Errorf("send re-ask: %w", err)
+		}
+		return PromptResult{}, outcomeHandled, nil
+	}
+
+	result.RawReply = raw
+	result.NormalizedReply = normalizeReply(raw)
+	if matched {
+		result.NormalizedReply = strings.Join(choices, ", ")
+	}
+	result.Choices = choices
+	result.RepliedAt = time.Now()
+	return result, outcomeAnswer, nil
+}
+
+// noticeUnlessDone sends a notice unless the round already ended, in which
+// case the loop reports the timeout instead.
+func (c *Conversation) noticeUnlessDone(ctx context.Context, notice string, replyTo int64, threadID int64, kind string) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := c.replyNotice(ctx, notice, replyTo, threadID); err != nil {
+		return fmt.Errorf("send %s notice: %w", kind, err)
+	}
+	return nil
+}
+
+// sessionChatID is the chat prompts actually went to, which differs from
+// the configured one after a group was upgraded to a supergroup.
+func (c *Conversation) sessionChatID() string {
+	return c.api.EffectiveChatID(c.chatID)
+}
+
+// fromSession reports whether msg was posted where the prompt was, by
+// someone allowed to answer it.
+func (c *Conversation) fromSession(msg telegramapi.Message, opts PromptOptions) bool {
+	return fmt.Sprintf("%d", msg.Chat.ID) == c.sessionChatID() &&
+		telegramapi.SenderAllowed(msg.From, opts.AllowedUserIDs) &&
+		(opts.ThreadID == 0 || msg.MessageThreadID == opts.ThreadID)
+}
+
+// endsGraceWindow reports whether update is a new message in the session
+// rather than a correction (an edit, /undo, or another button tap).
+func (c *Conversation) endsGraceWindow(update telegramapi.Update, opts PromptOptions) bool {
+	if update.CallbackQuery != nil || update.EditedMessage != nil {
+		return false
+	}
+	return c.fromSession(update.Message, opts) && !isUndo(update.Message.Text)
+}
+
+// replyNotice answers a message that could not be used, for example a voice
+// note that failed to transcribe, without ending the round.
+func (c *Conversation) replyNotice(ctx context.Context, text string, replyTo int64, threadID int64) error {
+	_, err := c.api.SendMessageWithOptions(ctx, c.chatID, text, telegramapi.SendOptions{
+		ReplyToMessageID: replyTo,
+		MessageThreadID:  threadID,
+	})
+	return err
+}
+
+// sendPrompt sends the prompt, split into chunks when it is too long for one
+// message, and returns the ID of the last chunk, which carries the keyboard
+// and is what callbacks and replies refer to.
+func (c *Conversation) sendPrompt(ctx context.Context, prompt string, options []string, mode telegramformat.ParseMode, threadID int64) (int64, error) {
+	chunks := telegramformat.Render(prompt, mode, telegramformat.MaxMessageLength)
+	if len(chunks) == 0 {
+		return 0, errors.New("prompt is empty after formatting")
+	}
+
+	var messageID int64
+	for i, chunk := range chunks {
+		sendOpts := telegramapi.SendOptions{ParseMode: string(mode), MessageThreadID: threadID}
+		if i == len(chunks)-1 {
+			sendOpts.ReplyMarkup = buildKeyboard(options)
+		}
+
+		id, err := c.api.SendMessageWithOptions(ctx, c.chatID, chunk, sendOpts)
+		if err != nil {
+			if len(chunks) > 1 {
+				return 0, fmt.Errorf("send prompt chunk %d/%d: %w", i+1, len(chunks), err)
+			}
+			return 0, fmt.Errorf("send prompt: %w", err)
+		}
+		messageID = id
+	}
+	return messageID, nil
+}
+
+func cleanOptions(raw []string) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	options := make([]string, 0, len(raw))
+	for _, opt := range raw {
+		opt = strings.TrimSpace(opt)
+		if opt == "" {
+			return nil, errors.New("options must not be empty")
+		}
+		options = append(options, opt)
+	}
+	return options, nil
+}
+
+func buildKeyboard(options []string) *telegramapi.InlineKeyboardMarkup {
+	if len(options) == 0 {
+		return nil
+	}
+
+	rows := make([][]telegramapi.InlineKeyboardButton, 0, len(options))
+	for i, opt := range options {
+		rows = append(rows, []telegramapi.InlineKeyboardButton{{
+			Text:         opt,
+			CallbackData: callbackDataPrefix + strconv.Itoa(i),
+		}})
+	}
+	return &telegramapi.InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+// isStaleTap reports whether cb is a button on an earlier prompt of this
+// conversation, whose keyboard outlived its round. Taps on prompts sent by
+// other sessions in the chat are theirs to answer.
+func (c *Conversation) isStaleTap(cb *telegramapi.CallbackQuery, promptMessageID int64) bool {
+	return cb.Message != nil && cb.Message.MessageID != promptMessageID &&
+		c.prompts[promptRef{c.chatID, cb.Message.MessageID}] &&
+		strings.HasPrefix(cb.Data, callbackDataPrefix) &&
+		fmt.Sprintf("%d", cb.Message.Chat.ID) == c.sessionChatID()
+}
+
+func matchCallback(cb *telegramapi.CallbackQuery, chatID string, promptMessageID int64, options []string) (string, bool) {
+	if cb.Message == nil || cb.Message.MessageID != promptMessageID {
+		return "", false
+	}
+	if fmt.Sprintf("%d", cb.Message.Chat.ID) != chatID {
+		return "", false
+	}
+
+	idx, err := strconv.Atoi(strings.TrimPrefix(cb.Data, callbackDataPrefix))
+	if err != nil || !strings.HasPrefix(cb.Data, callbackDataPrefix) || idx < 0 || idx >= len(options) {
+		return "", false
+	}
+	return options[idx], true
+}
+
+func matchTypedChoices(raw string, options []string, multiSelect bool) ([]string, bool) {
+	idx, ok := ParseChoices(raw, options)
+	if !ok || (len(idx) > 1 && !multiSelect) {
+		return nil, false
+	}
+
+	choices := make([]string, 0, len(idx))
+	for _, i := range idx {
+		choices = append(choices, options[i])
+	}
+	return choices, true
+}
+
+func buildReaskMessage(options []string, multiSelect bool) string {
+	var b strings.Builder
+	b.WriteString("未能识别你的回复，请从以下选项中选择：\n")
+	for i, opt := range options {
+		if declaredLabel.MatchString(opt) {
+			fmt.Fprintf(&b, "%s\n", opt)
+			continue
+		}
+		fmt.Fprintf(&b, "%d) %s\n", i+1, opt)
+	}
+	if multiSelect {
+		b.WriteString("可点击上方按钮，或回复字母/序号，多选用逗号分隔，例如 1,3。")
+	} else {
+		b.WriteString("可点击上方按钮，或回复一个字母/序号，例如 A 或 1。")
+	}
+	return b.String()
+}
+
+// isUndo accepts "/undo" and the "/undo@botname" form used in groups.
+func isUndo(text string) bool {
+	cmd, _, _ := strings.Cut(strings.TrimSpace(text), "@")
+	return strings.EqualFold(cmd, "/undo")
+}
+
+func isReplyTo(msg telegramapi.Message, messageID int64) bool {
+	return msg.ReplyToMessage != nil && msg.ReplyToMessage.MessageID == messageID
 }
 
 func normalizeReply(raw string) string {
 	return strings.TrimSpace(raw)
 }
 
-func latestUpdateOffset(ctx context.Context, api sessionAPI) (int64, error) {
-	updates, err := api.GetUpdates(ctx, 0, 0)
+// askedBefore reports whether this conversation already sent a prompt to the
+// current chat.
+func (c *Conversation) askedBefore() bool {
+	for p := range c.prompts {
+		if p.chatID == c.chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// skipPending acknowledges updates that arrived before the prompt is sent.
+// Before a conversation's first prompt they are stale and never taken as an
+// answer. After an earlier round, session messages are what the user sent
+// once that round was answered, often meant for the next question, and are
+// held for it. The rest are handed to OnUnrelated first.
+func (c *Conversation) skipPending(ctx context.Context, opts PromptOptions) error {
+	updates, err := c.api.GetUpdates(ctx, c.offset, 0)
 	if err != nil {
-		return 0, err
+		return err
+	}
+
+	var unrelated []telegramapi.Update
+	for _, update := range updates {
+		if c.askedBefore() && update.CallbackQuery == nil && update.EditedMessage == nil && c.fromSession(update.Message, opts) {
+			c.held = append(c.held, update)
+			continue
+		}
+		unrelated = append(unrelated, update)
+	}
+	if opts.OnUnrelated != nil && len(unrelated) > 0 {
+		if err := opts.OnUnrelated(unrelated); err != nil {
+			return fmt.Errorf("spool pending updates: %w", err)
+		}
 	}
 
-	var offset int64
 	for _, update := range updates {
-		if update.UpdateID >= offset {
-			offset = update.UpdateID + 1
+		if update.UpdateID >= c.offset {
+			c.offset = update.UpdateID + 1
 		}
 	}
 
-	return offset, nil
+	return nil
 }
 
 func computePollTimeout(remaining time.Duration) int {
@@ -3,24 +3,99 @@ package telegrambrainstorm
 import (
 	"context"
 	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"codex-brainstorming-telegram/internal/telegramapi"
+	"codex-brainstorming-telegram/internal/telegramformat"
+	"codex-brainstorming-telegram/internal/transcribe"
 )
 
 type fakeAPI struct {
 	polls    [][]telegramapi.Update
 	pollIdx  int
 	sentText []string
+	// sentChats are the chats of sentText, in order.
+	sentChats []string
+	sentOpts  []telegramapi.SendOptions
+	answered  []string
+	// answerTexts holds the notice sent with each entry of answered.
+	answerTexts []string
+	offsets     []int64
+	sendErr     error
+	docs        []telegramapi.InputFile
+	files       map[string][]byte
+	edits       []fakeEdit
+	editErr     error
+	// migratedTo is the supergroup the chat was upgraded to, if any.
+	migratedTo string
 }
 
-func (f *fakeAPI) SendMessage(_ context.Context, _ string, text string) (int64, error) {
+func (f *fakeAPI) EffectiveChatID(chatID string) string {
+	if f.migratedTo != "" {
+		return f.migratedTo
+	}
+	return chatID
+}
+
+type fakeEdit struct {
+	messageID  int64
+	text       string
+	opts       telegramapi.EditOptions
+	markupOnly bool
+}
+
+func (f *fakeAPI) EditMessageText(_ context.Context, _ string, messageID int64, text string, opts telegramapi.EditOptions) error {
+	f.edits = append(f.edits, fakeEdit{messageID: messageID, text: text, opts: opts})
+	return f.editErr
+}
+
+func (f *fakeAPI) EditMessageReplyMarkup(_ context.Context, _ string, messageID int64, markup *telegramapi.InlineKeyboardMarkup) error {
+	f.edits = append(f.edits, fakeEdit{messageID: messageID, opts: telegramapi.EditOptions{ReplyMarkup: markup}, markupOnly: true})
+	return nil
+}
+
+func (f *fakeAPI) SendMessageWithOptions(_ context.Context, chatID string, text string, opts telegramapi.SendOptions) (int64, error) {
+	if f.sendErr != nil {
+		return 0, f.sendErr
+	}
+	f.sentChats = append(f.sentChats, chatID)
 	f.sentText = append(f.sentText, text)
+	f.sentOpts = append(f.sentOpts, opts)
 	return int64(len(f.sentText)), nil
 }
 
-func (f *fakeAPI) GetUpdates(_ context.Context, _ int64, _ int) ([]telegramapi.Update, error) {
+func (f *fakeAPI) SendDocument(_ context.Context, _ string, doc telegramapi.InputFile, _ telegramapi.DocumentOptions) (int64, error) {
+	f.docs = append(f.docs, doc)
+	f.sentText = append(f.sentText, "[document] "+doc.Name)
+	f.sentOpts = append(f.sentOpts, telegramapi.SendOptions{})
+	return int64(len(f.sentText)), nil
+}
+
+func (f *fakeAPI) GetFile(_ context.Context, fileID string) (telegramapi.File, error) {
+	data, ok := f.files[fileID]
+	if !ok {
+		return telegramapi.File{}, errors.New("file not found")
+	}
+	return telegramapi.File{FileID: fileID, FileSize: int64(len(data)), FilePath: "files/" + fileID}, nil
+}
+
+func (f *fakeAPI) DownloadFile(_ context.Context, filePath string) ([]byte, error) {
+	return f.files[strings.TrimPrefix(filePath, "files/")], nil
+}
+
+func (f *fakeAPI) AnswerCallbackQuery(_ context.Context, callbackQueryID string, text string) error {
+	f.answered = append(f.answered, callbackQueryID)
+	f.answerTexts = append(f.answerTexts, text)
+	return nil
+}
+
+func (f *fakeAPI) GetUpdates(_ context.Context, offset int64, _ int) ([]telegramapi.Update, error) {
+	f.offsets = append(f.offsets, offset)
 	if f.pollIdx >= len(f.polls) {
 		return nil, nil
 	}
@@ -47,7 +122,7 @@ func TestRunPromptSuccess(t *testing.T) {
 	defer cancel()
 
 	prompt := "请选择方案：\nA) 低风险\nB) 平衡\nC) 激进\n请回复 A/B/C。"
-	result, err := RunPrompt(ctx, api, "1001", prompt, 2*time.Second)
+	result, err := RunPrompt(ctx, api, "1001", prompt, 2*time.Second, PromptOptions{})
 	if err != nil {
 		t.Fatalf("RunPrompt() error = %v", err)
 	}
@@ -73,11 +148,1021 @@ func TestRunPromptTimeout(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	_, err := RunPrompt(ctx, api, "1001", "A/B/C?", 20*time.Millisecond)
+	result, err := RunPrompt(ctx, api, "1001", "A/B/C?", 20*time.Millisecond, PromptOptions{})
 	if err == nil {
 		t.Fatal("RunPrompt() error = nil, want timeout error")
 	}
 	if !errors.Is(err, ErrSessionTimeout) {
 		t.Fatalf("RunPrompt() error = %v, want %v", err, ErrSessionTimeout)
 	}
+	if result.PromptMessageID != 1 || result.SentAt.IsZero() {
+		t.Fatalf("result = %+v, want the timed-out prompt identified", result)
+	}
+}
+
+// escalationAPI answers from chat 2002 once the prompt was sent there.
+type escalationAPI struct {
+	*fakeAPI
+	answered bool
+}
+
+func (f *escalationAPI) GetUpdates(ctx context.Context, offset int64, limit int) ([]telegramapi.Update, error) {
+	if !f.answered && slices.Contains(f.sentChats, "2002") {
+		f.answered = true
+		reply := textUpdate(5, 50, "B")
+		reply.Message.Chat.ID = 2002
+		return []telegramapi.Update{reply}, nil
+	}
+	return f.fakeAPI.GetUpdates(ctx, offset, limit)
+}
+
+func TestRunPromptEscalatesToNextChat(t *testing.T) {
+	t.Parallel()
+
+	api := &escalationAPI{fakeAPI: &fakeAPI{}}
+	var escalated []string
+	result, err := RunPrompt(context.Background(), api, "1001", "A or B?", 30*time.Millisecond, PromptOptions{
+		Options:    []string{"A", "B"},
+		ThreadID:   7,
+		MarkStatus: true,
+		Escalation: []EscalationTarget{{ChatID: "2002", Timeout: time.Second}},
+		OnEscalate: func(chatID string) { escalated = append(escalated, chatID) },
+	})
+	if err != nil {
+		t.Fatalf("RunPrompt() error = %v", err)
+	}
+	if result.ChatID != "2002" || result.NormalizedReply != "B" || result.PromptMessageID != 2 {
+		t.Fatalf("result = %+v, want B answered in chat 2002 to prompt 2", result)
+	}
+	if !slices.Equal(api.sentChats, []string{"1001", "2002"}) || !slices.Equal(escalated, []string{"2002"}) {
+		t.Fatalf("sent to %q, escalated to %q, want the prompt in 1001 then 2002", api.sentChats, escalated)
+	}
+	if api.sentOpts[1].MessageThreadID != 0 {
+		t.Fatalf("escalated prompt thread = %d, want none", api.sentOpts[1].MessageThreadID)
+	}
+	if len(api.edits) != 2 || api.edits[0].messageID != 1 || api.edits[0].text != "A or B?\n\n"+escalatedStatus {
+		t.Fatalf("edits = %+v, want prompt 1 marked as handed on", api.edits)
+	}
+}
+
+func TestRunPromptEscalationTimesOutInLastChat(t *testing.T) {
+	t.Parallel()
+
+	api := &fakeAPI{}
+	result, err := RunPrompt(context.Background(), api, "1001", "A or B?", 20*time.Millisecond, PromptOptions{
+		Escalation: []EscalationTarget{{ChatID: "2002", Timeout: 20 * time.Millisecond}},
+	})
+	if !errors.Is(err, ErrSessionTimeout) {
+		t.Fatalf("RunPrompt() error = %v, want ErrSessionTimeout", err)
+	}
+	if result.ChatID != "2002" || result.PromptMessageID != 2 {
+		t.Fatalf("result = %+v, want the prompt in chat 2002 identified", result)
+	}
+
+	_, err = RunPrompt(context.Background(), api, "1001", "A or B?", time.Second, PromptOptions{
+		Escalation: []EscalationTarget{{ChatID: "2002"}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "escalation timeout") {
+		t.Fatalf("RunPrompt() error = %v, want escalation timeout error", err)
+	}
+}
+
+func TestRunPromptRemindsAndMarksExpired(t *testing.T) {
+	t.Parallel()
+
+	api := &fakeAPI{}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := RunPrompt(ctx, api, "1001", "A or B?", 150*time.Millisecond, PromptOptions{
+		Options:    []string{"A", "B"},
+		Reminders:  []time.Duration{100 * time.Millisecond, 50 * time.Millisecond, time.Hour},
+		MarkStatus: true,
+	})
+	if !errors.Is(err, ErrSessionTimeout) {
+		t.Fatalf("RunPrompt() error = %v, want ErrSessionTimeout", err)
+	}
+
+	if len(api.sentText) != 3 {
+		t.Fatalf("sent = %q, want prompt and two reminders", api.sentText)
+	}
+	for i := 1; i < 3; i++ {
+		if !strings.HasPrefix(api.sentText[i], "⏰") || api.sentOpts[i].ReplyToMessageID != 1 {
+			t.Fatalf("reminder %d = %q replying to %d, want reminder replying to prompt 1", i, api.sentText[i], api.sentOpts[i].ReplyToMessageID)
+		}
+	}
+
+	if len(api.edits) != 1 {
+		t.Fatalf("edits = %+v, want 1", api.edits)
+	}
+	edit := api.edits[0]
+	if edit.messageID != 1 || edit.text != "A or B?\n\n"+expiredStatus || edit.opts.ReplyMarkup != nil {
+		t.Fatalf("edit = %+v, want expired status without keyboard", edit)
+	}
+}
+
+func TestRunPromptSkipsRemindersOnceAnswered(t *testing.T) {
+	t.Parallel()
+
+	reply := telegramapi.Update{UpdateID: 2}
+	reply.Message.Chat.ID = 1001
+	reply.Message.Text = "B"
+	api := &fakeAPI{polls: [][]telegramapi.Update{nil, {reply}}}
+
+	result, err := RunPrompt(context.Background(), api, "1001", "A or B?", time.Second, PromptOptions{
+		Reminders:   []time.Duration{time.Millisecond},
+		MarkStatus:  true,
+		GraceWindow: 50 * time.Millisecond,
+	})
+	if err != nil || result.RawReply != "B" {
+		t.Fatalf("RunPrompt() = %+v, %v, want B", result, err)
+	}
+	for _, text := range api.sentText[1:] {
+		if !strings.HasPrefix(text, "⏰") {
+			t.Fatalf("sent = %q, want only reminders after the prompt", api.sentText)
+		}
+	}
+	if len(api.edits) != 1 || api.edits[0].text != "A or B?\n\n✅ 已回答：B" {
+		t.Fatalf("edits = %+v, want the prompt marked answered", api.edits)
+	}
+}
+
+func TestRunPromptMarkStatusFallsBackToRemovingKeyboard(t *testing.T) {
+	t.Parallel()
+
+	tap := telegramapi.Update{UpdateID: 2, CallbackQuery: &telegramapi.CallbackQuery{
+		ID:      "cb-1",
+		Data:    "opt:0",
+		Message: &telegramapi.Message{MessageID: 1},
+	}}
+	tap.CallbackQuery.Message.Chat.ID = 1001
+
+	// The last chunk already fills a whole message, so the status cannot
+	// be appended.
+	long := strings.Repeat("x", telegramformat.MaxMessageLength)
+	api := &fakeAPI{polls: [][]telegramapi.Update{nil, {tap}}}
+	result, err := RunPrompt(context.Background(), api, "1001", long, time.Second, PromptOptions{
+		Options:    []string{"A", "B"},
+		MarkStatus: true,
+	})
+	if err != nil || result.NormalizedReply != "A" {
+		t.Fatalf("RunPrompt() = %+v, %v, want A", result, err)
+	}
+	if len(api.edits) != 1 || !api.edits[0].markupOnly || api.edits[0].opts.ReplyMarkup != nil {
+		t.Fatalf("edits = %+v, want only the keyboard removed", api.edits)
+	}
+
+	// A failed text edit also falls back to removing the keyboard.
+	api = &fakeAPI{polls: [][]telegramapi.Update{nil, {tap}}, editErr: errors.New("Bad Request: can't parse entities")}
+	if _, err := RunPrompt(context.Background(), api, "1001", "A or B?", time.Second, PromptOptions{
+		Options:    []string{"A", "B"},
+		MarkStatus: true,
+	}); err != nil {
+		t.Fatalf("RunPrompt() error = %v", err)
+	}
+	if len(api.edits) != 2 || api.edits[0].text != "A or B?\n\n✅ 已回答：A" || !api.edits[1].markupOnly {
+		t.Fatalf("edits = %+v, want a text edit and then a keyboard removal", api.edits)
+	}
+}
+
+func TestConversationAnswersTapsOnItsEarlierPrompts(t *testing.T) {
+	t.Parallel()
+
+	tapOn := func(updateID int64, id string, messageID int64, data string) telegramapi.Update {
+		return telegramapi.Update{UpdateID: updateID, CallbackQuery: &telegramapi.CallbackQuery{
+			ID:      id,
+			Message: &telegramapi.Message{MessageID: messageID, Chat: telegramapi.Chat{ID: 1001}},
+			Data:    data,
+		}}
+	}
+
+	// Message 1 is another session's live prompt; this conversation sends
+	// messages 2 and 3.
+	api := &fakeAPI{
+		sentText: []string{"other session's prompt"},
+		polls: [][]telegramapi.Update{
+			nil,
+			{tapOn(1, "cb-first", 2, "opt:0")},
+			nil,
+			{tapOn(2, "cb-stale", 2, "opt:1"), tapOn(3, "cb-other", 1, "opt:0"), tapOn(4, "cb-second", 3, "opt:1")},
+		},
+	}
+	conv, err := NewConversation(api, "1001")
+	if err != nil {
+		t.Fatalf("NewConversation() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	opts := PromptOptions{Options: []string{"A", "B"}}
+	if _, err := conv.Ask(ctx, "first?", 2*time.Second, opts); err != nil {
+		t.Fatalf("first Ask() error = %v", err)
+	}
+	result, err := conv.Ask(ctx, "second?", 2*time.Second, opts)
+	if err != nil {
+		t.Fatalf("second Ask() error = %v", err)
+	}
+	if result.NormalizedReply != "B" || result.PromptMessageID != 3 {
+		t.Fatalf("result = %+v, want B on message 3", result)
+	}
+	want := []string{"cb-first", "cb-stale", "cb-second"}
+	if strings.Join(api.answered, ",") != strings.Join(want, ",") || api.answerTexts[1] != staleTapNotice {
+		t.Fatalf("answered = %q with %q, want %q with the stale tap told %q", api.answered, api.answerTexts, want, staleTapNotice)
+	}
+}
+
+func TestRunPromptInlineKeyboardTap(t *testing.T) {
+	t.Parallel()
+
+	stale := telegramapi.Update{UpdateID: 2, CallbackQuery: &telegramapi.CallbackQuery{
+		ID:      "cb-stale",
+		Message: &telegramapi.Message{MessageID: 99, Chat: telegramapi.Chat{ID: 1001}},
+		Data:    "opt:0",
+	}}
+	tap := telegramapi.Update{UpdateID: 3, CallbackQuery: &telegramapi.CallbackQuery{
+		ID:      "cb-1",
+		From:    telegramapi.User{ID: 55, Username: "alice"},
+		Message: &telegramapi.Message{MessageID: 1, Chat: telegramapi.Chat{ID: 1001}},
+		Data:    "opt:1",
+	}}
+
+	api := &fakeAPI{
+		polls: [][]telegramapi.Update{
+			nil,
+			{stale, tap},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	result, err := RunPrompt(ctx, api, "1001", "请选择方案", 2*time.Second, PromptOptions{
+		Options: []string{"A) 低风险", "B) 平衡"},
+	})
+	if err != nil {
+		t.Fatalf("RunPrompt() error = %v", err)
+	}
+
+	if got, want := result.NormalizedReply, "B) 平衡"; got != want {
+		t.Fatalf("result.NormalizedReply = %q, want %q", got, want)
+	}
+	if len(result.Choices) != 1 || result.Choices[0] != "B) 平衡" {
+		t.Fatalf("result.Choices = %q", result.Choices)
+	}
+	if result.Sender.ID != 55 || result.SentAt.IsZero() || result.RepliedAt.IsZero() {
+		t.Fatalf("result sender/timestamps = %+v", result)
+	}
+	if len(api.answered) != 1 || api.answered[0] != "cb-1" {
+		t.Fatalf("answered = %q, want [cb-1]", api.answered)
+	}
+
+	markup := api.sentOpts[0].ReplyMarkup
+	if markup == nil || len(markup.InlineKeyboard) != 2 {
+		t.Fatalf("reply markup = %+v, want two rows", markup)
+	}
+	if got := markup.InlineKeyboard[1][0].CallbackData; got != "opt:1" {
+		t.Fatalf("callback data = %q, want opt:1", got)
+	}
+}
+
+func TestRunPromptResumeDoesNotResend(t *testing.T) {
+	t.Parallel()
+
+	reply := telegramapi.Update{UpdateID: 9}
+	reply.Message.Chat.ID = 1001
+	reply.Message.Text = "A"
+
+	api := &fakeAPI{polls: [][]telegramapi.Update{{reply}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var checkpoints []Checkpoint
+	result, err := RunPrompt(ctx, api, "1001", "A/B?", 2*time.Second, PromptOptions{
+		Resume:      &Checkpoint{MessageID: 5, Offset: 8},
+		Attachments: []Attachment{{File: telegramapi.InputFile{Name: "plan.md", Data: []byte("# Plan")}}},
+		OnCheckpoint: func(cp Checkpoint) error {
+			checkpoints = append(checkpoints, cp)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("RunPrompt() error = %v", err)
+	}
+
+	if result.NormalizedReply != "A" {
+		t.Fatalf("result.NormalizedReply = %q, want A", result.NormalizedReply)
+	}
+	if len(api.sentText) != 0 {
+		t.Fatalf("sent = %q, want no resend", api.sentText)
+	}
+	if api.pollIdx != 1 {
+		t.Fatalf("polls = %d, want 1 (no offset snapshot on resume)", api.pollIdx)
+	}
+	if len(checkpoints) != 0 {
+		t.Fatalf("checkpoints = %+v, want none before reply", checkpoints)
+	}
+}
+
+func TestRunPromptCheckpointsSentMessageAndOffset(t *testing.T) {
+	t.Parallel()
+
+	other := telegramapi.Update{UpdateID: 4}
+	other.Message.Chat.ID = 2002
+	other.Message.Text = "not ours"
+
+	api := &fakeAPI{polls: [][]telegramapi.Update{nil, {other}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var checkpoints []Checkpoint
+	_, err := RunPrompt(ctx, api, "1001", "A/B?", 50*time.Millisecond, PromptOptions{
+		OnCheckpoint: func(cp Checkpoint) error {
+			checkpoints = append(checkpoints, cp)
+			return nil
+		},
+	})
+	if !errors.Is(err, ErrSessionTimeout) {
+		t.Fatalf("RunPrompt() error = %v, want %v", err, ErrSessionTimeout)
+	}
+
+	want := []Checkpoint{{MessageID: 1, Offset: 0}, {MessageID: 1, Offset: 5}}
+	if len(checkpoints) != len(want) {
+		t.Fatalf("checkpoints = %+v, want %+v", checkpoints, want)
+	}
+	for i := range want {
+		if checkpoints[i] != want[i] {
+			t.Fatalf("checkpoints = %+v, want %+v", checkpoints, want)
+		}
+	}
+}
+
+func TestRunPromptReplyModeIgnoresStrayMessages(t *testing.T) {
+	t.Parallel()
+
+	stray := telegramapi.Update{UpdateID: 2}
+	stray.Message.MessageID = 10
+	stray.Message.Chat.ID = 1001
+	stray.Message.Text = "typed before reading"
+
+	otherReply := telegramapi.Update{UpdateID: 3}
+	otherReply.Message.MessageID = 11
+	otherReply.Message.Chat.ID = 1001
+	otherReply.Message.Text = "A"
+	otherReply.Message.ReplyToMessage = &telegramapi.Message{MessageID: 99}
+
+	reply := telegramapi.Update{UpdateID: 4}
+	reply.Message.MessageID = 12
+	reply.Message.Chat.ID = 1001
+	reply.Message.Text = "B"
+	reply.Message.ReplyToMessage = &telegramapi.Message{MessageID: 1}
+
+	api := &fakeAPI{polls: [][]telegramapi.Update{nil, {stray, otherReply, reply}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	result, err := RunPrompt(ctx, api, "1001", "A/B?", 2*time.Second, PromptOptions{Match: MatchReply})
+	if err != nil {
+		t.Fatalf("RunPrompt() error = %v", err)
+	}
+
+	if result.NormalizedReply != "B" {
+		t.Fatalf("result.NormalizedReply = %q, want B", result.NormalizedReply)
+	}
+	if result.PromptMessageID != 1 || result.ReplyMessageID != 12 {
+		t.Fatalf("message IDs = %d/%d, want 1/12", result.PromptMessageID, result.ReplyMessageID)
+	}
+}
+
+func TestRunPromptWrapsAPIError(t *testing.T) {
+	t.Parallel()
+
+	api := &fakeAPI{sendErr: &telegramapi.APIError{
+		Method:      "sendMessage",
+		StatusCode:  400,
+		ErrorCode:   400,
+		Description: "Bad Request: chat not found",
+	}}
+
+	_, err := RunPrompt(context.Background(), api, "1001", "A/B?", time.Second, PromptOptions{})
+	var apiErr *telegramapi.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("RunPrompt() error = %v, want *telegramapi.APIError", err)
+	}
+	if apiErr.Description != "Bad Request: chat not found" {
+		t.Fatalf("APIError.Description = %q", apiErr.Description)
+	}
+}
+
+func TestRunPromptSplitsLongPrompt(t *testing.T) {
+	t.Parallel()
+
+	reply := telegramapi.Update{UpdateID: 3}
+	reply.Message.Chat.ID = 1001
+	reply.Message.Text = "A"
+
+	api := &fakeAPI{polls: [][]telegramapi.Update{nil, {reply}}}
+	para := strings.Repeat("step ", 500)
+	prompt := strings.Join([]string{para, para, para, "Proceed? (A/B)"}, "\n\n")
+
+	result, err := RunPrompt(context.Background(), api, "1001", prompt, time.Second, PromptOptions{
+		Options:   []string{"A", "B"},
+		ParseMode: telegramformat.ParseModeMarkdownV2,
+	})
+	if err != nil {
+		t.Fatalf("RunPrompt() error = %v", err)
+	}
+
+	if len(api.sentText) < 2 {
+		t.Fatalf("sent %d messages, want the prompt split into chunks", len(api.sentText))
+	}
+	last := len(api.sentText) - 1
+	for i, text := range api.sentText {
+		if telegramformat.Length(text) > telegramformat.MaxMessageLength {
+			t.Fatalf("chunk %d length = %d", i, telegramformat.Length(text))
+		}
+		if api.sentOpts[i].ParseMode != "MarkdownV2" {
+			t.Fatalf("chunk %d parse mode = %q, want MarkdownV2", i, api.sentOpts[i].ParseMode)
+		}
+		if (api.sentOpts[i].ReplyMarkup != nil) != (i == last) {
+			t.Fatalf("chunk %d keyboard = %v, want keyboard only on the last chunk", i, api.sentOpts[i].ReplyMarkup)
+		}
+	}
+	if !strings.HasPrefix(api.sentText[0], `\(1/`) {
+		t.Fatalf("first chunk = %.20q, want chunk marker", api.sentText[0])
+	}
+	if result.PromptMessageID != int64(last+1) {
+		t.Fatalf("PromptMessageID = %d, want last chunk %d", result.PromptMessageID, last+1)
+	}
+}
+
+func TestRunPromptSendsAttachmentsBeforePrompt(t *testing.T) {
+	t.Parallel()
+
+	reply := telegramapi.Update{UpdateID: 3}
+	reply.Message.Chat.ID = 1001
+	reply.Message.Text = "ok"
+
+	api := &fakeAPI{polls: [][]telegramapi.Update{nil, {reply}}}
+	result, err := RunPrompt(context.Background(), api, "1001", "Approve the plan?", time.Second, PromptOptions{
+		Attachments: []Attachment{{File: telegramapi.InputFile{Name: "plan.md", Data: []byte("# Plan")}, Caption: "Plan"}},
+	})
+	if err != nil {
+		t.Fatalf("RunPrompt() error = %v", err)
+	}
+
+	if got := strings.Join(api.sentText, "|"); got != "[document] plan.md|Approve the plan?" {
+		t.Fatalf("sent = %q, want document then prompt", got)
+	}
+	if result.PromptMessageID != 2 {
+		t.Fatalf("PromptMessageID = %d, want 2", result.PromptMessageID)
+	}
+}
+
+func TestRunPromptIgnoresUnlistedSendersAndBots(t *testing.T) {
+	t.Parallel()
+
+	stranger := telegramapi.Update{UpdateID: 2}
+	stranger.Message.Chat.ID = -1001
+	stranger.Message.From = &telegramapi.User{ID: 7}
+	stranger.Message.Text = "A"
+
+	bot := telegramapi.Update{UpdateID: 3}
+	bot.Message.Chat.ID = -1001
+	bot.Message.From = &telegramapi.User{ID: 42, IsBot: true}
+	bot.Message.Text = "A"
+
+	strangerTap := telegramapi.Update{UpdateID: 4, CallbackQuery: &telegramapi.CallbackQuery{
+		ID:      "cb-stranger",
+		From:    telegramapi.User{ID: 7},
+		Message: &telegramapi.Message{MessageID: 1, Chat: telegramapi.Chat{ID: -1001}},
+		Data:    "opt:0",
+	}}
+
+	owner := telegramapi.Update{UpdateID: 5}
+	owner.Message.Chat.ID = -1001
+	owner.Message.From = &telegramapi.User{ID: 42, Username: "owner"}
+	owner.Message.Text = "B"
+
+	api := &fakeAPI{polls: [][]telegramapi.Update{nil, {stranger, bot, strangerTap, owner}}}
+	result, err := RunPrompt(context.Background(), api, "-1001", "A/B?", time.Second, PromptOptions{
+		Options:        []string{"A", "B"},
+		AllowedUserIDs: []int64{42},
+	})
+	if err != nil {
+		t.Fatalf("RunPrompt() error = %v", err)
+	}
+
+	if result.NormalizedReply != "B" || result.Sender.ID != 42 {
+		t.Fatalf("result = %+v, want B from 42", result)
+	}
+	if len(api.sentText) != 1 {
+		t.Fatalf("sent = %q, want no re-ask for ignored senders", api.sentText)
+	}
+	if len(api.answered) != 1 || api.answered[0] != "cb-stranger" {
+		t.Fatalf("answered = %v, want the stranger's tap dismissed", api.answered)
+	}
+}
+
+func TestRunPromptStaysInThread(t *testing.T) {
+	t.Parallel()
+
+	otherTopic := telegramapi.Update{UpdateID: 2}
+	otherTopic.Message.Chat.ID = -1001
+	otherTopic.Message.MessageThreadID = 8
+	otherTopic.Message.Text = "other repo"
+
+	sameTopic := telegramapi.Update{UpdateID: 3}
+	sameTopic.Message.Chat.ID = -1001
+	sameTopic.Message.MessageThreadID = 9
+	sameTopic.Message.Text = "zz"
+
+	answer := telegramapi.Update{UpdateID: 4}
+	answer.Message.Chat.ID = -1001
+	answer.Message.MessageThreadID = 9
+	answer.Message.Text = "B"
+
+	api := &fakeAPI{polls: [][]telegramapi.Update{nil, {otherTopic, sameTopic}, {answer}}}
+	result, err := RunPrompt(context.Background(), api, "-1001", "A/B?", time.Second, PromptOptions{
+		Options:  []string{"A", "B"},
+		ThreadID: 9,
+	})
+	if err != nil {
+		t.Fatalf("RunPrompt() error = %v", err)
+	}
+	if result.NormalizedReply != "B" {
+		t.Fatalf("result.NormalizedReply = %q, want B", result.NormalizedReply)
+	}
+
+	if len(api.sentOpts) != 2 {
+		t.Fatalf("sent = %q, want prompt and one re-ask", api.sentText)
+	}
+	for i, opts := range api.sentOpts {
+		if opts.MessageThreadID != 9 {
+			t.Fatalf("message %d thread = %d, want 9", i, opts.MessageThreadID)
+		}
+	}
+}
+
+func TestRunPromptHandsUnrelatedUpdatesToSpool(t *testing.T) {
+	t.Parallel()
+
+	pending := telegramapi.Update{UpdateID: 1}
+	pending.Message.Chat.ID = 1001
+	pending.Message.Text = "typed before the prompt"
+
+	otherChat := telegramapi.Update{UpdateID: 2}
+	otherChat.Message.Chat.ID = 2002
+	otherChat.Message.Text = "for another tool"
+
+	answer := telegramapi.Update{UpdateID: 3}
+	answer.Message.Chat.ID = 1001
+	answer.Message.Text = "yes"
+
+	// Updates after the answer are not acknowledged by this round, so they
+	// are left for the next one instead of being spooled.
+	after := telegramapi.Update{UpdateID: 4}
+	after.Message.Chat.ID = 1001
+	after.Message.Text = "next answer"
+
+	api := &fakeAPI{polls: [][]telegramapi.Update{{pending}, {otherChat, answer, after}}}
+
+	var spooled []int64
+	result, err := RunPrompt(context.Background(), api, "1001", "Q?", 2*time.Second, PromptOptions{
+		OnUnrelated: func(updates []telegramapi.Update) error {
+			for _, u := range updates {
+				spooled = append(spooled, u.UpdateID)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("RunPrompt() error = %v", err)
+	}
+	if result.NormalizedReply != "yes" {
+		t.Fatalf("NormalizedReply = %q, want yes", result.NormalizedReply)
+	}
+	if len(spooled) != 2 || spooled[0] != 1 || spooled[1] != 2 {
+		t.Fatalf("spooled = %v, want [1 2]", spooled)
+	}
+}
+
+func TestConversationHoldsMessagesSentBetweenRounds(t *testing.T) {
+	t.Parallel()
+
+	otherChat := telegramapi.Update{UpdateID: 3}
+	otherChat.Message.Chat.ID = 2002
+	otherChat.Message.Text = "for another tool"
+
+	// The user answers the second question before it is asked. Round one
+	// leaves that message unacknowledged; the second prompt's skipPending
+	// reads it again.
+	first, early := textUpdate(1, 10, "first answer"), textUpdate(2, 11, "second answer")
+	api := &fakeAPI{polls: [][]telegramapi.Update{nil, {first, early, otherChat}, {early, otherChat}}}
+
+	var spooled []int64
+	opts := PromptOptions{
+		OnUnrelated: func(updates []telegramapi.Update) error {
+			for _, u := range updates {
+				spooled = append(spooled, u.UpdateID)
+			}
+			return nil
+		},
+	}
+	conv, err := NewConversation(api, "1001")
+	if err != nil {
+		t.Fatalf("NewConversation() error = %v", err)
+	}
+	if result, err := conv.Ask(context.Background(), "Q1?", 2*time.Second, opts); err != nil || result.NormalizedReply != "first answer" {
+		t.Fatalf("first Ask() = %+v, %v", result, err)
+	}
+	result, err := conv.Ask(context.Background(), "Q2?", 2*time.Second, opts)
+	if err != nil {
+		t.Fatalf("second Ask() error = %v", err)
+	}
+	if result.NormalizedReply != "second answer" || result.ReplyMessageID != 11 {
+		t.Fatalf("second result = %+v, want the held message", result)
+	}
+	if len(api.offsets) != 3 {
+		t.Fatalf("offsets = %v, want no poll once the held message answered", api.offsets)
+	}
+	if len(spooled) != 1 || spooled[0] != 3 {
+		t.Fatalf("spooled = %v, want only the other chat's update", spooled)
+	}
+}
+
+func TestRunPromptStopsWhenSpoolFails(t *testing.T) {
+	t.Parallel()
+
+	otherChat := telegramapi.Update{UpdateID: 2}
+	otherChat.Message.Chat.ID = 2002
+	otherChat.Message.Text = "for another tool"
+
+	api := &fakeAPI{polls: [][]telegramapi.Update{nil, {otherChat}}}
+	spoolErr := errors.New("disk full")
+
+	_, err := RunPrompt(context.Background(), api, "1001", "Q?", 2*time.Second, PromptOptions{
+		OnUnrelated: func([]telegramapi.Update) error { return spoolErr },
+	})
+	if !errors.Is(err, spoolErr) {
+		t.Fatalf("RunPrompt() error = %v, want spool error", err)
+	}
+	// The failed batch must not be acknowledged by another poll.
+	if len(api.offsets) != 2 {
+		t.Fatalf("offsets = %v, want no poll after the spool failure", api.offsets)
+	}
+}
+
+func voiceUpdate(id int64, fileID string) telegramapi.Update {
+	u := telegramapi.Update{UpdateID: id}
+	u.Message.MessageID = 100 + id
+	u.Message.Chat.ID = 1001
+	u.Message.Voice = &telegramapi.Voice{FileID: fileID, Duration: 3, MimeType: "audio/ogg"}
+	return u
+}
+
+func TestRunPromptTranscribesVoiceReply(t *testing.T) {
+	t.Parallel()
+
+	api := &fakeAPI{
+		polls: [][]telegramapi.Update{nil, {voiceUpdate(2, "v1")}},
+		files: map[string][]byte{"v1": []byte("OggS")},
+	}
+	stt := &transcribe.Fake{Transcript: " Beta "}
+
+	result, err := RunPrompt(context.Background(), api, "1001", "Pick one", 2*time.Second, PromptOptions{
+		Options:     []string{"Alpha", "Beta"},
+		Transcriber: stt,
+	})
+	if err != nil {
+		t.Fatalf("RunPrompt() error = %v", err)
+	}
+	if !result.Transcribed || result.NormalizedReply != "Beta" || result.ReplyMessageID != 102 {
+		t.Fatalf("result = %+v, want transcribed voice reply", result)
+	}
+	inputs := stt.Inputs()
+	if len(inputs) != 1 || string(inputs[0].Data) != "OggS" || inputs[0].MimeType != "audio/ogg" {
+		t.Fatalf("transcriber inputs = %+v", inputs)
+	}
+}
+
+func TestRunPromptVoiceNoticesKeepRoundOpen(t *testing.T) {
+	t.Parallel()
+
+	text := telegramapi.Update{UpdateID: 4}
+	text.Message.Chat.ID = 1001
+	text.Message.Text = "typed instead"
+
+	api := &fakeAPI{
+		polls: [][]telegramapi.Update{nil, {voiceUpdate(2, "v1")}, {voiceUpdate(3, "v2")}, {text}},
+		files: map[string][]byte{"v1": []byte("a"), "v2": []byte("b")},
+	}
+	stt := &transcribe.Fake{Err: errors.New("model missing")}
+
+	result, err := RunPrompt(context.Background(), api, "1001", "Q?", 2*time.Second, PromptOptions{Transcriber: stt})
+	if err != nil {
+		t.Fatalf("RunPrompt() error = %v", err)
+	}
+	if result.Transcribed || result.RawReply != "typed instead" {
+		t.Fatalf("result = %+v, want the typed reply", result)
+	}
+	if len(api.sentText) != 3 || api.sentText[1] != voiceFailedNotice || api.sentOpts[1].ReplyToMessageID != 102 {
+		t.Fatalf("sent = %q (%+v), want a failure notice per voice reply", api.sentText, api.sentOpts)
+	}
+
+	noSTT := &fakeAPI{polls: [][]telegramapi.Update{nil, {voiceUpdate(2, "v1")}, {text}}}
+	if _, err := RunPrompt(context.Background(), noSTT, "1001", "Q?", 2*time.Second, PromptOptions{}); err != nil {
+		t.Fatalf("RunPrompt() without transcriber error = %v", err)
+	}
+	if len(noSTT.sentText) != 2 || noSTT.sentText[1] != voiceUnsupportedNotice {
+		t.Fatalf("sent = %q, want unsupported notice", noSTT.sentText)
+	}
+}
+
+func TestRunPromptSavesPhotoAndDocumentReplies(t *testing.T) {
+	t.Parallel()
+
+	photo := telegramapi.Update{UpdateID: 2}
+	photo.Message.MessageID = 50
+	photo.Message.Chat.ID = 1001
+	photo.Message.Caption = " sketch of option B "
+	photo.Message.Photo = []telegramapi.PhotoSize{
+		{FileID: "small", Width: 90, Height: 60},
+		{FileID: "big", Width: 1280, Height: 853},
+	}
+
+	doc := telegramapi.Update{UpdateID: 3}
+	doc.Message.MessageID = 51
+	doc.Message.Chat.ID = 1001
+	doc.Message.Document = &telegramapi.Document{FileID: "doc", FileName: "../../etc/notes.txt", MimeType: "text/plain"}
+
+	dir := t.TempDir()
+	api := &fakeAPI{
+		polls: [][]telegramapi.Update{nil, {photo}, nil, {doc}},
+		files: map[string][]byte{"small": []byte("s"), "big": []byte("JPEG"), "doc": []byte("notes")},
+	}
+
+	// A photo answers even a prompt with options.
+	result, err := RunPrompt(context.Background(), api, "1001", "Pick", 2*time.Second, PromptOptions{Options: []string{"A", "B"}, AttachmentDir: dir})
+	if err != nil {
+		t.Fatalf("RunPrompt() error = %v", err)
+	}
+	if result.NormalizedReply != "sketch of option B" || len(result.Files) != 1 {
+		t.Fatalf("result = %+v, want captioned photo", result)
+	}
+	if got, err := os.ReadFile(result.Files[0].Path); err != nil || string(got) != "JPEG" {
+		t.Fatalf("saved photo = %q, %v; want the largest size", got, err)
+	}
+
+	result, err = RunPrompt(context.Background(), api, "1001", "Notes?", 2*time.Second, PromptOptions{AttachmentDir: dir})
+	if err != nil {
+		t.Fatalf("RunPrompt() error = %v", err)
+	}
+	saved := result.Files[0]
+	if filepath.Dir(saved.Path) != dir || saved.FileName != "notes.txt" || saved.MimeType != "text/plain" || saved.Size != 5 {
+		t.Fatalf("saved document = %+v, want notes.txt inside %s", saved, dir)
+	}
+}
+
+func TestRunPromptFileReplyWithoutDirectoryGetsNotice(t *testing.T) {
+	t.Parallel()
+
+	photo := telegramapi.Update{UpdateID: 2}
+	photo.Message.Chat.ID = 1001
+	photo.Message.Photo = []telegramapi.PhotoSize{{FileID: "p", Width: 10, Height: 10}}
+
+	text := telegramapi.Update{UpdateID: 3}
+	text.Message.Chat.ID = 1001
+	text.Message.Text = "in words then"
+
+	api := &fakeAPI{polls: [][]telegramapi.Update{nil, {photo, text}}}
+	result, err := RunPrompt(context.Background(), api, "1001", "Q?", 2*time.Second, PromptOptions{})
+	if err != nil {
+		t.Fatalf("RunPrompt() error = %v", err)
+	}
+	if result.RawReply != "in words then" || len(api.sentText) != 2 || api.sentText[1] != filesUnsupportedNotice {
+		t.Fatalf("result = %+v, sent = %q", result, api.sentText)
+	}
+}
+
+func textUpdate(id int64, messageID int64, text string) telegramapi.Update {
+	u := telegramapi.Update{UpdateID: id}
+	u.Message.MessageID = messageID
+	u.Message.Chat.ID = 1001
+	u.Message.Text = text
+	return u
+}
+
+func TestRunPromptGraceWindowAppliesEdit(t *testing.T) {
+	t.Parallel()
+
+	edit := telegramapi.Update{UpdateID: 3}
+	edit.EditedMessage = &telegramapi.Message{MessageID: 20, Chat: telegramapi.Chat{ID: 1001}, Text: "2"}
+
+	api := &fakeAPI{polls: [][]telegramapi.Update{nil, {textUpdate(2, 20, "1")}, {edit}}}
+	result, err := RunPrompt(context.Background(), api, "1001", "Pick", 2*time.Second, PromptOptions{
+		Options:     []string{"Alpha", "Beta"},
+		GraceWindow: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("RunPrompt() error = %v", err)
+	}
+	if result.NormalizedReply != "Beta" || !result.Corrected || result.ReplyMessageID != 20 {
+		t.Fatalf("result = %+v, want the edited answer", result)
+	}
+}
+
+func TestRunPromptUndoDiscardsAnswer(t *testing.T) {
+	t.Parallel()
+
+	api := &fakeAPI{polls: [][]telegramapi.Update{
+		nil,
+		{textUpdate(2, 20, "ship it"), textUpdate(3, 21, "/undo")},
+		{textUpdate(4, 22, "wait for review")},
+	}}
+	result, err := RunPrompt(context.Background(), api, "1001", "Q?", 2*time.Second, PromptOptions{GraceWindow: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("RunPrompt() error = %v", err)
+	}
+	if result.NormalizedReply != "wait for review" || !result.Corrected {
+		t.Fatalf("result = %+v, want the answer sent after /undo", result)
+	}
+	if len(api.sentText) != 2 || api.sentText[1] != undoneNotice {
+		t.Fatalf("sent = %q, want undo notice", api.sentText)
+	}
+}
+
+func TestRunPromptNewMessageEndsGraceWindow(t *testing.T) {
+	t.Parallel()
+
+	api := &fakeAPI{polls: [][]telegramapi.Update{
+		nil,
+		{textUpdate(2, 20, "A"), textUpdate(3, 21, "meant for the next question")},
+	}}
+	conv, err := NewConversation(api, "1001")
+	if err != nil {
+		t.Fatalf("NewConversation() error = %v", err)
+	}
+	result, err := conv.Ask(context.Background(), "Q?", 2*time.Second, PromptOptions{GraceWindow: time.Minute})
+	if err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	if result.NormalizedReply != "A" || result.Corrected {
+		t.Fatalf("result = %+v, want the first answer", result)
+	}
+	if conv.offset != 3 {
+		t.Fatalf("offset = %d, want 3 so the next message stays unacknowledged", conv.offset)
+	}
+}
+
+func TestRunPromptUndoWithoutAnswer(t *testing.T) {
+	t.Parallel()
+
+	api := &fakeAPI{polls: [][]telegramapi.Update{nil, {textUpdate(2, 20, "/undo@brainstorm_bot"), textUpdate(3, 21, "B")}}}
+	result, err := RunPrompt(context.Background(), api, "1001", "Q?", 2*time.Second, PromptOptions{})
+	if err != nil {
+		t.Fatalf("RunPrompt() error = %v", err)
+	}
+	if result.NormalizedReply != "B" || api.sentText[1] != nothingToUndoNotice {
+		t.Fatalf("result = %+v, sent = %q", result, api.sentText)
+	}
+}
+
+func TestRunPromptRejectsUnknownMatchMode(t *testing.T) {
+	t.Parallel()
+
+	_, err := RunPrompt(context.Background(), &fakeAPI{}, "1001", "A/B?", time.Second, PromptOptions{Match: "first"})
+	if err == nil {
+		t.Fatal("RunPrompt() error = nil, want unknown match mode")
+	}
+}
+
+func TestConversationKeepsOffsetAcrossRounds(t *testing.T) {
+	t.Parallel()
+
+	first := telegramapi.Update{UpdateID: 5}
+	first.Message.Chat.ID = 1001
+	first.Message.Text = "A"
+
+	second := telegramapi.Update{UpdateID: 6}
+	second.Message.Chat.ID = 1001
+	second.Message.Text = "2"
+
+	api := &fakeAPI{polls: [][]telegramapi.Update{nil, {first}, nil, {second}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conv, err := NewConversation(api, "1001")
+	if err != nil {
+		t.Fatalf("NewConversation() error = %v", err)
+	}
+
+	r1, err := conv.Ask(ctx, "A/B?", time.Second, PromptOptions{})
+	if err != nil {
+		t.Fatalf("Ask() #1 error = %v", err)
+	}
+	r2, err := conv.Ask(ctx, "1/2?", time.Second, PromptOptions{})
+	if err != nil {
+		t.Fatalf("Ask() #2 error = %v", err)
+	}
+
+	if r1.NormalizedReply != "A" || r2.NormalizedReply != "2" {
+		t.Fatalf("replies = %q, %q", r1.NormalizedReply, r2.NormalizedReply)
+	}
+	want := []int64{0, 0, 6, 6}
+	if len(api.offsets) != len(want) {
+		t.Fatalf("offsets = %v, want %v", api.offsets, want)
+	}
+	for i := range want {
+		if api.offsets[i] != want[i] {
+			t.Fatalf("offsets = %v, want %v", api.offsets, want)
+		}
+	}
+}
+
+func TestRunPromptParsesTypedChoiceAndReasksOnGarbage(t *testing.T) {
+	t.Parallel()
+
+	garbage := telegramapi.Update{UpdateID: 2}
+	garbage.Message.MessageID = 20
+	garbage.Message.Chat.ID = 1001
+	garbage.Message.Text = "嗯……再想想"
+
+	multi := telegramapi.Update{UpdateID: 3}
+	multi.Message.MessageID = 21
+	multi.Message.Chat.ID = 1001
+	multi.Message.Text = "1,2"
+
+	valid := telegramapi.Update{UpdateID: 4}
+	valid.Message.MessageID = 22
+	valid.Message.Chat.ID = 1001
+	valid.Message.Text = "选b"
+
+	api := &fakeAPI{polls: [][]telegramapi.Update{nil, {garbage}, {multi, valid}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	result, err := RunPrompt(ctx, api, "1001", "请选择方案", 2*time.Second, PromptOptions{
+		Options: []string{"A) 低风险", "B) 平衡"},
+	})
+	if err != nil {
+		t.Fatalf("RunPrompt() error = %v", err)
+	}
+
+	if result.RawReply != "选b" || result.NormalizedReply != "B) 平衡" {
+		t.Fatalf("result = %+v", result)
+	}
+	if len(result.Choices) != 1 || result.Choices[0] != "B) 平衡" {
+		t.Fatalf("result.Choices = %q", result.Choices)
+	}
+	if len(api.sentText) != 3 {
+		t.Fatalf("sent = %q, want prompt plus two re-asks", api.sentText)
+	}
+	if got := api.sentOpts[1].ReplyToMessageID; got != 20 {
+		t.Fatalf("re-ask reply_to = %d, want 20", got)
+	}
+}
+
+func TestRunPromptMultiSelectAndFreeText(t *testing.T) {
+	t.Parallel()
+
+	multi := telegramapi.Update{UpdateID: 2}
+	multi.Message.Chat.ID = 1001
+	multi.Message.Text = "A 和 C"
+
+	api := &fakeAPI{polls: [][]telegramapi.Update{nil, {multi}}}
+	result, err := RunPrompt(context.Background(), api, "1001", "可多选", time.Second, PromptOptions{
+		Options:     []string{"日志", "指标", "告警"},
+		MultiSelect: true,
+	})
+	if err != nil {
+		t.Fatalf("RunPrompt() multi error = %v", err)
+	}
+	if got := result.NormalizedReply; got != "日志, 告警" {
+		t.Fatalf("multi NormalizedReply = %q", got)
+	}
+
+	free := telegramapi.Update{UpdateID: 2}
+	free.Message.Chat.ID = 1001
+	free.Message.Text = "都不要，改成邮件通知"
+
+	api = &fakeAPI{polls: [][]telegramapi.Update{nil, {free}}}
+	result, err = RunPrompt(context.Background(), api, "1001", "选一个", time.Second, PromptOptions{
+		Options:       []string{"日志", "指标"},
+		AllowFreeText: true,
+	})
+	if err != nil {
+		t.Fatalf("RunPrompt() free text error = %v", err)
+	}
+	if result.NormalizedReply != "都不要，改成邮件通知" || len(result.Choices) != 0 {
+		t.Fatalf("free text result = %+v", result)
+	}
+	if len(api.sentText) != 1 {
+		t.Fatalf("sent = %q, want no re-ask", api.sentText)
+	}
 }
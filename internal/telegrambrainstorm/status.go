@@ -0,0 +1,112 @@
+package telegrambrainstorm
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"codex-brainstorming-telegram/internal/telegramapi"
+	"codex-brainstorming-telegram/internal/telegramformat"
+)
+
+const (
+	reminderNotice = "⏰ 提醒：上面的问题还在等待你的回复，约 %s后超时。"
+	answeredStatus = "✅ 已回答：%s"
+	expiredStatus  = "⌛ 已过期：等待回复超时，这个问题不再接受回答。"
+	// escalatedStatus marks a prompt handed on to the next chat.
+	escalatedStatus = "⏭️ 已转交：等待回复超时，问题已发给下一位，这里不再接受回答。"
+)
+
+// maxStatusAnswer caps how much of a free-text answer is repeated under the
+// prompt; the full reply is still in the chat right below it.
+const maxStatusAnswer = 200
+
+// reminderSchedule keeps the reminders that fall inside the session timeout,
+// sorted and without duplicates.
+func reminderSchedule(reminders []time.Duration, sessionTimeout time.Duration) []time.Duration {
+	schedule := make([]time.Duration, 0, len(reminders))
+	for _, d := range reminders {
+		if d > 0 && d < sessionTimeout {
+			schedule = append(schedule, d)
+		}
+	}
+	sort.Slice(schedule, func(i, j int) bool { return schedule[i] < schedule[j] })
+
+	out := schedule[:0]
+	for i, d := range schedule {
+		if i == 0 || d != schedule[i-1] {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// sendReminder nudges the chat with a reply to the prompt. It is best
+// effort: a lost reminder must not end a round that can still be answered.
+func (c *Conversation) sendReminder(ctx context.Context, r round, remaining time.Duration) {
+	_, _ = c.api.SendMessageWithOptions(ctx, c.chatID, fmt.Sprintf(reminderNotice, formatRemaining(remaining)), telegramapi.SendOptions{
+		ReplyToMessageID: r.promptMessageID,
+		MessageThreadID:  r.opts.ThreadID,
+	})
+}
+
+// markStatus appends status to the prompt's last chunk, which also drops its
+// keyboard. When the status does not fit in one message or the edit fails,
+// only the keyboard is removed so stale buttons cannot be tapped. Like
+// reminders this is best effort.
+func (c *Conversation) markStatus(ctx context.Context, r round, status string) {
+	if ctx.Err() != nil || r.promptText == "" {
+		return
+	}
+	text := r.promptText + "\n\n" + telegramformat.Escape(status, r.opts.ParseMode)
+	if telegramformat.Length(text) <= telegramformat.MaxMessageLength {
+		err := c.api.EditMessageText(ctx, c.chatID, r.promptMessageID, text, telegramapi.EditOptions{
+			ParseMode: string(r.opts.ParseMode),
+		})
+		if err == nil {
+			return
+		}
+	}
+	if len(r.options) > 0 {
+		_ = c.api.EditMessageReplyMarkup(ctx, c.chatID, r.promptMessageID, nil)
+	}
+}
+
+// answeredStatusFor describes the recorded answer: the choice, the reply
+// text, or the names of the files sent.
+func answeredStatusFor(result PromptResult) string {
+	answer := result.NormalizedReply
+	if answer == "" {
+		names := make([]string, 0, len(result.Files))
+		for _, f := range result.Files {
+			names = append(names, f.FileName)
+		}
+		answer = strings.Join(names, ", ")
+	}
+	answer = strings.Join(strings.Fields(answer), " ")
+	if runes := []rune(answer); len(runes) > maxStatusAnswer {
+		answer = string(runes[:maxStatusAnswer]) + "…"
+	}
+	return fmt.Sprintf(answeredStatus, answer)
+}
+
+// lastChunk is the text of the message sendPrompt attaches the keyboard to.
+func lastChunk(prompt string, mode telegramformat.ParseMode) string {
+	chunks := telegramformat.Render(prompt, mode, telegramformat.MaxMessageLength)
+	if len(chunks) == 0 {
+		return ""
+	}
+	return chunks[len(chunks)-1]
+}
+
+// formatRemaining rounds up to whole minutes, or whole seconds under a
+// minute, since reminders are read by people rather than parsed.
+func formatRemaining(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%d 秒", int(math.Max(1, math.Ceil(d.Seconds()))))
+	}
+	return fmt.Sprintf("%d 分钟", int(math.Ceil(d.Minutes())))
+}
@@ -0,0 +1,62 @@
+package telegrambrainstorm
+
+import (
+	"context"
+
+	"codex-brainstorming-telegram/internal/telegramapi"
+	"codex-brainstorming-telegram/internal/transcribe"
+)
+
+const (
+	voiceUnsupportedNotice = "暂不支持语音回复，请用文字回答。"
+	voiceFailedNotice      = "语音转写失败，请重新发送或改用文字回答。"
+	voiceTooLargeNotice    = "语音文件过大，无法下载，请发送更短的语音或改用文字回答。"
+)
+
+func hasVoice(msg telegramapi.Message) bool {
+	return msg.Voice != nil || msg.Audio != nil
+}
+
+// transcribeReply downloads a voice or audio reply and transcribes it. When
+// it cannot produce text it returns a notice to send back instead, so a
+// failed transcription asks for a retry rather than ending the round.
+func (c *Conversation) transcribeReply(ctx context.Context, msg telegramapi.Message, t transcribe.Transcriber) (string, string) {
+	if t == nil {
+		return "", voiceUnsupportedNotice
+	}
+
+	in := transcribe.Input{}
+	var fileID string
+	var size int64
+	if msg.Voice != nil {
+		fileID, size = msg.Voice.FileID, msg.Voice.FileSize
+		in.MimeType = msg.Voice.MimeType
+	} else {
+		fileID, size = msg.Audio.FileID, msg.Audio.FileSize
+		in.MimeType, in.FileName = msg.Audio.MimeType, msg.Audio.FileName
+	}
+	if size > telegramapi.MaxDownloadSize {
+		return "", voiceTooLargeNotice
+	}
+
+	file, err := c.api.GetFile(ctx, fileID)
+	if err != nil {
+		return "", voiceFailedNotice
+	}
+	if file.FileSize > telegramapi.MaxDownloadSize {
+		return "", voiceTooLargeNotice
+	}
+	if in.FileName == "" {
+		in.FileName = file.FilePath
+	}
+	in.Data, err = c.api.DownloadFile(ctx, file.FilePath)
+	if err != nil {
+		return "", voiceFailedNotice
+	}
+
+	text, err := t.Transcribe(ctx, in)
+	if err != nil || normalizeReply(text) == "" {
+		return "", voiceFailedNotice
+	}
+	return normalizeReply(text), ""
+}
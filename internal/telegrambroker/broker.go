@@ -0,0 +1,222 @@
+// Package telegrambroker lets several local processes share one bot token.
+// A Broker is the only getUpdates consumer; it keeps recent updates in
+// memory and serves them to every client over a Unix socket with per-client
+// offsets, so one session acknowledging an update never hides it from
+// another.
+package telegrambroker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"codex-brainstorming-telegram/internal/telegramapi"
+)
+
+const (
+	// DefaultRetention is how long updates stay available to clients.
+	DefaultRetention = 30 * time.Minute
+	// DefaultMaxUpdates caps the buffer regardless of age.
+	DefaultMaxUpdates = 5000
+
+	upstreamPollTimeout  = 25
+	maxClientPollTimeout = 50
+)
+
+type upstream interface {
+	GetUpdates(ctx context.Context, offset int64, timeoutSec int) ([]telegramapi.Update, error)
+}
+
+type Broker struct {
+	upstream   upstream
+	retention  time.Duration
+	maxUpdates int
+	now        func() time.Time
+
+	// OnError is called for upstream polling failures before the broker
+	// backs off and tries again. It may be nil.
+	OnError func(error)
+
+	mu      sync.Mutex
+	changed chan struct{}
+	updates []bufferedUpdate
+	offset  int64
+}
+
+type bufferedUpdate struct {
+	update     telegramapi.Update
+	receivedAt time.Time
+}
+
+func New(up upstream) *Broker {
+	return &Broker{
+		upstream:   up,
+		retention:  DefaultRetention,
+		maxUpdates: DefaultMaxUpdates,
+		now:        time.Now,
+		changed:    make(chan struct{}),
+	}
+}
+
+// Run polls upstream until ctx is cancelled. Unauthorized errors stop the
+// broker because retrying cannot fix a revoked token.
+func (b *Broker) Run(ctx context.Context) error {
+	backoff := time.Second
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil
+		}
+
+		b.mu.Lock()
+		offset := b.offset
+		b.mu.Unlock()
+
+		updates, err := b.upstream.GetUpdates(ctx, offset, upstreamPollTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, telegramapi.ErrUnauthorized) {
+				return err
+			}
+			if b.OnError != nil {
+				b.OnError(err)
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, 30*time.Second)
+			continue
+		}
+		backoff = time.Second
+		b.add(updates)
+	}
+}
+
+func (b *Broker) add(updates []telegramapi.Update) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	added := false
+	for _, u := range updates {
+		if u.UpdateID < b.offset {
+			continue
+		}
+		b.offset = u.UpdateID + 1
+		b.updates = append(b.updates, bufferedUpdate{update: u, receivedAt: now})
+		added = true
+	}
+	b.pruneLocked(now)
+
+	if added {
+		close(b.changed)
+		b.changed = make(chan struct{})
+	}
+}
+
+func (b *Broker) pruneLocked(now time.Time) {
+	drop := 0
+	for drop < len(b.updates) && now.Sub(b.updates[drop].receivedAt) > b.retention {
+		drop++
+	}
+	if extra := len(b.updates) - drop - b.maxUpdates; extra > 0 {
+		drop += extra
+	}
+	if drop > 0 {
+		b.updates = append([]bufferedUpdate(nil), b.updates[drop:]...)
+	}
+}
+
+// GetUpdates returns buffered updates with IDs >= offset, waiting up to
+// timeoutSec for new ones. Unlike Telegram it never discards updates below
+// offset, since other clients may still need them.
+func (b *Broker) GetUpdates(ctx context.Context, offset int64, timeoutSec int) ([]telegramapi.Update, error) {
+	timer := time.NewTimer(time.Duration(timeoutSec) * time.Second)
+	defer timer.Stop()
+
+	for {
+		b.mu.Lock()
+		b.pruneLocked(b.now())
+		var pending []telegramapi.Update
+		for _, bu := range b.updates {
+			if bu.update.UpdateID >= offset {
+				pending = append(pending, bu.update)
+			}
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		if len(pending) > 0 || timeoutSec <= 0 {
+			return pending, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			return nil, nil
+		case <-changed:
+		}
+	}
+}
+
+// ServeHTTP answers GET /getUpdates?offset=&timeout= with a JSON array of
+// updates.
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/getUpdates" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	offset, _ := strconv.ParseInt(q.Get("offset"), 10, 64)
+	timeoutSec, _ := strconv.Atoi(q.Get("timeout"))
+	timeoutSec = min(max(timeoutSec, 0), maxClientPollTimeout)
+
+	updates, err := b.GetUpdates(r.Context(), offset, timeoutSec)
+	if err != nil {
+		return
+	}
+	if updates == nil {
+		updates = []telegramapi.Update{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(updates)
+}
+
+// Listen creates the Unix socket at path, readable only by the current user.
+// A stale socket left by a crashed broker is replaced; a live one is an
+// error.
+func Listen(path string) (net.Listener, error) {
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return nil, errors.New("another broker is already listening on " + path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
@@ -0,0 +1,166 @@
+package telegrambroker
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"codex-brainstorming-telegram/internal/telegramapi"
+)
+
+type scriptedUpstream struct {
+	mu      sync.Mutex
+	batches [][]telegramapi.Update
+	offsets []int64
+}
+
+func (u *scriptedUpstream) GetUpdates(ctx context.Context, offset int64, _ int) ([]telegramapi.Update, error) {
+	u.mu.Lock()
+	u.offsets = append(u.offsets, offset)
+	if len(u.batches) > 0 {
+		batch := u.batches[0]
+		u.batches = u.batches[1:]
+		u.mu.Unlock()
+		return batch, nil
+	}
+	u.mu.Unlock()
+
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func textUpdate(id int64, text string) telegramapi.Update {
+	u := telegramapi.Update{UpdateID: id}
+	u.Message.Chat.ID = 123
+	u.Message.Text = text
+	return u
+}
+
+func serveBroker(t *testing.T, b *Broker) string {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "tgb")
+	if err != nil {
+		t.Fatalf("MkdirTemp() error = %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	socket := filepath.Join(dir, "broker.sock")
+	ln, err := Listen(socket)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	srv := &http.Server{Handler: b}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { srv.Close() })
+	return socket
+}
+
+func TestBrokerFansOutToEveryClient(t *testing.T) {
+	t.Parallel()
+
+	up := &scriptedUpstream{batches: [][]telegramapi.Update{
+		{textUpdate(10, "first")},
+		{textUpdate(11, "second")},
+	}}
+	b := New(up)
+	socket := serveBroker(t, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() { _ = b.Run(ctx) }()
+
+	alice := NewClient(socket)
+	bob := NewClient(socket)
+	if !alice.Available() {
+		t.Fatal("Available() = false, want true")
+	}
+
+	var got []int64
+	offset := int64(0)
+	for len(got) < 2 {
+		updates, err := alice.GetUpdates(ctx, offset, 2)
+		if err != nil {
+			t.Fatalf("alice GetUpdates() error = %v", err)
+		}
+		for _, u := range updates {
+			got = append(got, u.UpdateID)
+			offset = u.UpdateID + 1
+		}
+	}
+	if got[0] != 10 || got[1] != 11 {
+		t.Fatalf("alice got %v, want [10 11]", got)
+	}
+
+	// Alice acknowledging both updates must not hide them from Bob.
+	updates, err := bob.GetUpdates(ctx, 11, 0)
+	if err != nil {
+		t.Fatalf("bob GetUpdates() error = %v", err)
+	}
+	if len(updates) != 1 || updates[0].Message.Text != "second" {
+		t.Fatalf("bob got %+v, want update 11", updates)
+	}
+
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	if up.offsets[0] != 0 || up.offsets[1] != 11 {
+		t.Fatalf("upstream offsets = %v, want broker to confirm 11 after the first batch", up.offsets)
+	}
+}
+
+func TestBrokerLongPollWakesOnNewUpdate(t *testing.T) {
+	t.Parallel()
+
+	b := New(&scriptedUpstream{})
+	done := make(chan []telegramapi.Update, 1)
+	go func() {
+		updates, _ := b.GetUpdates(context.Background(), 5, 5)
+		done <- updates
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	b.add([]telegramapi.Update{textUpdate(5, "late")})
+
+	select {
+	case updates := <-done:
+		if len(updates) != 1 || updates[0].UpdateID != 5 {
+			t.Fatalf("GetUpdates() = %+v, want update 5", updates)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("GetUpdates() did not wake up on a new update")
+	}
+}
+
+func TestBrokerPrunesOldUpdates(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := New(&scriptedUpstream{})
+	b.now = func() time.Time { return now }
+	b.maxUpdates = 2
+
+	b.add([]telegramapi.Update{textUpdate(1, "a")})
+	now = now.Add(DefaultRetention + time.Second)
+	b.add([]telegramapi.Update{textUpdate(2, "b"), textUpdate(3, "c"), textUpdate(4, "d")})
+
+	updates, err := b.GetUpdates(context.Background(), 0, 0)
+	if err != nil {
+		t.Fatalf("GetUpdates() error = %v", err)
+	}
+	if len(updates) != 2 || updates[0].UpdateID != 3 || updates[1].UpdateID != 4 {
+		t.Fatalf("GetUpdates() = %+v, want updates 3 and 4", updates)
+	}
+}
+
+func TestListenRefusesLiveSocket(t *testing.T) {
+	t.Parallel()
+
+	socket := serveBroker(t, New(&scriptedUpstream{}))
+	if _, err := Listen(socket); err == nil {
+		t.Fatal("Listen() on a live socket error = nil, want error")
+	}
+}
@@ -0,0 +1,83 @@
+package telegrambroker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"codex-brainstorming-telegram/internal/telegramapi"
+)
+
+// Client reads updates from a broker. It implements the GetUpdates half of
+// the session API, so it can replace telegramapi.Client.GetUpdates.
+type Client struct {
+	socket     string
+	httpClient *http.Client
+}
+
+func NewClient(socket string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &Client{socket: socket, httpClient: &http.Client{Transport: transport}}
+}
+
+// DefaultSocketPath is derived from the bot token so every process using the
+// same bot finds the same broker without configuration.
+func DefaultSocketPath(botToken string) string {
+	sum := sha256.Sum256([]byte(botToken))
+	name := fmt.Sprintf("telegram-brainstorming-%d-%s.sock", os.Getuid(), hex.EncodeToString(sum[:6]))
+	return filepath.Join(os.TempDir(), name)
+}
+
+// Available reports whether a broker is listening on the socket.
+func (c *Client) Available() bool {
+	conn, err := net.DialTimeout("unix", c.socket, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func (c *Client) GetUpdates(ctx context.Context, offset int64, timeoutSec int) ([]telegramapi.Update, error) {
+	q := url.Values{}
+	q.Set("offset", fmt.Sprintf("%d", offset))
+	q.Set("timeout", fmt.Sprintf("%d", timeoutSec))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://broker/getUpdates?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("broker getUpdates: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read broker response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("broker getUpdates: status %d", resp.StatusCode)
+	}
+
+	var updates []telegramapi.Update
+	if err := json.Unmarshal(body, &updates); err != nil {
+		return nil, fmt.Errorf("decode broker response: %w", err)
+	}
+	return updates, nil
+}
@@ -0,0 +1,814 @@
+package telegramfake
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"codex-brainstorming-telegram/internal/telegramapi"
+	"codex-brainstorming-telegram/internal/telegramformat"
+)
+
+const maxPollTimeout = 50 * time.Second
+
+// Server is an in-memory stand-in for the Telegram Bot API. Bot methods are
+// served under /bot<token>/<method>; /control/* endpoints let tests act as
+// the human on the other side of the chat.
+type Server struct {
+	token string
+
+	mu            sync.Mutex
+	changed       chan struct{}
+	nextUpdateID  int64
+	nextMessageID int64
+	nextCallback  int64
+	updates       []wireUpdate
+	sent          []SentMessage
+	answers       []CallbackAnswer
+	nextFile      int64
+	files         map[string]storedFile
+	webhookURL    string
+	webhookSecret string
+	webhookClient *http.Client
+	webhookQueue  chan webhookDelivery
+	webhookOnce   sync.Once
+	migrated      map[int64]int64
+}
+
+type webhookDelivery struct {
+	url    string
+	secret string
+	body   []byte
+}
+
+type SentMessage struct {
+	MessageID   int64                             `json:"message_id"`
+	ChatID      int64                             `json:"chat_id"`
+	Text        string                            `json:"text"`
+	ReplyMarkup *telegramapi.InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+	ParseMode   string                            `json:"parse_mode,omitempty"`
+	ThreadID    int64                             `json:"message_thread_id,omitempty"`
+	Document    *SentDocument                     `json:"document,omitempty"`
+	Edited      bool                              `json:"edited,omitempty"`
+	Pinned      bool                              `json:"pinned,omitempty"`
+}
+
+// SentDocument is a file uploaded with sendDocument; the message Text holds
+// its caption.
+type SentDocument struct {
+	FileName string `json:"file_name"`
+	Data     []byte `json:"data"`
+}
+
+type UserMessage struct {
+	ChatID           int64      `json:"chat_id"`
+	Text             string     `json:"text"`
+	ReplyToMessageID int64      `json:"reply_to_message_id,omitempty"`
+	FromID           int64      `json:"from_id,omitempty"`
+	Username         string     `json:"username,omitempty"`
+	IsBot            bool       `json:"is_bot,omitempty"`
+	ThreadID         int64      `json:"message_thread_id,omitempty"`
+	Voice            *UserVoice `json:"voice,omitempty"`
+	// Photo is the image data of a photo reply; Document attaches a file.
+	// Caption goes with either.
+	Photo    []byte        `json:"photo,omitempty"`
+	Document *UserDocument `json:"document,omitempty"`
+	Caption  string        `json:"caption,omitempty"`
+}
+
+type UserDocument struct {
+	FileName string `json:"file_name"`
+	MimeType string `json:"mime_type,omitempty"`
+	Data     []byte `json:"data"`
+}
+
+// UserVoice is a voice note (or, with AsAudio, an audio file) attached to a
+// UserMessage. Its Data is served through getFile and the file endpoint.
+type UserVoice struct {
+	Data     []byte `json:"data"`
+	Duration int    `json:"duration,omitempty"`
+	AsAudio  bool   `json:"as_audio,omitempty"`
+}
+
+type storedFile struct {
+	path string
+	data []byte
+}
+
+// UserEdit changes the text of a message the user sent earlier.
+type UserEdit struct {
+	ChatID    int64  `json:"chat_id"`
+	MessageID int64  `json:"message_id"`
+	Text      string `json:"text"`
+	FromID    int64  `json:"from_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+}
+
+type CallbackTap struct {
+	ChatID    int64  `json:"chat_id"`
+	MessageID int64  `json:"message_id"`
+	Data      string `json:"data"`
+	FromID    int64  `json:"from_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+}
+
+type CallbackAnswer struct {
+	CallbackQueryID string `json:"callback_query_id"`
+	Text            string `json:"text,omitempty"`
+}
+
+type wireUser struct {
+	ID       int64  `json:"id"`
+	IsBot    bool   `json:"is_bot"`
+	Username string `json:"username,omitempty"`
+}
+
+type wireChat struct {
+	ID int64 `json:"id"`
+}
+
+type wireMessage struct {
+	MessageID       int64                   `json:"message_id"`
+	MessageThreadID int64                   `json:"message_thread_id,omitempty"`
+	Date            int64                   `json:"date"`
+	Chat            wireChat                `json:"chat"`
+	From            *wireUser               `json:"from,omitempty"`
+	Text            string                  `json:"text,omitempty"`
+	ReplyToMessage  *wireMessage            `json:"reply_to_message,omitempty"`
+	Voice           *telegramapi.Voice      `json:"voice,omitempty"`
+	Audio           *telegramapi.Audio      `json:"audio,omitempty"`
+	Photo           []telegramapi.PhotoSize `json:"photo,omitempty"`
+	Document        *telegramapi.Document   `json:"document,omitempty"`
+	Caption         string                  `json:"caption,omitempty"`
+}
+
+type wireCallback struct {
+	ID      string       `json:"id"`
+	From    wireUser     `json:"from"`
+	Message *wireMessage `json:"message,omitempty"`
+	Data    string       `json:"data"`
+}
+
+type wireUpdate struct {
+	UpdateID      int64         `json:"update_id"`
+	Message       *wireMessage  `json:"message,omitempty"`
+	EditedMessage *wireMessage  `json:"edited_message,omitempty"`
+	CallbackQuery *wireCallback `json:"callback_query,omitempty"`
+}
+
+func NewServer(token string) *Server {
+	return &Server{
+		token:         strings.TrimSpace(token),
+		changed:       make(chan struct{}),
+		webhookClient: &http.Client{Timeout: 10 * time.Second},
+		webhookQueue:  make(chan webhookDelivery, 256),
+		files:         map[string]storedFile{},
+		migrated:      map[int64]int64{},
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/control/"):
+		s.serveControl(w, r)
+	case strings.HasPrefix(r.URL.Path, "/file/bot"):
+		s.serveFile(w, r)
+	case strings.HasPrefix(r.URL.Path, "/bot"):
+		s.serveBot(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+func (s *Server) InjectMessage(m UserMessage) (updateID int64, messageID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextMessageID++
+	msg := &wireMessage{
+		MessageID: s.nextMessageID,
+		Date:      time.Now().Unix(),
+		Chat:      wireChat{ID: m.ChatID},
+		From:      userOrDefault(m.FromID, m.Username, m.ChatID),
+		Text:      m.Text,
+	}
+	msg.From.IsBot = m.IsBot
+	msg.MessageThreadID = m.ThreadID
+	if m.ReplyToMessageID != 0 {
+		msg.ReplyToMessage = s.messageLocked(m.ChatID, m.ReplyToMessageID)
+	}
+	if m.Voice != nil {
+		size := int64(len(m.Voice.Data))
+		if m.Voice.AsAudio {
+			fileID := s.storeFileLocked("music", ".mp3", m.Voice.Data)
+			msg.Audio = &telegramapi.Audio{FileID: fileID, FileUniqueID: "u" + fileID, Duration: m.Voice.Duration, MimeType: "audio/mpeg", FileSize: size}
+		} else {
+			fileID := s.storeFileLocked("voice", ".oga", m.Voice.Data)
+			msg.Voice = &telegramapi.Voice{FileID: fileID, FileUniqueID: "u" + fileID, Duration: m.Voice.Duration, MimeType: "audio/ogg", FileSize: size}
+		}
+	}
+	if m.Photo != nil {
+		fileID := s.storeFileLocked("photos", ".jpg", m.Photo)
+		msg.Photo = []telegramapi.PhotoSize{{FileID: fileID, FileUniqueID: "u" + fileID, Width: 800, Height: 600, FileSize: int64(len(m.Photo))}}
+	}
+	if m.Document != nil {
+		fileID := s.storeFileLocked("documents", "", m.Document.Data)
+		msg.Document = &telegramapi.Document{FileID: fileID, FileUniqueID: "u" + fileID, FileName: m.Document.FileName, MimeType: m.Document.MimeType, FileSize: int64(len(m.Document.Data))}
+	}
+	msg.Caption = m.Caption
+
+	return s.pushLocked(wireUpdate{Message: msg}), msg.MessageID
+}
+
+func (s *Server) InjectEdit(e UserEdit) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg := &wireMessage{
+		MessageID: e.MessageID,
+		Date:      time.Now().Unix(),
+		Chat:      wireChat{ID: e.ChatID},
+		From:      userOrDefault(e.FromID, e.Username, e.ChatID),
+		Text:      e.Text,
+	}
+	return s.pushLocked(wireUpdate{EditedMessage: msg})
+}
+
+func (s *Server) InjectCallback(tap CallbackTap) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextCallback++
+	cb := &wireCallback{
+		ID:      fmt.Sprintf("cb-%d", s.nextCallback),
+		From:    *userOrDefault(tap.FromID, tap.Username, tap.ChatID),
+		Message: s.messageLocked(tap.ChatID, tap.MessageID),
+		Data:    tap.Data,
+	}
+	return s.pushLocked(wireUpdate{CallbackQuery: cb})
+}
+
+// MigrateChat upgrades group chat from to supergroup to: requests naming
+// from fail with migrate_to_chat_id, as Telegram does after the upgrade.
+func (s *Server) MigrateChat(from int64, to int64) {
+	s.mu.Lock()
+	s.migrated[from] = to
+	s.mu.Unlock()
+}
+
+func (s *Server) SentMessages() []SentMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]SentMessage, len(s.sent))
+	copy(out, s.sent)
+	return out
+}
+
+func (s *Server) CallbackAnswers() []CallbackAnswer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]CallbackAnswer, len(s.answers))
+	copy(out, s.answers)
+	return out
+}
+
+// WaitForSent blocks until the bot has sent at least n messages.
+func (s *Server) WaitForSent(ctx context.Context, n int) ([]SentMessage, error) {
+	for {
+		s.mu.Lock()
+		if len(s.sent) >= n {
+			out := make([]SentMessage, len(s.sent))
+			copy(out, s.sent)
+			s.mu.Unlock()
+			return out, nil
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (s *Server) serveBot(w http.ResponseWriter, r *http.Request) {
+	token, method, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/bot"), "/")
+	if !ok || token != s.token {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+
+	if chatID, err := strconv.ParseInt(r.FormValue("chat_id"), 10, 64); err == nil {
+		s.mu.Lock()
+		to, ok := s.migrated[chatID]
+		s.mu.Unlock()
+		if ok {
+			writeMigrated(w, to)
+			return
+		}
+	}
+
+	switch method {
+	case "sendMessage":
+		s.handleSendMessage(w, r)
+	case "sendDocument":
+		s.handleSendDocument(w, r)
+	case "getUpdates":
+		s.handleGetUpdates(w, r)
+	case "getFile":
+		s.handleGetFile(w, r)
+	case "answerCallbackQuery":
+		s.handleAnswerCallbackQuery(w, r)
+	case "editMessageText", "editMessageReplyMarkup":
+		s.handleEditMessage(w, r, method == "editMessageText")
+	case "pinChatMessage":
+		s.handlePinChatMessage(w, r)
+	case "setWebhook":
+		s.handleSetWebhook(w, r)
+	case "deleteWebhook":
+		s.handleDeleteWebhook(w)
+	case "getWebhookInfo":
+		s.handleGetWebhookInfo(w)
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+func (s *Server) handleSendMessage(w http.ResponseWriter, r *http.Request) {
+	chatID, err := strconv.ParseInt(r.Form.Get("chat_id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: chat not found")
+		return
+	}
+	text := r.Form.Get("text")
+	if strings.TrimSpace(text) == "" {
+		writeError(w, http.StatusBadRequest, "Bad Request: message text is empty")
+		return
+	}
+	if telegramformat.Length(text) > telegramformat.MaxMessageLength {
+		writeError(w, http.StatusBadRequest, "Bad Request: message is too long")
+		return
+	}
+
+	threadID, _ := strconv.ParseInt(r.Form.Get("message_thread_id"), 10, 64)
+
+	markup, err := decodeMarkup(r.Form.Get("reply_markup"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: can't parse reply keyboard markup JSON object")
+		return
+	}
+
+	s.mu.Lock()
+	s.nextMessageID++
+	msg := SentMessage{
+		MessageID:   s.nextMessageID,
+		ChatID:      chatID,
+		Text:        text,
+		ReplyMarkup: markup,
+		ParseMode:   r.Form.Get("parse_mode"),
+		ThreadID:    threadID,
+	}
+	s.sent = append(s.sent, msg)
+	s.notifyLocked()
+	s.mu.Unlock()
+
+	writeResult(w, wireMessage{
+		MessageID: msg.MessageID,
+		Date:      time.Now().Unix(),
+		Chat:      wireChat{ID: chatID},
+		Text:      text,
+	})
+}
+
+func (s *Server) handleSendDocument(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(telegramapi.MaxUploadSize); err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: there is no document in the request")
+		return
+	}
+	chatID, err := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: chat not found")
+		return
+	}
+	file, header, err := r.FormFile("document")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: there is no document in the request")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+	threadID, _ := strconv.ParseInt(r.FormValue("message_thread_id"), 10, 64)
+	caption := r.FormValue("caption")
+	if telegramformat.Length(caption) > 1024 {
+		writeError(w, http.StatusBadRequest, "Bad Request: message caption is too long")
+		return
+	}
+
+	s.mu.Lock()
+	s.nextMessageID++
+	msg := SentMessage{
+		MessageID: s.nextMessageID,
+		ChatID:    chatID,
+		Text:      caption,
+		ParseMode: r.FormValue("parse_mode"),
+		ThreadID:  threadID,
+		Document:  &SentDocument{FileName: header.Filename, Data: data},
+	}
+	s.sent = append(s.sent, msg)
+	s.notifyLocked()
+	s.mu.Unlock()
+
+	writeResult(w, wireMessage{
+		MessageID: msg.MessageID,
+		Date:      time.Now().Unix(),
+		Chat:      wireChat{ID: chatID},
+	})
+}
+
+// storeFileLocked keeps data for getFile and the file endpoint and returns
+// its file_id.
+func (s *Server) storeFileLocked(folder string, ext string, data []byte) string {
+	s.nextFile++
+	fileID := fmt.Sprintf("file-%d", s.nextFile)
+	s.files[fileID] = storedFile{path: fmt.Sprintf("%s/file_%d%s", folder, s.nextFile, ext), data: data}
+	return fileID
+}
+
+func (s *Server) handleGetFile(w http.ResponseWriter, r *http.Request) {
+	fileID := r.FormValue("file_id")
+
+	s.mu.Lock()
+	f, ok := s.files[fileID]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Bad Request: invalid file_id")
+		return
+	}
+	writeResult(w, telegramapi.File{
+		FileID:       fileID,
+		FileUniqueID: "u" + fileID,
+		FileSize:     int64(len(f.data)),
+		FilePath:     f.path,
+	})
+}
+
+// serveFile answers /file/bot<token>/<file_path> downloads.
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request) {
+	token, path, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/file/bot"), "/")
+	if !ok || token != s.token {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.files {
+		if f.path == path {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write(f.data)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "Not Found")
+}
+
+func (s *Server) handleGetUpdates(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	webhookActive := s.webhookURL != ""
+	s.mu.Unlock()
+	if webhookActive {
+		writeError(w, http.StatusConflict, "Conflict: can't use getUpdates method while webhook is active; use deleteWebhook to delete the webhook first")
+		return
+	}
+
+	offset, _ := strconv.ParseInt(r.Form.Get("offset"), 10, 64)
+	timeoutSec, _ := strconv.Atoi(r.Form.Get("timeout"))
+	timeout := time.Duration(timeoutSec) * time.Second
+	if timeout > maxPollTimeout {
+		timeout = maxPollTimeout
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		s.confirmLocked(offset)
+		pending := make([]wireUpdate, len(s.updates))
+		copy(pending, s.updates)
+		changed := s.changed
+		s.mu.Unlock()
+
+		if len(pending) > 0 || timeout <= 0 {
+			writeResult(w, pending)
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-timer.C:
+			writeResult(w, []wireUpdate{})
+			return
+		case <-changed:
+		}
+	}
+}
+
+func (s *Server) handleAnswerCallbackQuery(w http.ResponseWriter, r *http.Request) {
+	id := r.Form.Get("callback_query_id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "Bad Request: query is too old and response timeout expired or query ID is invalid")
+		return
+	}
+
+	s.mu.Lock()
+	s.answers = append(s.answers, CallbackAnswer{CallbackQueryID: id, Text: r.Form.Get("text")})
+	s.notifyLocked()
+	s.mu.Unlock()
+
+	writeResult(w, true)
+}
+
+func (s *Server) handleEditMessage(w http.ResponseWriter, r *http.Request, editText bool) {
+	chatID, _ := strconv.ParseInt(r.Form.Get("chat_id"), 10, 64)
+	messageID, _ := strconv.ParseInt(r.Form.Get("message_id"), 10, 64)
+	text := r.Form.Get("text")
+	if editText && strings.TrimSpace(text) == "" {
+		writeError(w, http.StatusBadRequest, "Bad Request: message text is empty")
+		return
+	}
+	markup, err := decodeMarkup(r.Form.Get("reply_markup"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: can't parse reply keyboard markup JSON object")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.sent {
+		m := &s.sent[i]
+		if m.ChatID != chatID || m.MessageID != messageID {
+			continue
+		}
+		if editText {
+			m.Text = text
+		}
+		// Like the real API, omitting reply_markup removes the keyboard.
+		m.ReplyMarkup = markup
+		m.Edited = true
+		s.notifyLocked()
+
+		writeResult(w, wireMessage{
+			MessageID: m.MessageID,
+			Date:      time.Now().Unix(),
+			Chat:      wireChat{ID: m.ChatID},
+			Text:      m.Text,
+		})
+		return
+	}
+
+	writeError(w, http.StatusBadRequest, "Bad Request: message to edit not found")
+}
+
+func (s *Server) handlePinChatMessage(w http.ResponseWriter, r *http.Request) {
+	chatID, _ := strconv.ParseInt(r.Form.Get("chat_id"), 10, 64)
+	messageID, _ := strconv.ParseInt(r.Form.Get("message_id"), 10, 64)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.sent {
+		m := &s.sent[i]
+		if m.ChatID == chatID && m.MessageID == messageID {
+			m.Pinned = true
+			s.notifyLocked()
+			writeResult(w, true)
+			return
+		}
+	}
+
+	writeError(w, http.StatusBadRequest, "Bad Request: message to pin not found")
+}
+
+func (s *Server) handleSetWebhook(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.webhookURL = r.Form.Get("url")
+	s.webhookSecret = r.Form.Get("secret_token")
+	pending := s.updates
+	s.updates = nil
+	for _, u := range pending {
+		s.deliverLocked(u)
+	}
+	s.mu.Unlock()
+
+	writeResult(w, true)
+}
+
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter) {
+	s.mu.Lock()
+	s.webhookURL = ""
+	s.webhookSecret = ""
+	s.mu.Unlock()
+
+	writeResult(w, true)
+}
+
+func (s *Server) handleGetWebhookInfo(w http.ResponseWriter) {
+	s.mu.Lock()
+	info := map[string]any{
+		"url":                    s.webhookURL,
+		"has_custom_certificate": false,
+		"pending_update_count":   len(s.updates),
+	}
+	s.mu.Unlock()
+
+	writeResult(w, info)
+}
+
+func (s *Server) serveControl(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/control/message":
+		var m UserMessage
+		if err := decodeControl(r, &m); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		updateID, messageID := s.InjectMessage(m)
+		writeResult(w, map[string]int64{"update_id": updateID, "message_id": messageID})
+	case "/control/edit":
+		var e UserEdit
+		if err := decodeControl(r, &e); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeResult(w, map[string]int64{"update_id": s.InjectEdit(e)})
+	case "/control/callback":
+		var tap CallbackTap
+		if err := decodeControl(r, &tap); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeResult(w, map[string]int64{"update_id": s.InjectCallback(tap)})
+	case "/control/sent":
+		writeResult(w, s.SentMessages())
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+func (s *Server) pushLocked(u wireUpdate) int64 {
+	s.nextUpdateID++
+	u.UpdateID = s.nextUpdateID
+	if s.webhookURL != "" {
+		s.deliverLocked(u)
+	} else {
+		s.updates = append(s.updates, u)
+	}
+	s.notifyLocked()
+	return u.UpdateID
+}
+
+// deliverLocked queues an update for the registered webhook. A single
+// worker posts them in order, the way Telegram does once setWebhook is
+// active.
+func (s *Server) deliverLocked(u wireUpdate) {
+	body, err := json.Marshal(u)
+	if err != nil {
+		return
+	}
+	s.webhookOnce.Do(func() {
+		go s.deliverWebhooks()
+	})
+	s.webhookQueue <- webhookDelivery{url: s.webhookURL, secret: s.webhookSecret, body: body}
+}
+
+func (s *Server) deliverWebhooks() {
+	for d := range s.webhookQueue {
+		req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(d.body))
+		if err != nil {
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if d.secret != "" {
+			req.Header.Set("X-Telegram-Bot-Api-Secret-Token", d.secret)
+		}
+		resp, err := s.webhookClient.Do(req)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+	}
+}
+
+// confirmLocked drops updates below offset, mirroring how getUpdates
+// acknowledges everything before the requested offset.
+func (s *Server) confirmLocked(offset int64) {
+	if offset <= 0 {
+		return
+	}
+	kept := s.updates[:0]
+	for _, u := range s.updates {
+		if u.UpdateID >= offset {
+			kept = append(kept, u)
+		}
+	}
+	s.updates = kept
+}
+
+func (s *Server) notifyLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) messageLocked(chatID int64, messageID int64) *wireMessage {
+	for _, m := range s.sent {
+		if m.ChatID == chatID && m.MessageID == messageID {
+			return &wireMessage{
+				MessageID: m.MessageID,
+				Chat:      wireChat{ID: m.ChatID},
+				From:      &wireUser{ID: 1, IsBot: true, Username: "fake_bot"},
+				Text:      m.Text,
+			}
+		}
+	}
+	return &wireMessage{MessageID: messageID, Chat: wireChat{ID: chatID}}
+}
+
+func userOrDefault(id int64, username string, chatID int64) *wireUser {
+	if id == 0 {
+		id = chatID
+	}
+	if username == "" {
+		username = "tester"
+	}
+	return &wireUser{ID: id, Username: username}
+}
+
+func decodeMarkup(raw string) (*telegramapi.InlineKeyboardMarkup, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var markup telegramapi.InlineKeyboardMarkup
+	if err := json.Unmarshal([]byte(raw), &markup); err != nil {
+		return nil, err
+	}
+	return &markup, nil
+}
+
+func decodeControl(r *http.Request, v any) error {
+	if r.Method != http.MethodPost {
+		return errors.New("control endpoints that inject updates require POST")
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode control request: %w", err)
+	}
+	return nil
+}
+
+func writeResult(w http.ResponseWriter, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+func writeMigrated(w http.ResponseWriter, to int64) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"ok":          false,
+		"error_code":  http.StatusBadRequest,
+		"description": "Bad Request: group chat was upgraded to a supergroup chat",
+		"parameters":  map[string]int64{"migrate_to_chat_id": to},
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"ok":          false,
+		"error_code":  status,
+		"description": description,
+	})
+}
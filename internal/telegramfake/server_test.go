@@ -0,0 +1,166 @@
+package telegramfake
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"codex-brainstorming-telegram/internal/telegramapi"
+)
+
+func TestServerRoundTripThroughClient(t *testing.T) {
+	t.Parallel()
+
+	fake := NewServer("token123")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	client := telegramapi.NewClient(srv.URL, "token123", srv.Client())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	messageID, err := client.SendMessageWithOptions(ctx, "777", "A/B?", telegramapi.SendOptions{
+		ReplyMarkup: &telegramapi.InlineKeyboardMarkup{
+			InlineKeyboard: [][]telegramapi.InlineKeyboardButton{{{Text: "A", CallbackData: "opt:0"}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SendMessageWithOptions() error = %v", err)
+	}
+
+	sent := fake.SentMessages()
+	if len(sent) != 1 || sent[0].MessageID != messageID || sent[0].Text != "A/B?" {
+		t.Fatalf("SentMessages() = %+v", sent)
+	}
+	if sent[0].ReplyMarkup == nil || sent[0].ReplyMarkup.InlineKeyboard[0][0].CallbackData != "opt:0" {
+		t.Fatalf("reply markup = %+v", sent[0].ReplyMarkup)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		fake.InjectMessage(UserMessage{ChatID: 777, Text: "B", ReplyToMessageID: messageID})
+	}()
+
+	updates, err := client.GetUpdates(ctx, 0, 5)
+	if err != nil {
+		t.Fatalf("GetUpdates() error = %v", err)
+	}
+	if len(updates) != 1 {
+		t.Fatalf("len(updates) = %d, want 1", len(updates))
+	}
+	msg := updates[0].Message
+	if msg.Text != "B" || msg.Chat.ID != 777 {
+		t.Fatalf("message = %+v", msg)
+	}
+	if msg.ReplyToMessage == nil || msg.ReplyToMessage.MessageID != messageID {
+		t.Fatalf("reply_to_message = %+v, want %d", msg.ReplyToMessage, messageID)
+	}
+
+	updates, err = client.GetUpdates(ctx, updates[0].UpdateID+1, 0)
+	if err != nil {
+		t.Fatalf("GetUpdates() after confirm error = %v", err)
+	}
+	if len(updates) != 0 {
+		t.Fatalf("len(updates) after confirm = %d, want 0", len(updates))
+	}
+}
+
+func TestServerCallbackQueryAndAnswer(t *testing.T) {
+	t.Parallel()
+
+	fake := NewServer("token123")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	client := telegramapi.NewClient(srv.URL, "token123", srv.Client())
+	ctx := context.Background()
+
+	messageID, err := client.SendMessage(ctx, "777", "A/B?")
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	fake.InjectCallback(CallbackTap{ChatID: 777, MessageID: messageID, Data: "opt:1"})
+
+	updates, err := client.GetUpdates(ctx, 0, 0)
+	if err != nil {
+		t.Fatalf("GetUpdates() error = %v", err)
+	}
+	cb := updates[0].CallbackQuery
+	if cb == nil || cb.Data != "opt:1" || cb.Message == nil || cb.Message.MessageID != messageID {
+		t.Fatalf("callback = %+v", cb)
+	}
+
+	if err := client.AnswerCallbackQuery(ctx, cb.ID, "ok"); err != nil {
+		t.Fatalf("AnswerCallbackQuery() error = %v", err)
+	}
+	if answers := fake.CallbackAnswers(); len(answers) != 1 || answers[0].CallbackQueryID != cb.ID {
+		t.Fatalf("CallbackAnswers() = %+v", answers)
+	}
+}
+
+func TestServerRejectsWrongToken(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(NewServer("token123"))
+	defer srv.Close()
+
+	client := telegramapi.NewClient(srv.URL, "wrong", srv.Client())
+	_, err := client.SendMessage(context.Background(), "777", "hi")
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("SendMessage() error = %v, want 401", err)
+	}
+}
+
+func TestServerControlEndpoints(t *testing.T) {
+	t.Parallel()
+
+	fake := NewServer("token123")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	resp, err := srv.Client().Post(srv.URL+"/control/message", "application/json", strings.NewReader(`{"chat_id":777,"text":"hello"}`))
+	if err != nil {
+		t.Fatalf("POST /control/message error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+
+	client := telegramapi.NewClient(srv.URL, "token123", srv.Client())
+	updates, err := client.GetUpdates(context.Background(), 0, 0)
+	if err != nil {
+		t.Fatalf("GetUpdates() error = %v", err)
+	}
+	if len(updates) != 1 || updates[0].Message.Text != "hello" {
+		t.Fatalf("updates = %+v", updates)
+	}
+}
+
+func TestServerPinsSentMessages(t *testing.T) {
+	t.Parallel()
+
+	fake := NewServer("token123")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	client := telegramapi.NewClient(srv.URL, "token123", srv.Client())
+	ctx := context.Background()
+	messageID, err := client.SendMessage(ctx, "777", "recap")
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if err := client.PinChatMessage(ctx, "777", messageID); err != nil {
+		t.Fatalf("PinChatMessage() error = %v", err)
+	}
+	if sent := fake.SentMessages(); !sent[0].Pinned {
+		t.Fatalf("SentMessages() = %+v, want the message pinned", sent)
+	}
+
+	if err := client.PinChatMessage(ctx, "777", messageID+1); err == nil {
+		t.Fatal("PinChatMessage() of an unknown message error = nil, want non-nil")
+	}
+}
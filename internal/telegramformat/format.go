@@ -0,0 +1,158 @@
+// Package telegramformat prepares prompt text for sendMessage: it renders a
+// small Markdown subset into Telegram's MarkdownV2 or HTML parse modes and
+// splits long text into chunks that fit Telegram's message size limit.
+package telegramformat
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MaxMessageLength is Telegram's limit for one sendMessage text, counted in
+// UTF-16 code units after entity parsing.
+const MaxMessageLength = 4096
+
+type ParseMode string
+
+const (
+	// ParseModeNone sends the text as is, without parse_mode.
+	ParseModeNone ParseMode = ""
+	// ParseModeMarkdownV2 renders the text with Telegram's MarkdownV2.
+	ParseModeMarkdownV2 ParseMode = "MarkdownV2"
+	// ParseModeHTML renders the text with Telegram's HTML subset.
+	ParseModeHTML ParseMode = "HTML"
+)
+
+// ParseParseMode accepts the CLI spellings of a parse mode.
+func ParseParseMode(raw string) (ParseMode, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", "none", "plain":
+		return ParseModeNone, nil
+	case "markdownv2", "markdown":
+		return ParseModeMarkdownV2, nil
+	case "html":
+		return ParseModeHTML, nil
+	default:
+		return ParseModeNone, fmt.Errorf("unknown parse mode %q (want none, markdownv2 or html)", raw)
+	}
+}
+
+const markdownV2Special = "_*[]()~`>#+-=|{}.!\\"
+
+// EscapeMarkdownV2 escapes every character that MarkdownV2 treats as markup,
+// so s is shown literally.
+func EscapeMarkdownV2(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		if strings.ContainsRune(markdownV2Special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// escapeMarkdownV2Code escapes text inside pre and code entities, where only
+// ` and \ are special.
+func escapeMarkdownV2Code(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		if r == '`' || r == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// EscapeHTML escapes the characters Telegram's HTML parse mode requires.
+func EscapeHTML(s string) string {
+	return htmlEscaper.Replace(s)
+}
+
+// Escape escapes s for mode. ParseModeNone returns s unchanged.
+func Escape(s string, mode ParseMode) string {
+	switch mode {
+	case ParseModeMarkdownV2:
+		return EscapeMarkdownV2(s)
+	case ParseModeHTML:
+		return EscapeHTML(s)
+	default:
+		return s
+	}
+}
+
+var (
+	headingLine  = regexp.MustCompile(`^#{1,6}\s+(.+?)\s*#*\s*$`)
+	bulletLine   = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	inlineSpan   = regexp.MustCompile("`[^`\n]+`|\\*\\*[^*\n]+\\*\\*")
+	fenceLine    = regexp.MustCompile("^\\s*```")
+	fenceLangTag = regexp.MustCompile(`^[A-Za-z0-9_+\-]+$`)
+)
+
+// renderLine renders one line of a paragraph: headings become bold, list
+// bullets become "•", and `code` and **bold** spans are kept. Everything else
+// is escaped.
+func renderLine(line string, mode ParseMode) string {
+	if mode == ParseModeNone {
+		return line
+	}
+	if m := headingLine.FindStringSubmatch(line); m != nil {
+		return bold(renderInline(m[1], mode), mode)
+	}
+	if m := bulletLine.FindStringSubmatch(line); m != nil {
+		return m[1] + "• " + renderInline(m[2], mode)
+	}
+	return renderInline(line, mode)
+}
+
+func renderInline(s string, mode ParseMode) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range inlineSpan.FindAllStringIndex(s, -1) {
+		b.WriteString(Escape(s[last:loc[0]], mode))
+		span := s[loc[0]:loc[1]]
+		if strings.HasPrefix(span, "`") {
+			b.WriteString(code(span[1:len(span)-1], mode))
+		} else {
+			b.WriteString(bold(Escape(span[2:len(span)-2], mode), mode))
+		}
+		last = loc[1]
+	}
+	b.WriteString(Escape(s[last:], mode))
+	return b.String()
+}
+
+func bold(rendered string, mode ParseMode) string {
+	if mode == ParseModeHTML {
+		return "<b>" + rendered + "</b>"
+	}
+	return "*" + rendered + "*"
+}
+
+func code(raw string, mode ParseMode) string {
+	if mode == ParseModeHTML {
+		return "<code>" + EscapeHTML(raw) + "</code>"
+	}
+	return "`" + escapeMarkdownV2Code(raw) + "`"
+}
+
+func renderCodeBlock(lang string, lines []string, mode ParseMode) string {
+	body := strings.Join(lines, "\n")
+	switch mode {
+	case ParseModeMarkdownV2:
+		return "```" + lang + "\n" + escapeMarkdownV2Code(body) + "\n```"
+	case ParseModeHTML:
+		if lang != "" {
+			return `<pre><code class="language-` + lang + `">` + EscapeHTML(body) + "</code></pre>"
+		}
+		return "<pre>" + EscapeHTML(body) + "</pre>"
+	default:
+		return "```" + lang + "\n" + body + "\n```"
+	}
+}
@@ -0,0 +1,184 @@
+package telegramformat
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestEscapeMarkdownV2(t *testing.T) {
+	t.Parallel()
+
+	got := EscapeMarkdownV2(`v1.2 (beta) - a_b*c [x]! \ok`)
+	want := `v1\.2 \(beta\) \- a\_b\*c \[x\]\! \\ok`
+	if got != want {
+		t.Fatalf("EscapeMarkdownV2() = %q, want %q", got, want)
+	}
+}
+
+func TestEscapeHTML(t *testing.T) {
+	t.Parallel()
+
+	if got := EscapeHTML(`a < b && c > "d"`); got != `a &lt; b &amp;&amp; c &gt; "d"` {
+		t.Fatalf("EscapeHTML() = %q", got)
+	}
+}
+
+func TestParseParseMode(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]ParseMode{
+		"":           ParseModeNone,
+		"none":       ParseModeNone,
+		"MarkdownV2": ParseModeMarkdownV2,
+		"html":       ParseModeHTML,
+	}
+	for raw, want := range tests {
+		got, err := ParseParseMode(raw)
+		if err != nil || got != want {
+			t.Fatalf("ParseParseMode(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+	if _, err := ParseParseMode("bbcode"); err == nil {
+		t.Fatal("ParseParseMode(bbcode) error = nil, want error")
+	}
+}
+
+const samplePlan = "## Plan v2\n\n- step one (safe)\n- run `make test`\n\n**Note:** 1.5x faster!\n\n```go\nfmt.Println(\"a_b\")\n```"
+
+func TestRenderMarkdownV2(t *testing.T) {
+	t.Parallel()
+
+	got := Render(samplePlan, ParseModeMarkdownV2, 0)
+	want := "*Plan v2*\n\n" +
+		"• step one \\(safe\\)\n• run `make test`\n\n" +
+		"*Note:* 1\\.5x faster\\!\n\n" +
+		"```go\nfmt.Println(\"a_b\")\n```"
+	if len(got) != 1 || got[0] != want {
+		t.Fatalf("Render() = %q, want %q", got, want)
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	t.Parallel()
+
+	got := Render("# A & B\n\n```go\nif a < b {}\n```", ParseModeHTML, 0)
+	want := "<b>A &amp; B</b>\n\n<pre><code class=\"language-go\">if a &lt; b {}</code></pre>"
+	if len(got) != 1 || got[0] != want {
+		t.Fatalf("Render() = %q, want %q", got, want)
+	}
+}
+
+func TestRenderPlainShortTextUnchanged(t *testing.T) {
+	t.Parallel()
+
+	text := "line one\n\n\n  indented *not bold*"
+	if got := Render(text, ParseModeNone, 0); len(got) != 1 || got[0] != text {
+		t.Fatalf("Render() = %q, want text unchanged", got)
+	}
+}
+
+func TestRenderSplitsOnParagraphBoundaries(t *testing.T) {
+	t.Parallel()
+
+	para := strings.Repeat("word ", 24)
+	text := strings.Join([]string{para, para, para, para, para}, "\n\n")
+
+	chunks := Render(text, ParseModeNone, 300)
+	if len(chunks) != 3 {
+		t.Fatalf("len(chunks) = %d, want 3: %q", len(chunks), chunks)
+	}
+	for i, chunk := range chunks {
+		if Length(chunk) > 300 {
+			t.Fatalf("chunk %d length = %d, want <= 300", i, Length(chunk))
+		}
+		wantMarker := "(" + string(rune('1'+i)) + "/3)\n"
+		if !strings.HasPrefix(chunk, wantMarker) {
+			t.Fatalf("chunk %d = %q, want marker %q", i, chunk, wantMarker)
+		}
+		if strings.Contains(strings.TrimPrefix(chunk, wantMarker), "(") {
+			t.Fatalf("chunk %d has a stray marker: %q", i, chunk)
+		}
+	}
+}
+
+func TestRenderRefencesSplitCodeBlocks(t *testing.T) {
+	t.Parallel()
+
+	var lines []string
+	for i := 0; i < 120; i++ {
+		lines = append(lines, "fmt.Println(\"line_"+strings.Repeat("x", 10)+"\")")
+	}
+	text := "Intro.\n\n```go\n" + strings.Join(lines, "\n") + "\n```"
+
+	chunks := Render(text, ParseModeMarkdownV2, 1000)
+	if len(chunks) < 3 {
+		t.Fatalf("len(chunks) = %d, want >= 3", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if Length(chunk) > 1000 {
+			t.Fatalf("chunk %d length = %d, want <= 1000", i, Length(chunk))
+		}
+		if strings.Count(chunk, "```")%2 != 0 {
+			t.Fatalf("chunk %d has an unbalanced fence: %q", i, chunk)
+		}
+		if !strings.HasPrefix(chunk, `\(`) {
+			t.Fatalf("chunk %d = %q, want escaped marker", i, chunk)
+		}
+	}
+}
+
+func TestRenderSplitsOverlongLine(t *testing.T) {
+	t.Parallel()
+
+	text := strings.Repeat("a.", 300)
+	chunks := Render(text, ParseModeMarkdownV2, 200)
+	var joined strings.Builder
+	for i, chunk := range chunks {
+		if Length(chunk) > 200 {
+			t.Fatalf("chunk %d length = %d, want <= 200", i, Length(chunk))
+		}
+		_, body, _ := strings.Cut(chunk, "\n")
+		joined.WriteString(body)
+	}
+	if got := joined.String(); got != EscapeMarkdownV2(text) {
+		t.Fatalf("joined chunks = %q, want escaped original", got)
+	}
+}
+
+func TestRenderSplitsLargePlanWithinLimit(t *testing.T) {
+	t.Parallel()
+
+	// A long plan: one huge paragraph and one huge code block, both far
+	// over the limit, so pieces are built line by line.
+	var para, code []string
+	for i := 0; i < 2000; i++ {
+		para = append(para, fmt.Sprintf("- step %d: update *config* & <docs>", i))
+		code = append(code, fmt.Sprintf("fmt.Println(`line %d`) // a < b", i))
+	}
+	text := strings.Join(para, "\n") + "\n\n```go\n" + strings.Join(code, "\n") + "\n```"
+
+	for _, mode := range []ParseMode{ParseModeMarkdownV2, ParseModeHTML} {
+		chunks := Render(text, mode, MaxMessageLength)
+		if len(chunks) < 2 {
+			t.Fatalf("%s: chunks = %d, want a split", mode, len(chunks))
+		}
+		for i, chunk := range chunks {
+			if Length(chunk) > MaxMessageLength {
+				t.Fatalf("%s: chunk %d length = %d, want <= %d", mode, i, Length(chunk), MaxMessageLength)
+			}
+		}
+		last := chunks[len(chunks)-1]
+		if !strings.Contains(last, "line 1999") {
+			t.Fatalf("%s: last chunk = %q, want the end of the code block", mode, last)
+		}
+	}
+}
+
+func TestLengthCountsUTF16(t *testing.T) {
+	t.Parallel()
+
+	if got := Length("a中😀"); got != 4 {
+		t.Fatalf("Length() = %d, want 4", got)
+	}
+}
@@ -0,0 +1,233 @@
+package telegramformat
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// block is a paragraph or a fenced code block of the source text. Blocks are
+// the units Render keeps together whenever they fit in one message.
+type block struct {
+	code  bool
+	lang  string
+	lines []string
+	// literal blocks are pieces of an over-long line; they are escaped as a
+	// whole so inline markup never straddles two messages.
+	literal bool
+}
+
+// Render formats text for mode and splits the result into messages of at
+// most limit UTF-16 code units (MaxMessageLength when limit <= 0). Splits
+// fall on paragraph and code block boundaries where possible; over-long code
+// blocks are re-fenced in every chunk. When more than one message is needed,
+// each starts with a "(1/3)" style marker.
+func Render(text string, mode ParseMode, limit int) []string {
+	if limit <= 0 {
+		limit = MaxMessageLength
+	}
+	if mode == ParseModeNone && Length(text) <= limit {
+		return []string{text}
+	}
+
+	blocks := parseBlocks(text)
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	rendered := make([]string, 0, len(blocks))
+	for _, b := range blocks {
+		rendered = append(rendered, renderBlock(b, mode))
+	}
+	if whole := strings.Join(rendered, "\n\n"); Length(whole) <= limit {
+		return []string{whole}
+	}
+
+	budget := limit - Length(marker(9999, 9999, mode))
+	var pieces []string
+	for _, b := range blocks {
+		pieces = append(pieces, splitBlock(b, mode, budget)...)
+	}
+
+	var chunks []string
+	var cur strings.Builder
+	size := 0
+	for _, piece := range pieces {
+		n := Length(piece)
+		if cur.Len() > 0 && size+2+n > budget {
+			chunks = append(chunks, cur.String())
+			cur.Reset()
+			size = 0
+		}
+		if cur.Len() > 0 {
+			cur.WriteString("\n\n")
+			size += 2
+		}
+		cur.WriteString(piece)
+		size += n
+	}
+	if cur.Len() > 0 {
+		chunks = append(chunks, cur.String())
+	}
+
+	if len(chunks) > 1 {
+		for i := range chunks {
+			chunks[i] = marker(i+1, len(chunks), mode) + chunks[i]
+		}
+	}
+	return chunks
+}
+
+// Length counts s the way Telegram measures message length.
+func Length(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+func marker(i, n int, mode ParseMode) string {
+	return Escape(fmt.Sprintf("(%d/%d)", i, n), mode) + "\n"
+}
+
+func parseBlocks(text string) []block {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	var blocks []block
+	var cur *block
+	flush := func() {
+		if cur != nil && len(cur.lines) > 0 {
+			blocks = append(blocks, *cur)
+		}
+		cur = nil
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		if cur != nil && cur.code {
+			if fenceLine.MatchString(line) {
+				flush()
+				continue
+			}
+			cur.lines = append(cur.lines, line)
+			continue
+		}
+		if fenceLine.MatchString(line) {
+			flush()
+			lang := strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "`"))
+			if !fenceLangTag.MatchString(lang) {
+				lang = ""
+			}
+			cur = &block{code: true, lang: lang}
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		if cur == nil {
+			cur = &block{}
+		}
+		cur.lines = append(cur.lines, line)
+	}
+	flush()
+	return blocks
+}
+
+func renderBlock(b block, mode ParseMode) string {
+	switch {
+	case b.code:
+		return renderCodeBlock(b.lang, b.lines, mode)
+	case b.literal:
+		return Escape(strings.Join(b.lines, "\n"), mode)
+	default:
+		lines := make([]string, 0, len(b.lines))
+		for _, line := range b.lines {
+			lines = append(lines, renderLine(line, mode))
+		}
+		return strings.Join(lines, "\n")
+	}
+}
+
+// splitBlock renders b as one or more pieces that each fit in budget, cutting
+// between lines first and inside a line only when a single line is too long.
+func splitBlock(b block, mode ParseMode, budget int) []string {
+	if whole := renderBlock(b, mode); Length(whole) <= budget {
+		return []string{whole}
+	}
+
+	// Lines render independently, so a piece's length is the overhead of
+	// an empty block plus its rendered lines and the newlines between them.
+	// Tracking it keeps splitting linear in the size of the block.
+	overhead := 0
+	if b.code {
+		overhead = Length(renderCodeBlock(b.lang, []string{""}, mode))
+	}
+	lineLength := func(line string) int {
+		return Length(renderBlock(block{code: b.code, lang: b.lang, lines: []string{line}}, mode)) - overhead
+	}
+
+	var pieces []string
+	var cur []string
+	size := 0
+	flush := func() {
+		if len(cur) > 0 {
+			pieces = append(pieces, renderBlock(block{code: b.code, lang: b.lang, lines: cur}, mode))
+			cur = nil
+		}
+	}
+
+	for _, line := range b.lines {
+		n := lineLength(line)
+		if len(cur) > 0 && size+1+n <= budget {
+			cur = append(cur, line)
+			size += 1 + n
+			continue
+		}
+		flush()
+		if overhead+n <= budget {
+			cur = []string{line}
+			size = overhead + n
+			continue
+		}
+		for _, part := range splitLine(b, line, mode, budget) {
+			pieces = append(pieces, renderBlock(part, mode))
+		}
+	}
+	flush()
+	return pieces
+}
+
+// splitLine cuts one over-long line into blocks by the escaped size of each
+// rune, so the pieces fit in budget once rendered.
+func splitLine(b block, line string, mode ParseMode, budget int) []block {
+	overhead := 0
+	cost := func(r rune) int { return Length(Escape(string(r), mode)) }
+	if b.code {
+		overhead = Length(renderCodeBlock(b.lang, []string{""}, mode))
+		cost = func(r rune) int {
+			if mode == ParseModeMarkdownV2 {
+				return Length(escapeMarkdownV2Code(string(r)))
+			}
+			return Length(Escape(string(r), mode))
+		}
+	}
+
+	var parts []block
+	var cur strings.Builder
+	size := overhead
+	for _, r := range line {
+		c := cost(r)
+		if cur.Len() > 0 && size+c > budget {
+			parts = append(parts, block{code: b.code, lang: b.lang, lines: []string{cur.String()}, literal: !b.code})
+			cur.Reset()
+			size = overhead
+		}
+		cur.WriteRune(r)
+		size += c
+	}
+	if cur.Len() > 0 {
+		parts = append(parts, block{code: b.code, lang: b.lang, lines: []string{cur.String()}, literal: !b.code})
+	}
+	return parts
+}
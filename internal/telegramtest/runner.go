@@ -13,11 +13,22 @@ import (
 var ErrChallengeTimeout = errors.New("did not receive matching reply before timeout")
 
 type challengeAPI interface {
-	SendMessage(ctx context.Context, chatID string, text string) (int64, error)
+	SendMessageWithOptions(ctx context.Context, chatID string, text string, opts telegramapi.SendOptions) (int64, error)
 	GetUpdates(ctx context.Context, offset int64, timeoutSec int) ([]telegramapi.Update, error)
+	EffectiveChatID(chatID string) string
 }
 
-func RunChallenge(ctx context.Context, api challengeAPI, chatID string, code string, replyTimeout time.Duration) error {
+type ChallengeOptions struct {
+	// AllowedUserIDs limits whose replies count. Messages from bots are
+	// always ignored.
+	AllowedUserIDs []int64
+
+	// ThreadID sends the challenge into a forum topic and accepts only
+	// replies posted in that topic.
+	ThreadID int64
+}
+
+func RunChallenge(ctx context.Context, api challengeAPI, chatID string, code string, replyTimeout time.Duration, opts ChallengeOptions) error {
 	if replyTimeout <= 0 {
 		return errors.New("reply timeout must be greater than 0")
 	}
@@ -28,10 +39,14 @@ func RunChallenge(ctx context.Context, api challengeAPI, chatID string, code str
 	}
 
 	message := BuildChallengeMessage(code)
-	if _, err := api.SendMessage(ctx, chatID, message); err != nil {
+	if _, err := api.SendMessageWithOptions(ctx, chatID, message, telegramapi.SendOptions{MessageThreadID: opts.ThreadID}); err != nil {
 		return fmt.Errorf("send challenge message: %w", err)
 	}
 
+	// After a group upgrade the challenge went to the new supergroup, and
+	// the reply comes from there.
+	chatID = api.EffectiveChatID(chatID)
+
 	waitCtx, cancel := context.WithTimeout(ctx, replyTimeout)
 	defer cancel()
 	deadline := time.Now().Add(replyTimeout)
@@ -62,6 +77,12 @@ func RunChallenge(ctx context.Context, api challengeAPI, chatID string, code str
 			if fmt.Sprintf("%d", update.Message.Chat.ID) != chatID {
 				continue
 			}
+			if !telegramapi.SenderAllowed(update.Message.From, opts.AllowedUserIDs) {
+				continue
+			}
+			if opts.ThreadID != 0 && update.Message.MessageThreadID != opts.ThreadID {
+				continue
+			}
 			if IsMatchingReply(update.Message.Text, code) {
 				return nil
 			}
@@ -12,13 +12,28 @@ import (
 type fakeAPI struct {
 	sendChatID string
 	sendText   string
+	sendOpts   telegramapi.SendOptions
 	polls      [][]telegramapi.Update
 	pollIndex  int
+	sendErr    error
+	// migratedTo is the supergroup the chat was upgraded to, if any.
+	migratedTo string
 }
 
-func (f *fakeAPI) SendMessage(_ context.Context, chatID string, text string) (int64, error) {
+func (f *fakeAPI) EffectiveChatID(chatID string) string {
+	if f.migratedTo != "" {
+		return f.migratedTo
+	}
+	return chatID
+}
+
+func (f *fakeAPI) SendMessageWithOptions(_ context.Context, chatID string, text string, opts telegramapi.SendOptions) (int64, error) {
+	if f.sendErr != nil {
+		return 0, f.sendErr
+	}
 	f.sendChatID = chatID
 	f.sendText = text
+	f.sendOpts = opts
 	return 1, nil
 }
 
@@ -48,7 +63,7 @@ func TestRunChallengeSuccess(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	err := RunChallenge(ctx, api, "123", "654321", 3*time.Second)
+	err := RunChallenge(ctx, api, "123", "654321", 3*time.Second, ChallengeOptions{})
 	if err != nil {
 		t.Fatalf("RunChallenge() error = %v", err)
 	}
@@ -61,6 +76,32 @@ func TestRunChallengeSuccess(t *testing.T) {
 	}
 }
 
+func TestRunChallengeAcceptsReplyFromMigratedChat(t *testing.T) {
+	t.Parallel()
+
+	stale := telegramapi.Update{UpdateID: 2}
+	stale.Message.Chat.ID = -777
+	stale.Message.Text = "654321"
+	update := telegramapi.Update{UpdateID: 3}
+	update.Message.Chat.ID = -1001234
+	update.Message.Text = "654321"
+
+	api := &fakeAPI{
+		migratedTo: "-1001234",
+		polls:      [][]telegramapi.Update{nil, {stale}, {update}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := RunChallenge(ctx, api, "-777", "654321", 3*time.Second, ChallengeOptions{}); err != nil {
+		t.Fatalf("RunChallenge() error = %v", err)
+	}
+	if api.pollIndex != 3 {
+		t.Fatalf("polls = %d, want the reply from the old chat ignored", api.pollIndex)
+	}
+}
+
 func TestRunChallengeTimeout(t *testing.T) {
 	t.Parallel()
 
@@ -68,7 +109,7 @@ func TestRunChallengeTimeout(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	err := RunChallenge(ctx, api, "123", "654321", 20*time.Millisecond)
+	err := RunChallenge(ctx, api, "123", "654321", 20*time.Millisecond, ChallengeOptions{})
 	if err == nil {
 		t.Fatal("RunChallenge() error = nil, want timeout error")
 	}
@@ -76,3 +117,63 @@ func TestRunChallengeTimeout(t *testing.T) {
 		t.Fatalf("RunChallenge() error = %v, want %v", err, ErrChallengeTimeout)
 	}
 }
+
+func TestRunChallengeWrapsAPIError(t *testing.T) {
+	t.Parallel()
+
+	api := &fakeAPI{sendErr: &telegramapi.APIError{
+		Method:      "sendMessage",
+		StatusCode:  401,
+		ErrorCode:   401,
+		Description: "Unauthorized",
+	}}
+
+	err := RunChallenge(context.Background(), api, "123", "654321", time.Second, ChallengeOptions{})
+	var apiErr *telegramapi.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("RunChallenge() error = %v, want *telegramapi.APIError", err)
+	}
+	if !errors.Is(err, telegramapi.ErrUnauthorized) {
+		t.Fatalf("RunChallenge() error = %v, want %v", err, telegramapi.ErrUnauthorized)
+	}
+}
+
+func TestRunChallengeIgnoresUnlistedSenders(t *testing.T) {
+	t.Parallel()
+
+	stranger := telegramapi.Update{UpdateID: 2}
+	stranger.Message.Chat.ID = 123
+	stranger.Message.From = &telegramapi.User{ID: 7}
+	stranger.Message.Text = "654321"
+
+	api := &fakeAPI{polls: [][]telegramapi.Update{nil, {stranger}}}
+	err := RunChallenge(context.Background(), api, "123", "654321", 50*time.Millisecond, ChallengeOptions{AllowedUserIDs: []int64{42}})
+	if !errors.Is(err, ErrChallengeTimeout) {
+		t.Fatalf("RunChallenge() error = %v, want %v", err, ErrChallengeTimeout)
+	}
+}
+
+func TestRunChallengeStaysInThread(t *testing.T) {
+	t.Parallel()
+
+	otherTopic := telegramapi.Update{UpdateID: 2}
+	otherTopic.Message.Chat.ID = 123
+	otherTopic.Message.MessageThreadID = 8
+	otherTopic.Message.Text = "654321"
+
+	sameTopic := telegramapi.Update{UpdateID: 3}
+	sameTopic.Message.Chat.ID = 123
+	sameTopic.Message.MessageThreadID = 9
+	sameTopic.Message.Text = "654321"
+
+	api := &fakeAPI{polls: [][]telegramapi.Update{nil, {otherTopic}, {sameTopic}}}
+	if err := RunChallenge(context.Background(), api, "123", "654321", time.Second, ChallengeOptions{ThreadID: 9}); err != nil {
+		t.Fatalf("RunChallenge() error = %v", err)
+	}
+	if api.sendOpts.MessageThreadID != 9 {
+		t.Fatalf("MessageThreadID = %d, want 9", api.sendOpts.MessageThreadID)
+	}
+	if api.pollIndex != 3 {
+		t.Fatalf("polls = %d, want 3 (other topic ignored)", api.pollIndex)
+	}
+}
@@ -0,0 +1,129 @@
+package telegramwebhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"codex-brainstorming-telegram/internal/telegramapi"
+)
+
+const (
+	SecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+	maxUpdateBody = 1 << 20
+)
+
+// Receiver accepts updates pushed by Telegram to a webhook and serves them
+// back through GetUpdates with the same offset semantics as long polling,
+// so it can stand in for telegramapi.Client.GetUpdates.
+type Receiver struct {
+	secretToken string
+
+	mu      sync.Mutex
+	changed chan struct{}
+	updates []telegramapi.Update
+	seen    map[int64]bool
+}
+
+func NewReceiver(secretToken string) *Receiver {
+	return &Receiver{
+		secretToken: secretToken,
+		changed:     make(chan struct{}),
+		seen:        map[int64]bool{},
+	}
+}
+
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	got := req.Header.Get(SecretTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(got), []byte(r.secretToken)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxUpdateBody))
+	if err != nil {
+		http.Error(w, "read body", http.StatusBadRequest)
+		return
+	}
+	var update telegramapi.Update
+	if err := json.Unmarshal(body, &update); err != nil {
+		http.Error(w, "invalid update", http.StatusBadRequest)
+		return
+	}
+
+	r.push(update)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *Receiver) GetUpdates(ctx context.Context, offset int64, timeoutSec int) ([]telegramapi.Update, error) {
+	timer := time.NewTimer(time.Duration(timeoutSec) * time.Second)
+	defer timer.Stop()
+
+	for {
+		r.mu.Lock()
+		r.confirmLocked(offset)
+		pending := make([]telegramapi.Update, len(r.updates))
+		copy(pending, r.updates)
+		changed := r.changed
+		r.mu.Unlock()
+
+		if len(pending) > 0 || timeoutSec <= 0 {
+			return pending, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			return nil, nil
+		case <-changed:
+		}
+	}
+}
+
+func (r *Receiver) push(update telegramapi.Update) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Telegram redelivers an update until it gets a 2xx, so duplicates are
+	// expected after a slow response.
+	if r.seen[update.UpdateID] {
+		return
+	}
+	r.seen[update.UpdateID] = true
+
+	// Telegram may deliver over several connections at once; keep the
+	// buffer ordered so offsets behave like getUpdates.
+	i := sort.Search(len(r.updates), func(i int) bool {
+		return r.updates[i].UpdateID > update.UpdateID
+	})
+	r.updates = slices.Insert(r.updates, i, update)
+
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+func (r *Receiver) confirmLocked(offset int64) {
+	if offset <= 0 {
+		return
+	}
+	kept := r.updates[:0]
+	for _, u := range r.updates {
+		if u.UpdateID >= offset {
+			kept = append(kept, u)
+		}
+	}
+	r.updates = kept
+}
@@ -0,0 +1,91 @@
+package telegramwebhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postUpdate(t *testing.T, h http.Handler, secret string, body string) int {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body))
+	if secret != "" {
+		req.Header.Set(SecretTokenHeader, secret)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestReceiverRejectsWrongSecret(t *testing.T) {
+	t.Parallel()
+
+	r := NewReceiver("s3cret")
+	if code := postUpdate(t, r, "", `{"update_id":1}`); code != http.StatusForbidden {
+		t.Fatalf("missing secret status = %d, want 403", code)
+	}
+	if code := postUpdate(t, r, "nope", `{"update_id":1}`); code != http.StatusForbidden {
+		t.Fatalf("wrong secret status = %d, want 403", code)
+	}
+
+	updates, err := r.GetUpdates(context.Background(), 0, 0)
+	if err != nil {
+		t.Fatalf("GetUpdates() error = %v", err)
+	}
+	if len(updates) != 0 {
+		t.Fatalf("len(updates) = %d, want 0", len(updates))
+	}
+}
+
+func TestReceiverServesUpdatesWithOffsets(t *testing.T) {
+	t.Parallel()
+
+	r := NewReceiver("s3cret")
+	body := `{"update_id":5,"message":{"message_id":9,"text":"B","chat":{"id":777}}}`
+	if code := postUpdate(t, r, "s3cret", body); code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+	// Redelivery of the same update is ignored.
+	postUpdate(t, r, "s3cret", body)
+
+	updates, err := r.GetUpdates(context.Background(), 0, 0)
+	if err != nil {
+		t.Fatalf("GetUpdates() error = %v", err)
+	}
+	if len(updates) != 1 || updates[0].Message.Text != "B" || updates[0].Message.Chat.ID != 777 {
+		t.Fatalf("updates = %+v", updates)
+	}
+
+	updates, err = r.GetUpdates(context.Background(), 6, 0)
+	if err != nil {
+		t.Fatalf("GetUpdates() error = %v", err)
+	}
+	if len(updates) != 0 {
+		t.Fatalf("len(updates) after confirm = %d, want 0", len(updates))
+	}
+}
+
+func TestReceiverGetUpdatesWaitsForDelivery(t *testing.T) {
+	t.Parallel()
+
+	r := NewReceiver("s3cret")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		postUpdate(t, r, "s3cret", `{"update_id":1,"message":{"text":"A","chat":{"id":1}}}`)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	updates, err := r.GetUpdates(ctx, 0, 5)
+	if err != nil {
+		t.Fatalf("GetUpdates() error = %v", err)
+	}
+	if len(updates) != 1 {
+		t.Fatalf("len(updates) = %d, want 1", len(updates))
+	}
+}
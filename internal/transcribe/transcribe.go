@@ -0,0 +1,50 @@
+// Package transcribe turns voice replies into text. RunPrompt only depends on
+// the Transcriber interface, so the backend can be a local whisper.cpp build,
+// a hosted API, or Fake in tests.
+package transcribe
+
+import (
+	"context"
+	"sync"
+)
+
+// Input is one downloaded voice note or audio file.
+type Input struct {
+	FileName string
+	MimeType string
+	Data     []byte
+}
+
+type Transcriber interface {
+	Transcribe(ctx context.Context, in Input) (string, error)
+}
+
+// Fake returns Transcript (or Err) for every input and records what it was
+// given.
+type Fake struct {
+	Transcript string
+	Err        error
+
+	mu     sync.Mutex
+	inputs []Input
+}
+
+func (f *Fake) Transcribe(_ context.Context, in Input) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.inputs = append(f.inputs, in)
+	if f.Err != nil {
+		return "", f.Err
+	}
+	return f.Transcript, nil
+}
+
+func (f *Fake) Inputs() []Input {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out := make([]Input, len(f.inputs))
+	copy(out, f.inputs)
+	return out
+}
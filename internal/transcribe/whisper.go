@@ -0,0 +1,101 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// WhisperCPP runs a local whisper.cpp CLI (whisper-cli, or main in older
+// builds). whisper.cpp reads 16 kHz mono WAV, so Telegram's OGG/Opus voice
+// notes are converted with ffmpeg first.
+type WhisperCPP struct {
+	// Binary is the whisper.cpp executable.
+	Binary string
+	// Model is the ggml model file passed as -m.
+	Model string
+	// Language is passed as -l; empty means "auto".
+	Language string
+	// FFmpeg converts input to WAV; empty means "ffmpeg" from PATH.
+	FFmpeg string
+}
+
+func (w WhisperCPP) Transcribe(ctx context.Context, in Input) (string, error) {
+	if w.Binary == "" {
+		return "", errors.New("whisper.cpp binary is not configured")
+	}
+	if w.Model == "" {
+		return "", errors.New("whisper.cpp model is not configured")
+	}
+
+	dir, err := os.MkdirTemp("", "telegram-voice-")
+	if err != nil {
+		return "", fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "input"+inputExt(in))
+	if err := os.WriteFile(src, in.Data, 0o600); err != nil {
+		return "", fmt.Errorf("write voice file: %w", err)
+	}
+
+	wav := filepath.Join(dir, "input.wav")
+	ffmpeg := w.FFmpeg
+	if ffmpeg == "" {
+		ffmpeg = "ffmpeg"
+	}
+	if _, err := runCommand(ctx, ffmpeg, "-nostdin", "-loglevel", "error", "-y", "-i", src, "-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", wav); err != nil {
+		return "", fmt.Errorf("convert voice to wav: %w", err)
+	}
+
+	lang := w.Language
+	if lang == "" {
+		lang = "auto"
+	}
+	out, err := runCommand(ctx, w.Binary, "-m", w.Model, "-f", wav, "-l", lang, "-nt", "-np")
+	if err != nil {
+		return "", fmt.Errorf("run whisper.cpp: %w", err)
+	}
+	return strings.Join(strings.Fields(out), " "), nil
+}
+
+func inputExt(in Input) string {
+	if ext := filepath.Ext(in.FileName); ext != "" {
+		return ext
+	}
+	switch in.MimeType {
+	case "audio/mpeg":
+		return ".mp3"
+	case "audio/mp4", "audio/m4a", "audio/x-m4a":
+		return ".m4a"
+	case "audio/wav", "audio/x-wav":
+		return ".wav"
+	default:
+		return ".ogg"
+	}
+}
+
+// runCommand returns stdout; on failure the error carries the end of stderr,
+// which is where both tools explain what went wrong.
+func runCommand(ctx context.Context, name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > 500 {
+			msg = "..." + msg[len(msg)-500:]
+		}
+		if msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,70 @@
+package transcribe
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func writeScript(t *testing.T, dir string, name string, body string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o700); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func TestWhisperCPPConvertsAndTranscribes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts as stand-ins for ffmpeg and whisper.cpp")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	argsLog := filepath.Join(dir, "args.log")
+	// The last ffmpeg argument is the output WAV; whisper receives it via -f.
+	ffmpeg := writeScript(t, dir, "ffmpeg", `for last; do :; done; cp "$6" "$last"`+"\n")
+	whisper := writeScript(t, dir, "whisper-cli", `echo "$@" > `+argsLog+"\n"+`printf '  选 B，\n  理由是风险低  \n'`+"\n")
+
+	w := WhisperCPP{Binary: whisper, Model: "ggml-base.bin", Language: "zh", FFmpeg: ffmpeg}
+	got, err := w.Transcribe(context.Background(), Input{MimeType: "audio/ogg", Data: []byte("OggS")})
+	if err != nil {
+		t.Fatalf("Transcribe() error = %v", err)
+	}
+	if got != "选 B， 理由是风险低" {
+		t.Fatalf("Transcribe() = %q", got)
+	}
+
+	args, err := os.ReadFile(argsLog)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !strings.Contains(string(args), "-m ggml-base.bin") || !strings.Contains(string(args), "-l zh") || !strings.Contains(string(args), "input.wav") {
+		t.Fatalf("whisper args = %q", args)
+	}
+}
+
+func TestWhisperCPPReportsToolErrors(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts as stand-ins for ffmpeg and whisper.cpp")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	ffmpeg := writeScript(t, dir, "ffmpeg", "echo 'Invalid data found when processing input' >&2\nexit 1\n")
+
+	w := WhisperCPP{Binary: "whisper-cli", Model: "m.bin", FFmpeg: ffmpeg}
+	_, err := w.Transcribe(context.Background(), Input{Data: []byte("x")})
+	if err == nil || !strings.Contains(err.Error(), "Invalid data") {
+		t.Fatalf("Transcribe() error = %v, want ffmpeg stderr", err)
+	}
+
+	if _, err := (WhisperCPP{}).Transcribe(context.Background(), Input{}); err == nil {
+		t.Fatal("Transcribe() without binary error = nil")
+	}
+}
@@ -0,0 +1,82 @@
+// Package updatespool keeps Telegram updates that a run acknowledged without
+// using. Confirming an offset deletes updates on Telegram's side, so anything
+// not meant for the current session is appended here for other tools sharing
+// the bot to read. Messages for the session sent between two rounds of one
+// conversation are not spooled; that conversation's next round reads them.
+package updatespool
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"codex-brainstorming-telegram/internal/jsonl"
+	"codex-brainstorming-telegram/internal/telegramapi"
+)
+
+const DefaultFileName = ".telegram-spool.jsonl"
+
+type Entry struct {
+	SpooledAt time.Time          `json:"spooled_at"`
+	Update    telegramapi.Update `json:"update"`
+}
+
+func DefaultPath(envPath string) string {
+	return filepath.Join(filepath.Dir(envPath), DefaultFileName)
+}
+
+// Append adds updates to the spool at path, creating it with mode 0600.
+func Append(path string, updates ...telegramapi.Update) error {
+	if len(updates) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	entries := make([]Entry, 0, len(updates))
+	for _, u := range updates {
+		entries = append(entries, Entry{SpooledAt: now, Update: u})
+	}
+	if err := jsonl.Append(path, entries...); err != nil {
+		return fmt.Errorf("append spool: %w", err)
+	}
+	return nil
+}
+
+// Read returns the spooled entries ordered by update ID. Updates spooled
+// more than once, for example by two sessions behind a broker, are returned
+// once. A missing spool is empty.
+func Read(path string) ([]Entry, error) {
+	all, err := jsonl.Read[Entry](path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read spool: %w", err)
+	}
+
+	seen := map[int64]bool{}
+	var entries []Entry
+	for _, e := range all {
+		if seen[e.Update.UpdateID] {
+			continue
+		}
+		seen[e.Update.UpdateID] = true
+		entries = append(entries, e)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Update.UpdateID < entries[j].Update.UpdateID
+	})
+	return entries, nil
+}
+
+// Clear removes the spool.
+func Clear(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove spool: %w", err)
+	}
+	return nil
+}
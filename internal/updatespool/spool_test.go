@@ -0,0 +1,79 @@
+package updatespool
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"codex-brainstorming-telegram/internal/telegramapi"
+)
+
+func spoolUpdate(id int64, text string) telegramapi.Update {
+	u := telegramapi.Update{UpdateID: id}
+	u.Message.Chat.ID = 456
+	u.Message.Text = text
+	return u
+}
+
+func TestAppendAndReadDeduplicates(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "nested", DefaultFileName)
+	if err := Append(path, spoolUpdate(7, "b"), spoolUpdate(5, "a")); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if err := Append(path, spoolUpdate(7, "b")); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	entries, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].Update.UpdateID != 5 || entries[1].Update.Message.Text != "b" {
+		t.Fatalf("Read() = %+v, want updates 5 and 7 once each", entries)
+	}
+	if entries[0].SpooledAt.IsZero() {
+		t.Fatal("SpooledAt is zero")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("spool mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestReadSkipsTornLineAndMissingFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if entries, err := Read(filepath.Join(dir, "missing.jsonl")); err != nil || entries != nil {
+		t.Fatalf("Read(missing) = %v, %v; want empty", entries, err)
+	}
+
+	path := filepath.Join(dir, DefaultFileName)
+	if err := Append(path, spoolUpdate(1, "kept")); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	f.WriteString(`{"update":{"update_id":2,`)
+	f.Close()
+
+	entries, err := Read(path)
+	if err != nil || len(entries) != 1 || entries[0].Update.Message.Text != "kept" {
+		t.Fatalf("Read() = %+v, %v; want only the complete entry", entries, err)
+	}
+
+	if err := Clear(path); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	if err := Clear(path); err != nil {
+		t.Fatalf("Clear() twice error = %v", err)
+	}
+}